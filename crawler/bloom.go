@@ -0,0 +1,74 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// BloomVisitedSet is a VisitedSet kept in a Bloom filter, for crawls
+// of more urls than would fit in memory as a MemoryVisitedSet. It
+// takes a fixed amount of memory, fewer than two bytes a url at a 1%
+// false positive rate, but in exchange it may report a url it hasn't
+// seen as already added: the crawl then skips it. It never forgets a
+// url that was added.
+type BloomVisitedSet struct {
+	mu     sync.Mutex
+	bits   []uint64
+	hashes int // bits set per url
+	seeds  [2]maphash.Seed
+	n      int
+}
+
+// NewBloomVisitedSet returns an empty BloomVisitedSet sized for about
+// n urls, which wrongly reports a url as added with probability
+// falsePositive once it holds that many. The rate rises if it holds
+// more. A falsePositive not strictly between 0 and 1 is taken as 1%.
+func NewBloomVisitedSet(n int, falsePositive float64) *BloomVisitedSet {
+	n = max(n, 1)
+	if falsePositive <= 0 || falsePositive >= 1 {
+		falsePositive = 0.01
+	}
+	// the usual optimum: m = -n ln p / (ln 2)^2 bits, k = m/n ln 2
+	m := math.Ceil(-float64(n) * math.Log(falsePositive) / (math.Ln2 * math.Ln2))
+	m = max(m, 64)
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	return &BloomVisitedSet{
+		bits:   make([]uint64, (int(m)+63)/64),
+		hashes: max(k, 1),
+		seeds:  [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+}
+
+// Add implements VisitedSet. It reports false for a url that was
+// added before, and also, rarely, for one that wasn't.
+func (s *BloomVisitedSet) Add(url string) (added bool) {
+	// k bit positions from two hashes, as Kirsch and Mitzenmacher
+	// show is as good as k independent ones
+	h1 := maphash.String(s.seeds[0], url)
+	h2 := maphash.String(s.seeds[1], url) | 1
+	m := uint64(len(s.bits)) * 64
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < s.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		w, mask := bit/64, uint64(1)<<(bit%64)
+		if s.bits[w]&mask == 0 {
+			s.bits[w] |= mask
+			added = true
+		}
+	}
+	if added {
+		s.n++
+	}
+	return added
+}
+
+// Len implements VisitedSet. It counts the urls added, so it is low
+// by the urls wrongly reported as already added.
+func (s *BloomVisitedSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestBloomVisitedSet(t *testing.T) {
+	tests := []struct {
+		n             int
+		falsePositive float64
+	}{
+		{1000, 0.01},
+		{1000, 0.001},
+		{10000, 0.05},
+		{100, 0}, // taken as 1%
+	}
+	for _, tt := range tests {
+		s := NewBloomVisitedSet(tt.n, tt.falsePositive)
+		size := len(s.bits)
+
+		// at twice the planned size, still everything added is present
+		for i := range 2 * tt.n {
+			s.Add(fmt.Sprintf("http://example.com/%d", i))
+		}
+		for i := range 2 * tt.n {
+			if u := fmt.Sprintf("http://example.com/%d", i); s.Add(u) {
+				t.Fatalf("NewBloomVisitedSet(%d, %v): %s was added but isn't present", tt.n, tt.falsePositive, u)
+			}
+		}
+		if len(s.bits) != size {
+			t.Errorf("NewBloomVisitedSet(%d, %v): grew from %d to %d words", tt.n, tt.falsePositive, size, len(s.bits))
+		}
+		// fewer than two bytes a url
+		if size*8 > 2*tt.n+8 {
+			t.Errorf("NewBloomVisitedSet(%d, %v) takes %d bytes", tt.n, tt.falsePositive, size*8)
+		}
+
+		// the false positive rate, probed with urls never added to a set
+		// holding n
+		s = NewBloomVisitedSet(tt.n, tt.falsePositive)
+		for i := range tt.n {
+			s.Add(fmt.Sprintf("http://example.com/%d", i))
+		}
+		const probes = 10000
+		var present int
+		full := slices.Clone(s.bits)
+		for i := range probes {
+			if !s.Add(fmt.Sprintf("http://example.org/%d", i)) {
+				present++
+			}
+			copy(s.bits, full) // so the probes don't fill it further
+		}
+		if p := cmp.Or(tt.falsePositive, 0.01); float64(present)/probes > 3*p {
+			t.Errorf("NewBloomVisitedSet(%d, %v): %d of %d new urls reported as present", tt.n, tt.falsePositive, present, probes)
+		}
+	}
+}
+
+func TestBloomVisitedSetCrawl(t *testing.T) {
+	want := []string{
+		"https://golang.org/", "https://golang.org/pkg/",
+		"https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+	}
+	for range 10 {
+		f := crawltest.NewFakeFetcher(rawDataGraph())
+		results, _ := CrawlErrors(context.Background(), "https://golang.org/", 4, f, WithVisited(NewBloomVisitedSet(100, 0.01)))
+		var got []string
+		for _, r := range results {
+			got = append(got, r.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Fatalf("crawled %q with a BloomVisitedSet, want %q", got, want)
+		}
+		if n := totalFetches(f, rawDataGraph()); n != 4 {
+			t.Errorf("made %d fetches, want each page once", n)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// BodyCache is a cache of fetched pages, bodies and links, keyed by
+// normalized url and holding at most a fixed number of pages. When it
+// is full, adding a page evicts the one least recently used. It is
+// safe for concurrent use.
+//
+// Unlike a VisitedSet, which stops a url from being crawled again, a
+// BodyCache lets a url be crawled again without fetching it.
+type BodyCache struct {
+	max int
+
+	mu    sync.Mutex
+	order *list.List               // of *bodyCacheEntry, most recent first
+	pages map[string]*list.Element // by key
+}
+
+type bodyCacheEntry struct {
+	key  string
+	page Page
+}
+
+// NewBodyCache returns an empty cache holding up to maxEntries pages.
+func NewBodyCache(maxEntries int) *BodyCache {
+	return &BodyCache{
+		max:   maxEntries,
+		order: list.New(),
+		pages: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the page cached for url, if there is one, and marks it
+// as the most recently used.
+func (c *BodyCache) Get(url string) (*Page, bool) {
+	key := NormalizedKey(url)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.pages[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	p := e.Value.(*bodyCacheEntry).page
+	return &p, true
+}
+
+// Put caches page as the page of url, evicting the least recently
+// used page if the cache is full.
+func (c *BodyCache) Put(url string, page *Page) {
+	if c.max <= 0 {
+		return
+	}
+	key := NormalizedKey(url)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.pages[key]; ok {
+		e.Value.(*bodyCacheEntry).page = *page
+		c.order.MoveToFront(e)
+		return
+	}
+	c.pages[key] = c.order.PushFront(&bodyCacheEntry{key, *page})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.pages, oldest.Value.(*bodyCacheEntry).key)
+	}
+}
+
+// Len returns the number of pages in the cache.
+func (c *BodyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
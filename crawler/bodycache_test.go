@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestBodyCacheEviction(t *testing.T) {
+	const root = "http://example.com/"
+	tests := []struct {
+		name string
+		ops  func(c *BodyCache)
+		want []string // cached urls
+		gone []string // evicted urls
+	}{
+		{"oldest evicted", func(c *BodyCache) {
+			c.Put(root+"a", &Page{Body: "a"})
+			c.Put(root+"b", &Page{Body: "b"})
+			c.Put(root+"c", &Page{Body: "c"})
+		}, []string{"b", "c"}, []string{"a"}},
+		{"get marks used", func(c *BodyCache) {
+			c.Put(root+"a", &Page{Body: "a"})
+			c.Put(root+"b", &Page{Body: "b"})
+			c.Get(root + "a")
+			c.Put(root+"c", &Page{Body: "c"})
+		}, []string{"a", "c"}, []string{"b"}},
+		{"put again marks used", func(c *BodyCache) {
+			c.Put(root+"a", &Page{Body: "old"})
+			c.Put(root+"b", &Page{Body: "b"})
+			c.Put(root+"a", &Page{Body: "a"})
+			c.Put(root+"c", &Page{Body: "c"})
+		}, []string{"a", "c"}, []string{"b"}},
+		{"normalized keys", func(c *BodyCache) {
+			c.Put(root+"a", &Page{Body: "old"})
+			c.Put("HTTP://EXAMPLE.com:80/a#top", &Page{Body: "a"})
+			c.Put(root+"b", &Page{Body: "b"})
+		}, []string{"a", "b"}, nil},
+	}
+	for _, tt := range tests {
+		c := NewBodyCache(2)
+		tt.ops(c)
+		if c.Len() != len(tt.want) {
+			t.Errorf("%s: Len = %d, want %d", tt.name, c.Len(), len(tt.want))
+		}
+		for _, u := range tt.want {
+			if p, ok := c.Get(root + u); !ok || p.Body != u {
+				t.Errorf("%s: Get(%s) = %v, %v; want body %q", tt.name, u, p, ok, u)
+			}
+		}
+		for _, u := range tt.gone {
+			if _, ok := c.Get(root + u); ok {
+				t.Errorf("%s: %s still cached", tt.name, u)
+			}
+		}
+	}
+}
+
+func TestBodyCacheCrawl(t *testing.T) {
+	graph := rawDataGraph()
+	cache := NewBodyCache(10)
+	for i, want := range []int{4, 0} {
+		f := crawltest.NewFakeFetcher(graph)
+		pages, _ := CrawlErrors(context.Background(), "https://golang.org/", 4, f, WithBodyCache(cache))
+		if n := totalFetches(f, graph); n != want || len(pages) != 4 {
+			t.Errorf("crawl %d: %d fetches, %d pages; want %d and 4", i+1, n, len(pages), want)
+		}
+		for _, p := range pages {
+			if p.Body != crawltest.Body(p.URL) {
+				t.Errorf("crawl %d: %s has body %q", i+1, p.URL, p.Body)
+			}
+		}
+	}
+}
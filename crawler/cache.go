@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PersistentCache is a VisitedSet kept in a file, so that a crawl
+// run again with the same cache skips the urls an earlier run
+// admitted. The file holds one url per line; each url added is
+// appended to it straight away.
+//
+// Urls are recorded when the crawl admits them, before they are
+// fetched, so a crawl that was interrupted will not fetch the urls
+// it had queued when run again.
+type PersistentCache struct {
+	mu   sync.Mutex
+	urls MemoryVisitedSet
+	file *os.File
+	err  error // first error writing to file
+}
+
+// OpenPersistentCache opens the cache stored in the file name,
+// creating it if it doesn't exist, and loads the urls already in it.
+func OpenPersistentCache(name string) (*PersistentCache, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &PersistentCache{file: file}
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		if url := strings.TrimSpace(sc.Text()); url != "" {
+			c.urls.Add(url)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("loading cache %s: %w", name, err)
+	}
+	return c, nil
+}
+
+// Add implements VisitedSet. A url that can't be written to the
+// file is still added; the error is reported by Close.
+func (c *PersistentCache) Add(url string) (added bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.urls.Add(url) {
+		return false
+	}
+	if c.err == nil {
+		_, c.err = c.file.WriteString(url + "\n")
+	}
+	return true
+}
+
+// Len implements VisitedSet.
+func (c *PersistentCache) Len() int {
+	return c.urls.Len()
+}
+
+// Close closes the cache's file. It returns the first error met
+// writing to the file, if there was one.
+func (c *PersistentCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.file.Close()
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestPersistentCache(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "visited")
+	crawl := func() *crawltest.FakeFetcher {
+		t.Helper()
+		cache, err := OpenPersistentCache(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := crawltest.NewFakeFetcher(rawDataGraph())
+		// the error is for golang.org/cmd/, which is not found
+		CrawlErrors(context.Background(), "https://golang.org/", 4, f, WithVisited(cache))
+		if err := cache.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	f := crawl()
+	if n := totalFetches(f, rawDataGraph()) + f.Fetches("https://golang.org/cmd/"); n != 5 {
+		t.Errorf("first crawl made %d fetches, want 5", n)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := strings.Fields(string(b))
+	slices.Sort(urls)
+	want := []string{
+		"https://golang.org/", "https://golang.org/cmd", "https://golang.org/pkg",
+		"https://golang.org/pkg/fmt", "https://golang.org/pkg/os",
+	}
+	if !slices.Equal(urls, want) {
+		t.Errorf("cache file holds %q, want %q", urls, want)
+	}
+
+	// run again, the crawl has nothing left to fetch
+	f = crawl()
+	if n := totalFetches(f, rawDataGraph()) + f.Fetches("https://golang.org/cmd/"); n != 0 {
+		t.Errorf("second crawl made %d fetches, want none", n)
+	}
+	if b2, _ := os.ReadFile(name); string(b2) != string(b) {
+		t.Errorf("second crawl changed the cache file:\n%s", b2)
+	}
+}
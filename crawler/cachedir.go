@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotCached is returned, wrapped with the url, by a ReplayOnly
+// CacheDirFetcher asked for a page it has no copy of.
+var ErrNotCached = errors.New("not cached")
+
+// CacheDirFetcher is a Fetcher that keeps a copy of every page it
+// fetches in a directory, and fetches those pages from there ever
+// after, so that a crawl can be recorded once and replayed offline
+// and the same each time, for tests or for analysing it again. It
+// wraps another Fetcher, which it uses for pages it has no copy of.
+// Pages answered with an HTTP error status are kept too, with the
+// status, so that replaying them fails the same way; other failures
+// are not kept. Each page is kept in its own file, named for a hash
+// of its url. It is safe for concurrent use, and several fetchers may
+// share a directory.
+//
+// Its exported fields may be changed after NewCacheDirFetcher
+// returns, but not once it has started fetching.
+type CacheDirFetcher struct {
+	fetcher Fetcher
+	dir     string
+
+	// ReplayOnly has the fetcher never use the fetcher it wraps,
+	// which may then be nil: a page it has no copy of fails with an
+	// error wrapping ErrNotCached.
+	ReplayOnly bool
+}
+
+// NewCacheDirFetcher returns a CacheDirFetcher that wraps fetcher and
+// keeps its pages in dir, which must exist.
+func NewCacheDirFetcher(fetcher Fetcher, dir string) *CacheDirFetcher {
+	return &CacheDirFetcher{fetcher: fetcher, dir: dir}
+}
+
+func (f *CacheDirFetcher) wrapped() Fetcher {
+	return f.fetcher
+}
+
+// cachedPage is the form a page is kept in by a CacheDirFetcher.
+type cachedPage struct {
+	URL    string // fetched, for anyone reading the files
+	Page   Page
+	Status int `json:",omitempty"` // of a *StatusError the fetch returned
+}
+
+// Fetch implements Fetcher.
+func (f *CacheDirFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, url))
+}
+
+// FetchPage implements PageFetcher. It fails if a copy of the page
+// can't be read or written, rather than carry on without one.
+func (f *CacheDirFetcher) FetchPage(ctx context.Context, url string) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name := f.file(url)
+	if b, err := os.ReadFile(name); err == nil {
+		var c cachedPage
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("cached page %s: %w", name, err)
+		}
+		if c.Status != 0 {
+			return &c.Page, &StatusError{URL: url, StatusCode: c.Status}
+		}
+		return &c.Page, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if f.ReplayOnly {
+		return nil, fmt.Errorf("%w: %s", ErrNotCached, url)
+	}
+
+	page, err := fetchPage(ctx, f.fetcher, url)
+	var se *StatusError
+	if err != nil && !(errors.As(err, &se) && page != nil) {
+		return page, err
+	}
+	c := cachedPage{URL: url}
+	if page != nil {
+		c.Page = *page
+		c.Page.TTFB, c.Page.Timing = 0, nil // it won't take that long again
+	}
+	if se != nil {
+		c.Status = se.StatusCode
+	}
+	if werr := f.write(name, c); werr != nil {
+		return nil, werr
+	}
+	return page, err
+}
+
+// file returns the name of the file the page of url is kept in.
+func (f *CacheDirFetcher) file(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// write keeps c in the file name, writing it under another name first
+// so that no one reads it half written.
+func (f *CacheDirFetcher) write(name string, c cachedPage) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".page-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
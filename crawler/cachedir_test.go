@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestCacheDirFetcher(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/a">a</a> <a href="/gone">gone</a>`)
+		case "/a":
+			fmt.Fprint(w, `<a href="/">home</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		seed string
+		f    Fetcher
+		stop func() // takes the network away
+		// the pages failing to replay as not cached, though they
+		// failed when recorded
+		notCached []string
+	}{
+		// the fake's not found isn't an HTTP status, so isn't kept
+		{"rawData", "https://golang.org/", crawltest.NewFakeFetcher(rawDataGraph()), func() {}, []string{"https://golang.org/cmd/"}},
+		// a 404 is kept and replayed
+		{"http", srv.URL + "/", NewHTTPFetcher(srv.Client()), srv.Close, nil},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		crawl := func(f Fetcher) map[string]string {
+			results, err := Crawl(context.Background(), tt.seed, CrawlConfig{Fetcher: f, Depth: Unlimited})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages := make(map[string]string)
+			for r := range results {
+				pages[r.URL] = fmt.Sprintf("%q %q", r.Body, r.Links)
+				if r.Err != nil {
+					pages[r.URL] = "error: " + r.Err.Error()
+				}
+			}
+			return pages
+		}
+		recorded := crawl(NewCacheDirFetcher(tt.f, dir))
+		if files, err := os.ReadDir(dir); err != nil || len(files) != len(recorded)-len(tt.notCached) {
+			t.Errorf("%s: recorded %d pages in %d files, %v", tt.name, len(recorded), len(files), err)
+		}
+		tt.stop()
+
+		replay := NewCacheDirFetcher(nil, dir)
+		replay.ReplayOnly = true
+		replayed := crawl(replay)
+		for _, u := range tt.notCached {
+			if _, err := replay.FetchPage(context.Background(), u); !errors.Is(err, ErrNotCached) {
+				t.Errorf("%s: replaying %s: %v, want ErrNotCached", tt.name, u, err)
+			}
+			delete(recorded, u)
+			delete(replayed, u)
+		}
+		if !reflect.DeepEqual(replayed, recorded) {
+			t.Errorf("%s: replayed\n%v\nwant the recording\n%v", tt.name, replayed, recorded)
+		}
+
+		// without ReplayOnly, what isn't cached is fetched and kept
+		if len(tt.notCached) == 0 {
+			continue
+		}
+		fake := crawltest.NewFakeFetcher(map[string][]string{tt.notCached[0]: nil})
+		f := NewCacheDirFetcher(fake, dir)
+		for range 2 {
+			if _, err := f.FetchPage(context.Background(), tt.notCached[0]); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+		}
+		if n := fake.Fetches(tt.notCached[0]); n != 1 {
+			t.Errorf("%s: %s fetched %d times, want once and then from the cache", tt.name, tt.notCached[0], n)
+		}
+	}
+}
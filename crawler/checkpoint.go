@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// checkpoint is the JSON form of a crawl saved by Checkpoint.
+type checkpoint struct {
+	Seeds     []string         `json:"seeds"`
+	Queue     []checkpointTask `json:"queue"`
+	Visited   []string         `json:"visited,omitempty"`
+	Pages     int64            `json:"pages"`                // for MaxPages
+	HostPages map[string]int   `json:"host_pages,omitempty"` // for PerHostPageLimit
+	Stats     CrawlStats       `json:"stats"`
+	Hosts     []string         `json:"hosts,omitempty"` // for Crawler.Hosts
+}
+
+// checkpointTask is the JSON form of a queued crawlTask.
+type checkpointTask struct {
+	URL    string   `json:"url"`
+	Parent string   `json:"parent,omitempty"`
+	Depth  int      `json:"depth"`
+	Level  int      `json:"level"`
+	Path   []string `json:"path"`
+	Asset  bool     `json:"asset,omitempty"`
+}
+
+// Checkpoint writes the state of a running crawl to w, for
+// LoadCheckpoint to carry on from later: the urls waiting to be
+// fetched, those already visited and the stats so far. It holds the
+// crawl still meanwhile, waiting for the fetches in progress to
+// finish and their results to be read. Checkpoint must be called
+// before the crawl's context is cancelled, since cancelling the crawl
+// drops what is queued.
+//
+// The visited urls are only written if the crawl's VisitedSet is a
+// MemoryVisitedSet. Any other set, such as a PersistentCache, is
+// expected to keep them itself, and must be passed to LoadCheckpoint.
+func (cr *Crawler) Checkpoint(w io.Writer) error {
+	if !cr.started.Load() {
+		return errors.New("crawl: checkpoint of a crawl not started")
+	}
+	r := cr.run
+	cp := checkpoint{Seeds: cr.seeds, Queue: []checkpointTask{}}
+	ok := r.q.freeze(func(tasks []crawlTask) {
+		for _, t := range tasks {
+			cp.Queue = append(cp.Queue, checkpointTask{
+				URL:    t.url,
+				Parent: t.parent,
+				Depth:  t.depth,
+				Level:  t.level,
+				Path:   t.route.urls(),
+				Asset:  t.asset,
+			})
+		}
+		if m, ok := r.visited.(*MemoryVisitedSet); ok {
+			cp.Visited = m.keys()
+		}
+		cp.Pages = r.pages.Load()
+		cp.HostPages = r.host.counts()
+		cp.Stats = r.counters.stats()
+		cp.Hosts = r.counters.hostList()
+	})
+	if !ok {
+		return errors.New("crawl: checkpoint of a cancelled crawl")
+	}
+	return json.NewEncoder(w).Encode(cp)
+}
+
+// LoadCheckpoint reads a crawl written by Checkpoint from r and
+// returns it ready to Start, or Run, from where it was saved. Only
+// the state of the crawl is saved, so the Fetcher, limits and hooks
+// have to come from cfg, which would usually be the configuration
+// the crawl was started with. Stats on resuming count the pages
+// crawled before the checkpoint as well as after.
+func LoadCheckpoint(r io.Reader, cfg CrawlConfig) (*Crawler, error) {
+	var cp checkpoint
+	if err := json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("crawl: checkpoint: %w", err)
+	}
+	cr, err := NewCrawler(cp.Seeds, cfg)
+	if err != nil {
+		return nil, err
+	}
+	run := cr.run
+	for _, key := range cp.Visited {
+		run.visited.Add(key)
+	}
+	run.pages.Store(cp.Pages)
+	run.host.restore(cp.HostPages)
+	run.counters.restore(cp.Stats)
+	run.counters.restoreHosts(cp.Hosts)
+	run.resumed = true
+	for _, t := range cp.Queue {
+		rt := newRoute(t.Path)
+		if rt == nil {
+			rt = &route{url: t.URL}
+		}
+		run.resume = append(run.resume, crawlTask{
+			url:    t.URL,
+			parent: t.Parent,
+			depth:  t.Depth,
+			level:  t.Level,
+			route:  rt,
+			asset:  t.Asset,
+		})
+	}
+	return cr, nil
+}
+
+// ExtendCrawl carries on a finished crawl whose results are prior,
+// configured by cfg, additionalDepth links further than its depth
+// limit took it. It follows the links of the DepthLimited pages of
+// prior, as if the crawl had been deeper, without fetching any of the
+// pages of prior again, and sends the results of the pages it
+// fetches. The results of prior and ExtendCrawl together are those
+// of the deeper crawl, but for its order. The crawl's scope comes
+// from the seeds of prior, and cfg.Depth is ignored. An error is
+// returned, and nothing crawled, if additionalDepth is not positive
+// or Unlimited, prior has no results, or the crawl would fail to
+// start.
+func ExtendCrawl(ctx context.Context, prior []CrawlResult, additionalDepth int, cfg CrawlConfig) (<-chan CrawlResult, error) {
+	if additionalDepth <= 0 && additionalDepth != Unlimited {
+		return nil, fmt.Errorf("crawl: invalid additional depth %d", additionalDepth)
+	}
+	var seeds []string
+	for _, res := range prior {
+		if res.ParentURL == "" && !slices.Contains(seeds, res.URL) {
+			seeds = append(seeds, res.URL)
+		}
+	}
+	cfg.Depth = additionalDepth
+	cr, err := NewCrawler(seeds, cfg)
+	if err != nil {
+		return nil, err
+	}
+	run := cr.run
+	for _, res := range prior {
+		run.visited.Add(run.key(res.URL))
+		for _, u := range res.RedirectChain {
+			run.visited.Add(run.key(u))
+		}
+	}
+	depth := additionalDepth
+	if depth != Unlimited {
+		depth-- // the links are one level down
+	}
+	for _, res := range prior {
+		if !res.DepthLimited {
+			continue
+		}
+		from, level := newRoute(res.Path), len(res.Path)
+		if from == nil {
+			from, level = &route{url: res.URL}, 1
+		}
+		for _, l := range uniqueURLs(res.Links, run.key) {
+			depth := run.externalDepth(l.url, run.relativeDepth(l.url, depth))
+			if run.admit(l.url, l.key, res.URL, depth) {
+				run.resume = append(run.resume, crawlTask{
+					url:    l.url,
+					parent: res.URL,
+					depth:  depth,
+					level:  level,
+					route:  &route{l.url, from},
+				})
+			}
+		}
+	}
+	run.resumed = true
+	return cr.Start(ctx)
+}
+
+// newRoute returns the route of the urls of a result's Path, or nil if
+// there are none.
+func newRoute(urls []string) *route {
+	var rt *route
+	for _, u := range urls {
+		rt = &route{u, rt}
+	}
+	return rt
+}
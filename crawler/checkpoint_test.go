@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// resultLines describes results one per line, sorted, for comparing
+// crawls regardless of their order.
+func resultLines(results []CrawlResult) []string {
+	var lines []string
+	for _, r := range results {
+		lines = append(lines, fmt.Sprintf("%s depth %d from %q via %q, %v", r.URL, r.Depth, r.ParentURL, r.Path, r.Err))
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func TestCheckpoint(t *testing.T) {
+	const seed = "http://example.com/p0"
+	graph := syntheticGraph(100, 3)
+	config := func(f Fetcher, stats *CrawlStats) CrawlConfig {
+		return CrawlConfig{Fetcher: f, Depth: Unlimited, Sequential: true, Stats: stats}
+	}
+
+	var want []CrawlResult
+	results, err := Crawl(context.Background(), seed, config(crawltest.NewFakeFetcher(graph), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range results {
+		want = append(want, r)
+	}
+
+	// crawl part of the site, then checkpoint it and stop
+	f := crawltest.NewFakeFetcher(graph)
+	cr, err := NewCrawler([]string{seed}, config(f, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := cr.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var got []CrawlResult
+	partway := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range c {
+			mu.Lock()
+			if got = append(got, r); len(got) == 20 {
+				close(partway)
+			}
+			mu.Unlock()
+		}
+	}()
+	<-partway
+	cr.Pause()
+	var buf bytes.Buffer
+	if err := cr.Checkpoint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	<-done
+	if len(got) < 20 || len(got) >= len(graph) {
+		t.Fatalf("checkpointed after %d pages, want part of the site", len(got))
+	}
+
+	// carry on from the checkpoint
+	var stats CrawlStats
+	f2 := crawltest.NewFakeFetcher(graph)
+	cr, err = LoadCheckpoint(strings.NewReader(buf.String()), config(f2, &stats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = cr.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range c {
+		got = append(got, r)
+	}
+
+	if g, w := resultLines(got), resultLines(want); !slices.Equal(g, w) {
+		t.Errorf("resumed crawl gave\n%s\nwant\n%s", strings.Join(g, "\n"), strings.Join(w, "\n"))
+	}
+	for u := range graph {
+		if n := f.Fetches(u) + f2.Fetches(u); n != 1 {
+			t.Errorf("%s fetched %d times across the checkpoint, want once", u, n)
+		}
+	}
+	if stats.PagesFetched != len(graph) {
+		t.Errorf("resumed stats count %d pages, want all %d", stats.PagesFetched, len(graph))
+	}
+
+	if _, err := LoadCheckpoint(strings.NewReader("{"), config(f2, nil)); err == nil {
+		t.Error("LoadCheckpoint of a truncated checkpoint succeeded")
+	}
+}
+
+func TestExtendCrawl(t *testing.T) {
+	crawl := func(t *testing.T, c <-chan CrawlResult, err error) []CrawlResult {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []CrawlResult
+		for r := range c {
+			results = append(results, r)
+		}
+		return results
+	}
+	tests := []struct {
+		name         string
+		seed         string
+		graph        map[string][]string
+		depth, extra int
+	}{
+		{"rawData, 1 then 1", "https://golang.org/", rawDataGraph(), 1, 1},
+		{"rawData, 1 then 2", "https://golang.org/", rawDataGraph(), 1, 2},
+		{"rawData, the seed then unlimited", "https://golang.org/", rawDataGraph(), 0, Unlimited},
+		{"synthetic, 2 then 3", "http://example.com/p0", syntheticGraph(200, 2), 2, 3},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(tt.graph)
+		cfg := CrawlConfig{Fetcher: f, Depth: tt.depth, Sequential: true}
+		c, err := Crawl(context.Background(), tt.seed, cfg)
+		prior := crawl(t, c, err)
+		c, err = ExtendCrawl(context.Background(), prior, tt.extra, cfg)
+		extended := crawl(t, c, err)
+
+		deeper := tt.depth + tt.extra
+		if tt.extra == Unlimited {
+			deeper = Unlimited
+		}
+		direct := crawltest.NewFakeFetcher(tt.graph)
+		c, err = Crawl(context.Background(), tt.seed, CrawlConfig{Fetcher: direct, Depth: deeper, Sequential: true})
+		want := crawl(t, c, err)
+
+		// the depths left differ, the pages and the routes to them don't
+		for _, results := range [][]CrawlResult{prior, extended, want} {
+			for i := range results {
+				results[i].Depth = 0
+			}
+		}
+		if got := resultLines(append(prior, extended...)); !slices.Equal(got, resultLines(want)) {
+			t.Errorf("%s: the crawls together gave\n%s\nwant, as from depth %d,\n%s",
+				tt.name, strings.Join(got, "\n"), deeper, strings.Join(resultLines(want), "\n"))
+		}
+		// no page fetched again
+		for u := range tt.graph {
+			if n, want := f.Fetches(u), direct.Fetches(u); n != want {
+				t.Errorf("%s: %s fetched %d times, want %d", tt.name, u, n, want)
+			}
+		}
+	}
+
+	prior := crawlRawData(t, CrawlConfig{Depth: 1})
+	for _, extra := range []int{0, -2} {
+		if _, err := ExtendCrawl(context.Background(), prior, extra, CrawlConfig{Fetcher: crawltest.NewFakeFetcher(nil)}); err == nil {
+			t.Errorf("ExtendCrawl by %d succeeded", extra)
+		}
+	}
+	if _, err := ExtendCrawl(context.Background(), nil, 1, CrawlConfig{Fetcher: crawltest.NewFakeFetcher(nil)}); err == nil {
+		t.Error("ExtendCrawl of no results succeeded")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+)
+
+// outputFormats are the values of the -output flag. "text" and
+// "ndjson" print each result as it arrives; the others print them all
+// at the end.
+var outputFormats = []string{"text", "ndjson", "json", "tree", "sitemap", "atom", "dot", "report"}
+
+// cliOptions holds the command-line settings of a crawl.
+type cliOptions struct {
+	seed     string
+	depth    int
+	workers  int
+	maxPages int
+	sameHost bool
+	http     bool // fetch over HTTP rather than from rawData
+	proxy    string
+	language string // Accept-Language for -http
+	insecure bool   // skip TLS verification for -http
+	output   string
+	verbose  bool
+}
+
+// parseFlags parses the command-line arguments args, not including
+// the program name. Errors are reported on stderr as well as
+// returned; flag.ErrHelp is returned if -h or -help was asked for.
+func parseFlags(args []string, stderr io.Writer) (cliOptions, error) {
+	var o cliOptions
+	fs := flag.NewFlagSet("crawler", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.seed, "seed", "https://golang.org/", "`url` to start crawling from")
+	fs.IntVar(&o.depth, "depth", DefaultDepth, "how many links deep to crawl from the seed; -1 for no limit")
+	fs.IntVar(&o.workers, "workers", DefaultMaxWorkers, "number of pages to fetch at once")
+	fs.IntVar(&o.maxPages, "max-pages", 0, "stop after fetching this many pages; 0 for no limit")
+	fs.BoolVar(&o.sameHost, "same-host", false, "only follow links to the seed's host")
+	fs.BoolVar(&o.http, "http", false, "fetch pages over HTTP instead of from the built-in fake data")
+	fs.StringVar(&o.proxy, "proxy", "", "with -http, fetch through the proxy at `url`; the default comes from $HTTP_PROXY and $HTTPS_PROXY")
+	fs.StringVar(&o.language, "accept-language", "", "with -http, ask for pages in these `languages`, as an Accept-Language header")
+	fs.BoolVar(&o.insecure, "insecure", false, "with -http, don't verify TLS certificates, for sites with self-signed ones")
+	fs.StringVar(&o.output, "output", "text", "output `format`: text, ndjson, json, tree, sitemap, atom, dot or report")
+	fs.BoolVar(&o.verbose, "v", false, "log each fetch on stderr")
+	if err := fs.Parse(args); err != nil {
+		return cliOptions{}, err
+	}
+
+	switch {
+	case fs.NArg() > 0:
+		return fail(fs, "unexpected argument %q", fs.Arg(0))
+	case !slices.Contains(outputFormats, o.output):
+		return fail(fs, "unknown -output format %q", o.output)
+	case o.depth < Unlimited:
+		return fail(fs, "-depth must be -1 or more")
+	case o.workers <= 0:
+		return fail(fs, "-workers must be positive")
+	case o.maxPages < 0:
+		return fail(fs, "-max-pages must not be negative")
+	}
+	return o, nil
+}
+
+// fail reports a usage error on fs like fs.Parse does, and returns it.
+func fail(fs *flag.FlagSet, format string, args ...any) (cliOptions, error) {
+	err := fmt.Errorf(format, args...)
+	fmt.Fprintln(fs.Output(), err)
+	fs.Usage()
+	return cliOptions{}, err
+}
+
+// crawlConfig returns the crawl configuration o asks for. Logs go
+// to stderr.
+func (o cliOptions) crawlConfig(stderr io.Writer) (CrawlConfig, error) {
+	var f Fetcher = myFetcher{}
+	if o.http {
+		client, err := NewProxyClient(o.proxy)
+		if err != nil {
+			return CrawlConfig{}, err
+		}
+		hf := NewHTTPFetcher(client)
+		hf.AcceptLanguage = o.language
+		if o.insecure {
+			hf.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		f = hf
+	}
+	cfg := CrawlConfig{
+		Fetcher:      f,
+		Depth:        o.depth,
+		MaxWorkers:   o.workers,
+		MaxPages:     o.maxPages,
+		SameHostOnly: o.sameHost,
+		Stats:        new(CrawlStats),
+	}
+	if o.verbose {
+		cfg.Logger = slog.New(slog.NewTextHandler(stderr, nil))
+	}
+	return cfg, nil
+}
+
+// writeResults prints the results of a crawl from seed, received
+// from c, in format. It returns once c is closed, unless writing to w
+// fails. stats are those of the crawl, filled in by the time c is
+// closed.
+func writeResults(w io.Writer, format, seed string, c <-chan CrawlResult, stats *CrawlStats) error {
+	switch format {
+	case "text":
+		for r := range c {
+			var err error
+			if r.Err != nil {
+				_, err = fmt.Fprintln(w, r.Err)
+			} else {
+				_, err = fmt.Fprintf(w, "found: %s %q\n", r.URL, r.Body)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "ndjson":
+		return StreamNDJSON(w, c)
+	}
+
+	var results []CrawlResult
+	for r := range c {
+		results = append(results, r)
+	}
+	switch format {
+	case "json":
+		return WriteJSON(w, results, false)
+	case "tree":
+		PrintTree(w, results, seed)
+		return nil
+	case "sitemap":
+		return WriteSitemap(w, results)
+	case "atom":
+		return WriteFeed(w, results)
+	case "dot":
+		return WriteDOT(w, BuildGraph(results))
+	case "report":
+		WriteReport(w, *stats, results)
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
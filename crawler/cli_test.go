@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestParseFlags(t *testing.T) {
+	defaults := cliOptions{
+		seed:    "https://golang.org/",
+		depth:   DefaultDepth,
+		workers: DefaultMaxWorkers,
+		output:  "text",
+	}
+	tests := []struct {
+		args []string
+		want func(*cliOptions)
+	}{
+		{nil, func(*cliOptions) {}},
+		{[]string{"-seed", "https://example.com/", "-depth", "2", "-workers", "3"}, func(o *cliOptions) {
+			o.seed, o.depth, o.workers = "https://example.com/", 2, 3
+		}},
+		{[]string{"-depth=-1", "-max-pages=50", "-same-host", "-output=json"}, func(o *cliOptions) {
+			o.depth, o.maxPages, o.sameHost, o.output = Unlimited, 50, true, "json"
+		}},
+		{[]string{"-http", "-proxy", "http://proxy:3128", "-accept-language", "fr", "-insecure", "-v"}, func(o *cliOptions) {
+			o.http, o.proxy, o.language, o.insecure, o.verbose = true, "http://proxy:3128", "fr", true, true
+		}},
+	}
+	for _, tt := range tests {
+		got, err := parseFlags(tt.args, io.Discard)
+		want := defaults
+		tt.want(&want)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseFlags(%q) = %+v, %v; want %+v", tt.args, got, err, want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-output", "yaml"},
+		{"-depth", "-2"},
+		{"-workers", "0"},
+		{"-max-pages", "-1"},
+		{"-depth", "x"},
+		{"-nosuchflag"},
+		{"https://example.com/"},
+	} {
+		var stderr bytes.Buffer
+		if _, err := parseFlags(args, &stderr); err == nil || !strings.Contains(stderr.String(), "Usage") {
+			t.Errorf("parseFlags(%q): error %v, stderr %q; want an error and the usage", args, err, stderr.String())
+		}
+	}
+	if _, err := parseFlags([]string{"-h"}, io.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("parseFlags(-h): %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestCrawlConfigFromFlags(t *testing.T) {
+	o, err := parseFlags([]string{"-depth", "2", "-workers", "3", "-max-pages", "9", "-same-host"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := o.crawlConfig(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Fetcher.(myFetcher); !ok {
+		t.Errorf("Fetcher is a %T, want the rawData fetcher", cfg.Fetcher)
+	}
+	if cfg.Depth != 2 || cfg.MaxWorkers != 3 || cfg.MaxPages != 9 || !cfg.SameHostOnly || cfg.Stats == nil {
+		t.Errorf("crawlConfig: %+v", cfg)
+	}
+
+	o.http, o.language = true, "fr"
+	if cfg, err = o.crawlConfig(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if hf, ok := cfg.Fetcher.(*HTTPFetcher); !ok || hf.AcceptLanguage != "fr" {
+		t.Errorf("-http Fetcher is a %T, want an *HTTPFetcher with Accept-Language fr", cfg.Fetcher)
+	}
+}
+
+func TestWriteResultsInterrupted(t *testing.T) {
+	const seed = "http://example.com/p0"
+	for _, format := range []string{"text", "ndjson", "json", "tree", "sitemap", "atom", "dot", "report"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		// as a ^C would, part way through the crawl
+		f := &cancellingFetcher{
+			Fetcher: crawltest.NewFakeFetcher(syntheticGraph(200, 5)),
+			n:       20,
+			cancel:  cancel,
+		}
+		stats := new(CrawlStats)
+		c, err := Crawl(ctx, seed, CrawlConfig{Fetcher: f, Depth: Unlimited, Stats: stats})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		done := make(chan error)
+		go func() { done <- writeResults(&buf, format, seed, c, stats) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%s: %v", format, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: writeResults still running 5s after the crawl was cancelled", format)
+		}
+		cancel()
+
+		if !strings.Contains(buf.String(), seed) && format != "report" {
+			t.Errorf("%s: the output lost the pages crawled before the cancel:\n%s", format, buf.String())
+		}
+		if stats.PagesFetched == 0 || stats.PagesFetched >= 200 {
+			t.Errorf("%s: fetched %d pages, want part of the site", format, stats.PagesFetched)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"time"
+)
+
+// DefaultMaxWorkers is used for a CrawlConfig's MaxWorkers if it is
+// zero.
+const DefaultMaxWorkers = 8
+
+// DefaultDepth is the depth the command crawls to unless told
+// otherwise.
+const DefaultDepth = 3
+
+// Unlimited, as a CrawlConfig's Depth, crawls every page reachable
+// from the seed. Each url is still fetched only once, so cycles in the
+// link graph don't keep the crawl going; set MaxPages as well to bound
+// the crawl of a large site.
+const Unlimited = -1
+
+// DefaultSchemes are the url schemes a crawl follows links to if its
+// CrawlConfig's AllowedSchemes is nil, along with those of its seeds.
+var DefaultSchemes = []string{"http", "https"}
+
+// CrawlConfig configures a crawl. The zero value of every field but
+// Fetcher is usable and gives the default behaviour.
+type CrawlConfig struct {
+	// Fetcher retrieves pages. It is required.
+	Fetcher Fetcher
+
+	// Depth is how many levels of links to follow from the seed:
+	// zero fetches only the seed, 1 the seed and the pages it links
+	// to, and so on. Unlimited follows links until there are no new
+	// pages left.
+	Depth int
+
+	// MaxWorkers is the most fetches run at once. Zero means
+	// DefaultMaxWorkers.
+	MaxWorkers int
+
+	// Concurrency spreads fetches across hosts, limiting those to
+	// any one host and how many hosts are fetched from at once, as
+	// well as MaxWorkers limits them overall. A worker waiting for a
+	// host to be free holds on to its task meanwhile.
+	Concurrency ConcurrencyLimits
+
+	// MaxPages stops the crawl from starting new fetches once this
+	// many pages have been fetched, even if Depth would allow more.
+	// Fetches already in progress are allowed to finish. Zero means
+	// no limit.
+	MaxPages int
+
+	// StableMaxPages has MaxPages, PerHostPageLimit and
+	// HostPageLimits pick the pages they let the crawl fetch by
+	// their urls rather than by which fetch happens to start first,
+	// so that a capped crawl with a deterministic Fetcher fetches
+	// the same pages each time, if not in the same order, however
+	// many workers it has. The crawl goes one level at a time, as a
+	// BreadthFirst one does, fetching the urls of each level
+	// smallest first, or by Priority, and waits for the fetches in
+	// progress before it passes over a url for lack of room, in case
+	// one of them is skipped. Limits on time or bytes still depend
+	// on how the fetches run.
+	StableMaxPages bool
+
+	// PerHostPageLimit stops the crawl from starting new fetches
+	// from a host once this many of its pages have been fetched, so
+	// that one large host can't use up the whole of MaxPages before
+	// the others get a share. Zero means no limit.
+	PerHostPageLimit int
+
+	// HostPageLimits overrides PerHostPageLimit for the hosts it
+	// has, mapping each lower case host name to how many of its
+	// pages may be fetched, for sharing a crawl out by weight. Zero
+	// means no limit for that host.
+	HostPageLimits map[string]int
+
+	// MaxBytes stops the crawl from starting new fetches once the
+	// bodies downloaded add up to this many bytes. Fetches already
+	// in progress are allowed to finish, so the total may end up
+	// somewhat over. Pages from the BodyCache don't count. Zero
+	// means no limit.
+	MaxBytes int64
+
+	// MaxQueueLength, if positive, bounds how many urls may wait to
+	// be fetched, to limit memory use in wide crawls. A worker that
+	// finds links when the queue is full waits for room before
+	// queueing them. If every worker would be left waiting, the
+	// crawl instead goes deeper before it goes wider, which keeps
+	// the queue near the bound. The seeds are always queued. The
+	// bound can't hold for a BreadthFirst or Sequential crawl, whose
+	// order is fixed; it only slows them.
+	MaxQueueLength int
+
+	// MaxLinksPerPage, if positive, is the most links followed from
+	// any one page; the rest are dropped, so that a page of many
+	// thousands of links can't swamp the crawl. Results still list
+	// all the links. Zero means no limit.
+	MaxLinksPerPage int
+
+	// MaxFanOut, if positive, is the most new links any one page
+	// adds to the queue, so that a hub page can't flood it with its
+	// links ahead of everything else; links already crawled or
+	// queued don't count. The rest are dropped, or held back with
+	// DeferFanOut. Results still list all the links. Zero means no
+	// limit.
+	MaxFanOut int
+
+	// DeferFanOut has the links held back by MaxFanOut queued once
+	// every other page has been crawled, rather than dropped, so that
+	// they are crawled if there is room left under MaxPages and the
+	// other limits. Links held back are not saved by Checkpoint.
+	DeferFanOut bool
+
+	// MaxURLLength, if positive, is the longest url followed, in
+	// bytes. Longer links are dropped and counted in the Stats, as a
+	// cheap guard against traps such as urls that grow a session id
+	// or a calendar that goes on for ever. The seeds are always
+	// crawled. Zero means no limit.
+	MaxURLLength int
+
+	// MaxRepeatedSegments, if positive, is the most times one
+	// segment may repeat in a row in the path of a url followed.
+	// Links to urls such as /a/b/b/b/b, which relative links on a
+	// misconfigured site can keep extending, are dropped as likely
+	// traps and counted in the Stats. The seeds are always crawled.
+	// Zero means no limit.
+	MaxRepeatedSegments int
+
+	// MaxPathSegments, if positive, is the most segments the path
+	// of a url followed may have, so 3 allows /a/b/c but not
+	// /a/b/c/d. Links to urls nested deeper are dropped as likely
+	// traps and counted in the Stats. Unlike Depth it measures the
+	// url, not the links followed to reach it. The seeds are always
+	// crawled. Zero means no limit.
+	MaxPathSegments int
+
+	// SameHostOnly restricts the crawl to the seed url's host, or
+	// the seeds' hosts if there are several.
+	SameHostOnly bool
+
+	// PathPrefixOnly restricts the crawl to urls within the path of
+	// a seed on the seed's host, such as those under /pkg/ when the
+	// seed is https://golang.org/pkg/. A path counts as within one
+	// it equals or continues after a slash, so /pkg/fmt is within
+	// /pkg but /pkgsite is not.
+	PathPrefixOnly bool
+
+	// AllowedHosts, if set, restricts the crawl to these hosts,
+	// plus the seed url's host if SameHostOnly is set.
+	AllowedHosts []string
+
+	// ExternalLinkDepth, if positive, lets the crawl go that many
+	// levels off the site SameHostOnly, PathPrefixOnly and
+	// AllowedHosts restrict it to, for checking the links to other
+	// sites as well as its own: 1 fetches the pages the site links
+	// to, to see that they are there, but follows none of their
+	// links, 2 follows their links one level, and so on. The levels
+	// count from the first page off the site, and never go beyond
+	// Depth. Zero keeps the crawl on the site.
+	ExternalLinkDepth int
+
+	// IncludePatterns, if set, restricts the crawl, seeds included,
+	// to urls matching at least one of the patterns, unless
+	// RelativeDepth is set.
+	IncludePatterns []*regexp.Regexp
+
+	// RelativeDepth, if positive, changes what IncludePatterns do:
+	// rather than restricting the crawl, they mark the sections of a
+	// site to crawl further. Any url matching one of them is crawled
+	// with at least RelativeDepth levels of links left to follow from
+	// it, however far it is from the seed, so that a crawl can find a
+	// section, such as /pkg/, within Depth and go on into it. Urls
+	// that match none are crawled to Depth as usual. Zero means the
+	// patterns restrict the crawl.
+	RelativeDepth int
+
+	// ExcludePatterns keeps the crawl from urls matching any of the
+	// patterns, even those IncludePatterns allows. Both sets of
+	// patterns apply on top of the host restrictions.
+	ExcludePatterns []*regexp.Regexp
+
+	// AllowedSchemes lists the url schemes, such as "https", of the
+	// links the crawl follows. Links with other schemes, such as
+	// ftp:, tel: or data: links, are dropped rather than passed to
+	// the Fetcher to fail, and counted by scheme in the Stats.
+	// Schemes match whatever their case. The seeds are always
+	// crawled. Nil means DefaultSchemes and the schemes of the
+	// seeds, so that a crawl of file urls follows links to others.
+	AllowedSchemes []string
+
+	// FollowExtensions, if set, restricts the links the crawl
+	// follows to urls whose paths end in one of these extensions,
+	// such as ".html" or ".php", and urls with no extension, which
+	// are always followed. SkipExtensions keeps the crawl from urls
+	// ending in any of its extensions, such as ".pdf" or ".zip",
+	// even those FollowExtensions allows. Both let a crawl pass over
+	// links that plainly aren't pages without fetching them, as
+	// checking their Content-Type would. Extensions match whatever
+	// their case, with or without the dot. The seeds are always
+	// crawled.
+	FollowExtensions, SkipExtensions []string
+
+	// BreadthFirst crawls one level at a time: every page at one
+	// depth is fetched before any page linked from them.
+	BreadthFirst bool
+
+	// Sequential runs the crawl with one worker, fetching the
+	// queued url that sorts first each time, so that a crawl with
+	// a deterministic Fetcher always gives the same results in the
+	// same order. It is meant for tests and debugging. MaxWorkers is
+	// ignored.
+	Sequential bool
+
+	// Priority, if set, scores each url before it is queued, with
+	// the depth it would be crawled at, and the crawl fetches the
+	// queued url scoring highest first, rather than the oldest, so
+	// that a crawl limited by MaxPages or MaxDuration reaches the
+	// pages that matter most before it runs out. Urls scoring the
+	// same are fetched oldest first, or smallest first in a
+	// Sequential crawl. In a BreadthFirst crawl it orders the urls
+	// of each level. The crawl can't go deeper first to keep within
+	// MaxQueueLength, which then only slows it. It may be called
+	// from many goroutines at once.
+	Priority func(url string, depth int) int
+
+	// BufferSize is the capacity of the results channel, letting
+	// fetches run ahead of a slow consumer. Zero means unbuffered.
+	BufferSize int
+
+	// ShouldFollow, if set, is consulted before following each url,
+	// including the seed, with the depth it would be crawled at.
+	// Urls for which it returns false are neither fetched nor
+	// counted in the stats. It may be called from many goroutines
+	// at once.
+	ShouldFollow func(url string, depth int) bool
+
+	// OnLinkDiscovered, if set, is called for every link found on
+	// every page fetched, in order and repeats included, with the
+	// page's url and remaining depth, before the crawl does
+	// anything else with the link. Links for which it returns false
+	// are dropped, from the page's result as well as the crawl. It
+	// sees links as the fetcher found them, so it is the place to
+	// observe or filter raw links; ShouldFollow sees only those the
+	// crawl would follow. It may be called from many goroutines at
+	// once.
+	OnLinkDiscovered func(from, to string, depth int) (enqueue bool)
+
+	// OnFrontier, if set, hands the crawl's scheduling over to the
+	// caller, for spreading a crawl across machines through a queue
+	// of its own: the links the crawl would follow are passed to
+	// OnFrontier, each once, with the depth to crawl them at, rather
+	// than queued, and the crawl fetches only the seeds and the urls
+	// given to Crawler.Submit. The Assets of pages are still fetched
+	// as usual. The crawl then carries on, waiting for more, until
+	// Crawler.EndSubmit is called and what was submitted has been
+	// crawled, or until it is cancelled, and may have no seeds at
+	// all. It may be called from many goroutines at once.
+	OnFrontier func(url string, depth int)
+
+	// URLRewriter, if set, is applied to the seeds and to each link
+	// found, before anything else is done with them, to make urls
+	// canonical: to strip tracking parameters, say, so that links
+	// differing only in those are fetched once. The Links of results
+	// are the rewritten urls. A url rewritten to "" is dropped. It
+	// may be called from many goroutines at once.
+	URLRewriter func(url string) string
+
+	// IgnoreQueryInDedup has the crawl tell urls apart without their
+	// query strings, for sites whose queries only track visitors:
+	// once /page?a=1 is crawled, /page?b=2 and /page are not. The
+	// query is still sent when the page is fetched. Unlike a
+	// URLRewriter it leaves the urls themselves alone. It is
+	// shorthand for a DedupKey of PathKey, and ignored if DedupKey
+	// is set.
+	IgnoreQueryInDedup bool
+
+	// CollapseIndexFiles has the crawl take a url whose path ends in
+	// one of IndexFiles, such as /dir/index.html, to be the same page
+	// as its directory, /dir/, as it is on most static sites and with
+	// a FileFetcher. It isn't the default since a server may serve
+	// something else for either. Like IgnoreQueryInDedup it leaves
+	// the urls alone, and is ignored if DedupKey is set.
+	CollapseIndexFiles bool
+
+	// DedupKey, if set, gives the key under which each url is
+	// recorded in Visited: urls with the same key are crawled once,
+	// under the first of them found. NormalizedKey and PathKey are
+	// the usual choices. Like URLRewriter it decides which urls are
+	// the same page, but it leaves the urls fetched and reported
+	// alone. Nil means NormalizedKey, or PathKey with
+	// IgnoreQueryInDedup, either changed for CollapseIndexFiles. It
+	// may be called from many goroutines at once.
+	DedupKey func(url string) string
+
+	// SoftNotFoundDetector, if set, is given the body of each page
+	// fetched successfully and reports whether it is really a "not
+	// found" page served with a success status. Such pages are sent
+	// with SoftNotFound set, and their links are not followed. It
+	// may be called from many goroutines at once.
+	SoftNotFoundDetector func(body string) bool
+
+	// FollowSitemaps has the crawl follow the urls listed in the XML
+	// sitemaps it comes across, as well as links. A page is taken
+	// for a sitemap if its Content-Type is XML, or if it has none and
+	// its url ends in .xml, and its body parses as one. Its urls
+	// are crawled at its own depth, as if the page that linked to
+	// the sitemap had linked to them instead. The sitemaps that pages
+	// name in <link rel="sitemap"> tags are followed too. An
+	// HTTPFetcher only reads sitemaps if its ContentTypes include
+	// their type, such as application/xml.
+	FollowSitemaps bool
+
+	// RespectNofollow has the crawl not follow the links a page
+	// marks rel="nofollow", as search engines don't, if the fetcher
+	// reports them, as an HTTPFetcher does. They are still among the
+	// Links of the page's result, and so in the crawl's graph.
+	RespectNofollow bool
+
+	// MaxConsecutiveErrors, if positive, stops the crawl once this
+	// many fetches in a row have failed, as when a site goes down
+	// or starts refusing the crawler, rather than carry on asking.
+	// A successful fetch starts the count again; skips don't count
+	// either way. The crawl's Stats record why it stopped.
+	MaxConsecutiveErrors int
+
+	// AbortOnError stops the crawl at the first fetch that fails,
+	// seed or not, for a check that a site is sound, such as of a
+	// deploy, where carrying on would only find more. Run returns
+	// that fetch's *FetchError. Fetches already in progress are
+	// cancelled. Skips don't count as failures.
+	AbortOnError bool
+
+	// MaxDuration, if positive, stops the crawl once it has run
+	// this long, as if its context had been cancelled. The crawl's
+	// Stats record whether that happened.
+	MaxDuration time.Duration
+
+	// IdleTimeout, if positive, stops the crawl once no fetch has
+	// succeeded for this long, as when a site stops answering part
+	// way through and every worker is left waiting on it. Each page
+	// fetched successfully starts the wait again, and time when the
+	// crawl is paused doesn't count. Fetches in progress are
+	// cancelled, so a Fetcher that ignores its context still holds
+	// the crawl up until it returns. The crawl's Stats record
+	// whether it was stopped this way.
+	IdleTimeout time.Duration
+
+	// DelayByDepth, if set, gives how long to wait before fetching a
+	// page found depth links from a seed, 0 for the seeds, so that
+	// deeper pages can be fetched more slowly and a crawl spend more
+	// of its time and MaxPages on the shallow pages likely to matter
+	// most. The wait holds up the worker but no host limit, and
+	// isn't counted in the FetchDuration. Pages taken from the
+	// BodyCache don't wait. It may be called from many goroutines at
+	// once.
+	DelayByDepth func(depth int) time.Duration
+
+	// DryRun leaves the Body of every result empty, for crawls
+	// that only want to find which pages there are. Pages are still
+	// fetched, to find their links, but each body is dropped once
+	// its links are known, so the results of even a very large
+	// crawl hold no more than its link graph. A BodyCache still
+	// keeps the bodies it is given.
+	DryRun bool
+
+	// CountOnly sends no results at all: the crawl runs as usual,
+	// but only its Stats report on it. The results channel is
+	// closed when it finishes.
+	CountOnly bool
+
+	// Sink, if set, is given the results in place of the results
+	// channel, which is closed unused when the crawl finishes. The
+	// crawl closes the Sink then too. If the Sink fails to write a
+	// result the crawl stops.
+	Sink ResultSink
+
+	// OnFetch, if set, is called after each call of the Fetcher
+	// returns, with the url fetched, its remaining depth and the
+	// error the fetch returned, if any. It is called for skipped
+	// and cancelled fetches as well, so it sees every fetch
+	// attempted. It is called by the worker that did the fetch, so
+	// it may be called from many goroutines at once, and the worker
+	// waits for it before sending the page's result.
+	OnFetch func(url string, depth int, err error)
+
+	// OnDuplicateLink, if set, is called for each link the crawl
+	// doesn't follow because its target was already visited, so
+	// that together with the ParentURL of each result it gives
+	// every link between the pages crawled, not just the ones
+	// that found them. A page linking to a url more than once
+	// gives one call. It may be called from many goroutines at
+	// once.
+	OnDuplicateLink func(e LinkEvent)
+
+	// Metrics, if set, is told of every fetch, as it starts and
+	// as it ends, for monitoring the crawl.
+	Metrics CrawlMetrics
+
+	// BodyCache, if set, is checked for each page before fetching
+	// it, and pages fetched successfully are added to it, so that
+	// crawls sharing a cache fetch each page once while it stays
+	// cached.
+	BodyCache *BodyCache
+
+	// Logger, if set, receives the crawl's log messages: one at
+	// Info level for each page fetched and at Warn level for each
+	// that failed. Nil discards them.
+	Logger *slog.Logger
+
+	// Visited, if set, records the urls the crawl admits, and urls
+	// already in it are not crawled. A crawl given a PersistentCache
+	// skips the pages earlier crawls with the same cache fetched.
+	// Nil means a new, empty MemoryVisitedSet.
+	Visited VisitedSet
+
+	// AlreadySeen lists urls to add to Visited before the crawl
+	// starts, so that they are skipped as if already crawled. A
+	// crawl given the pages a previous one found fetches only the
+	// new ones.
+	AlreadySeen []string
+
+	// Stats, if set, is filled in when the crawl finishes, before
+	// the results channel is closed, and must not be read before.
+	Stats *CrawlStats
+}
+
+// withDefaults returns a copy of cfg with zero fields defaulted.
+func (cfg CrawlConfig) withDefaults() CrawlConfig {
+	if cfg.MaxWorkers <= 0 {
+		cfg.MaxWorkers = DefaultMaxWorkers
+	}
+	if cfg.Sequential {
+		cfg.MaxWorkers = 1
+	}
+	if cfg.StableMaxPages {
+		cfg.BreadthFirst = true
+	}
+	if cfg.Visited == nil {
+		cfg.Visited = new(MemoryVisitedSet)
+	}
+	if cfg.DedupKey == nil {
+		cfg.DedupKey = NormalizedKey
+		if cfg.IgnoreQueryInDedup {
+			cfg.DedupKey = PathKey
+		}
+		if cfg.CollapseIndexFiles {
+			key := cfg.DedupKey
+			cfg.DedupKey = func(url string) string {
+				return withoutIndexFile(key(url))
+			}
+		}
+	}
+	if cfg.BufferSize < 0 {
+		cfg.BufferSize = 0
+	}
+	cfg.Logger = cfg.logger()
+	return cfg
+}
+
+// rewrite returns urls as rewritten by URLRewriter, leaving out
+// those it drops. urls itself is returned if there is no rewriter.
+func (cfg CrawlConfig) rewrite(urls []string) []string {
+	if cfg.URLRewriter == nil {
+		return urls
+	}
+	out := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if u = cfg.URLRewriter(u); u != "" {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// logger returns cfg.Logger, or a logger discarding everything if
+// it is nil.
+func (cfg CrawlConfig) logger() *slog.Logger {
+	if cfg.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return cfg.Logger
+}
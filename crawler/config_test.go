@@ -0,0 +1,82 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestCrawlConfigInvalid(t *testing.T) {
+	f := crawltest.NewFakeFetcher(rawDataGraph())
+	tests := []struct {
+		name string
+		seed string
+		cfg  CrawlConfig
+	}{
+		{"no fetcher", "https://golang.org/", CrawlConfig{}},
+		{"negative depth", "https://golang.org/", CrawlConfig{Fetcher: f, Depth: -2}},
+		{"relative seed", "/pkg/", CrawlConfig{Fetcher: f}},
+		{"bad seed", "https://golang.org/%zz", CrawlConfig{Fetcher: f}},
+	}
+	for _, tt := range tests {
+		if _, err := Crawl(context.Background(), tt.seed, tt.cfg); err == nil {
+			t.Errorf("%s: Crawl succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestCrawlConfigDefaults(t *testing.T) {
+	cfg := CrawlConfig{}.withDefaults()
+	if cfg.MaxWorkers != DefaultMaxWorkers || cfg.Visited == nil || cfg.DedupKey == nil || cfg.Logger == nil {
+		t.Errorf("defaults: %d workers, visited %v, logger %v", cfg.MaxWorkers, cfg.Visited, cfg.Logger)
+	}
+	if cfg := (CrawlConfig{MaxWorkers: 8, Sequential: true}).withDefaults(); cfg.MaxWorkers != 1 {
+		t.Errorf("Sequential crawl has %d workers, want 1", cfg.MaxWorkers)
+	}
+	if cfg := (CrawlConfig{StableMaxPages: true}).withDefaults(); !cfg.BreadthFirst {
+		t.Error("StableMaxPages crawl isn't BreadthFirst")
+	}
+}
+
+func TestCrawlConfigCombinations(t *testing.T) {
+	tests := []struct {
+		name string
+		seed string // if not https://golang.org/
+		cfg  CrawlConfig
+		want []string
+	}{
+		{"zero depth", "", CrawlConfig{Depth: 0}, []string{"https://golang.org/"}},
+		{"depth 1", "", CrawlConfig{Depth: 1}, []string{
+			"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/",
+		}},
+		{"unlimited", "", CrawlConfig{Depth: Unlimited}, []string{
+			"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/",
+			"https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+		{"max pages, breadth-first", "", CrawlConfig{Depth: Unlimited, MaxPages: 3, BreadthFirst: true}, []string{
+			"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/",
+		}},
+		{"path prefix, sequential", "https://golang.org/pkg/", CrawlConfig{Depth: Unlimited, PathPrefixOnly: true, Sequential: true}, []string{
+			"https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+	}
+	for _, tt := range tests {
+		cfg := tt.cfg
+		cfg.Fetcher = crawltest.NewFakeFetcher(rawDataGraph())
+		results, err := Crawl(context.Background(), cmp.Or(tt.seed, "https://golang.org/"), cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for r := range results {
+			got = append(got, r.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,50 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"iter"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Fetcher interface
 type Fetcher interface {
 	// Fetch returns the body of URL and
 	// a slice of URLs found on that page.
-	Fetch(url string) (body string, urls []string, err error)
+	// Implementations should abort and return ctx.Err()
+	// when ctx is cancelled.
+	Fetch(ctx context.Context, url string) (body string, urls []string, err error)
 }
 
+// ErrMaxDuration is returned by CrawlErrors when the crawl was
+// stopped by its MaxDuration.
+var ErrMaxDuration = errors.New("crawl: MaxDuration exceeded")
+
+// ErrIdleTimeout is returned by CrawlErrors when the crawl was stopped
+// by its IdleTimeout.
+var ErrIdleTimeout = errors.New("crawl: IdleTimeout exceeded")
+
+// ErrClosed is returned by Crawler.Run when the crawl was stopped by
+// Crawler.Close.
+var ErrClosed = errors.New("crawl: closed")
+
+// ErrNotFound is returned, wrapped with the url, when a page doesn't
+// exist. Check for it with errors.Is.
+var ErrNotFound = errors.New("not found")
+
 // AlreadyFetchedError is returned when a url has already been
-// fetched or is in progress of being fetched
+// fetched or is in progress of being fetched.
+// Fetchers in this package return it as *AlreadyFetchedError, but
+// the crawler recognizes it by value too.
 type AlreadyFetchedError struct {
 	url string
 }
@@ -22,79 +53,1148 @@ func (e AlreadyFetchedError) Error() string {
 	return fmt.Sprintf("Already fetched %v", e.url)
 }
 
-// Crawl uses fetcher to recursively crawl
-// pages starting with url, to a maximum of depth.
-func Crawl(url string, depth int, fetcher Fetcher, c chan<- fakeResult, wg *sync.WaitGroup) {
-	defer wg.Done()
-	if depth <= 0 {
-		return
+func (e AlreadyFetchedError) skip() {}
+
+// FetcherPanicError is returned, as *FetcherPanicError, when the
+// Fetcher panics while fetching a url. The crawl carries on without
+// that page.
+type FetcherPanicError struct {
+	url       string
+	recovered any // the value passed to panic
+}
+
+func (e FetcherPanicError) Error() string {
+	return fmt.Sprintf("Fetcher panicked fetching %v: %v", e.url, e.recovered)
+}
+
+// CircuitOpenError is given, as *CircuitOpenError, in CrawlStats and
+// by Crawler.Run when a crawl stopped because too many fetches in a
+// row failed. It wraps the error of the last of them.
+type CircuitOpenError struct {
+	failures int
+	last     error
+}
+
+func (e CircuitOpenError) Error() string {
+	return fmt.Sprintf("Stopped after %d fetches in a row failed, the last with: %v", e.failures, e.last)
+}
+
+func (e CircuitOpenError) Unwrap() error {
+	return e.last
+}
+
+// hostCancelledError is returned by crawlRun.fetch for a url whose
+// host was cancelled by Crawler.CancelHost before it was fetched.
+type hostCancelledError struct {
+	url string
+}
+
+func (e hostCancelledError) Error() string {
+	return fmt.Sprintf("Host cancelled %v", e.url)
+}
+
+func (e hostCancelledError) skip() {}
+
+// skipError is implemented by errors meaning that a url was
+// deliberately not fetched, rather than that fetching it failed.
+// The methods have value receivers so that both T and *T match.
+type skipError interface {
+	error
+	skip()
+}
+
+// isSkip reports whether err's chain contains a skipError.
+func isSkip(err error) bool {
+	var s skipError
+	return errors.As(err, &s)
+}
+
+// CrawlResult is a page fetched by a crawl.
+type CrawlResult struct {
+	URL       string
+	Depth     int    // remaining depth when the page was fetched, or Unlimited
+	ParentURL string // page that linked to URL; empty for the seed
+	Body      string
+	Links     []string // urls found on the page
+	Err       error    // non-nil if the fetch failed, as a *FetchError
+
+	// StatusCode is the HTTP status of the response, if the fetcher
+	// reports it. The links of pages with an error status are not
+	// followed.
+	StatusCode int
+
+	// ContentType is the Content-Type of the response, if the
+	// fetcher reports it.
+	ContentType string
+
+	// RedirectChain lists the urls requested on the way to the
+	// page, from URL to the one that served it, if the fetcher
+	// reports that it was redirected.
+	RedirectChain []string
+
+	// Canonical is the url the page gives as its canonical one, if
+	// the fetcher reports it. Pages with the same Canonical are
+	// copies of one page.
+	Canonical string
+
+	// Title and Description are the title of the page and the
+	// description in its metadata, if the fetcher reports them, for
+	// indexing the pages crawled.
+	Title, Description string
+
+	// Assets lists the images, scripts, stylesheets and other
+	// resources the page uses, if the fetcher reports them. They are
+	// fetched as part of the crawl, but not crawled from.
+	Assets []string
+
+	// BodyHash is the SHA-256 of the page's body, in hex, if it was
+	// fetched without error and has one. It is worked out even in a
+	// DryRun, which leaves out the body itself, and kept for a page
+	// the fetcher reports unchanged without its body, as an
+	// HTTPFetcher does. Pages with the same BodyHash are the same
+	// page served at several urls; see DuplicateContent.
+	BodyHash string
+
+	// Unchanged is set, in a crawl begun by CrawlChanged, on a page
+	// whose BodyHash is the one it had before. Its links are not
+	// followed.
+	Unchanged bool
+
+	// StructuredData holds the JSON-LD blocks the page embeds, in
+	// <script type="application/ld+json"> tags, if the fetcher
+	// reports them, for indexing what the pages are about. Each is
+	// valid JSON.
+	StructuredData [][]byte
+
+	// Asset is set on the result of fetching one of the Assets of
+	// another page. Its links are not followed.
+	Asset bool
+
+	// Path lists the urls followed to reach the page, from the seed
+	// to URL itself: the route by which it was first found, and so
+	// one of the shortest in a BreadthFirst crawl.
+	Path []string
+
+	// DepthLimited is set on a page at the crawl's depth limit that
+	// has links, none of which were followed because of the limit.
+	// A deeper crawl would go further from it.
+	DepthLimited bool
+
+	// FetchedAt is when the fetch of the page finished, or it was
+	// taken from the BodyCache.
+	FetchedAt time.Time
+
+	// FetchDuration is how long the fetcher took over the page,
+	// not counting any wait for the host limits. It is zero for
+	// pages taken from the BodyCache.
+	FetchDuration time.Duration
+
+	// TTFB is the time to first byte of the response, if the
+	// fetcher reports it.
+	TTFB time.Duration
+
+	// Timing breaks the fetch down into its phases, if the fetcher
+	// reports that, as an HTTPFetcher with TraceTiming does. It is
+	// nil for pages taken from the BodyCache.
+	Timing *Timing
+
+	// SoftNotFound is set on a page that the crawl's
+	// SoftNotFoundDetector took for a "not found" page, despite its
+	// status. Its links are not followed.
+	SoftNotFound bool
+
+	// Empty is set on a page fetched without error whose body is
+	// empty or only white space and which has no links. Such pages
+	// are sent like any other.
+	Empty bool
+}
+
+// LinkEvent is a link from one page to another.
+type LinkEvent struct {
+	From, To string
+}
+
+// FetchError records a failed fetch of URL, and where in the crawl
+// it was.
+type FetchError struct {
+	URL    string
+	Depth  int    // links followed from a seed to reach URL
+	Parent string // page that linked to URL; empty for a seed
+	Err    error
+}
+
+func (e *FetchError) Error() string {
+	if e.Parent == "" {
+		return fmt.Sprintf("fetch %v (depth %d, seed): %v", e.URL, e.Depth, e.Err)
 	}
-	body, urls, err := fetcher.Fetch(url)
+	return fmt.Sprintf("fetch %v (depth %d, parent %v): %v", e.URL, e.Depth, e.Parent, e.Err)
+}
 
-	if _, ok := err.(*AlreadyFetchedError); ok {
-		return
-	} else if err != nil {
-		fmt.Println(err)
-		return
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Crawl crawls pages starting with seed, as configured by cfg, and
+// sends a result for each page it fetches, or fails to fetch, on the
+// returned channel. The channel is closed when the crawl is finished
+// or ctx is cancelled. An error is returned, and nothing crawled, if
+// cfg has no Fetcher or an invalid Depth, or seed is not an absolute
+// url.
+//
+// A page's result is always sent before the results of the pages it
+// led to, whose ParentURL it is, since its links are only queued once
+// its result has been taken. A consumer can build the tree of the
+// crawl as results arrive, knowing each parent is already there. The
+// same holds of a Sink's writes.
+//
+// The consumer need not read every result: if it stops reading it
+// must cancel ctx, after which the workers stop, even those waiting
+// to send, and the channel is closed. Nothing is left running.
+func Crawl(ctx context.Context, seed string, cfg CrawlConfig) (<-chan CrawlResult, error) {
+	return CrawlSeeds(ctx, []string{seed}, cfg)
+}
+
+// CrawlSeeds is like Crawl but starts from every url in seeds at
+// once, as if they were all linked from one page. The seeds share
+// one crawl: no url is fetched twice, however many seeds lead to it,
+// and MaxPages and Stats cover the whole crawl. Seeds repeated in
+// seeds are crawled once.
+func CrawlSeeds(ctx context.Context, seeds []string, cfg CrawlConfig) (<-chan CrawlResult, error) {
+	cr, err := NewCrawler(seeds, cfg)
+	if err != nil {
+		return nil, err
 	}
+	return cr.Start(ctx)
+}
 
-	c <- fakeResult{body, urls}
+// Crawler is a crawl that can be paused and resumed while it runs.
+type Crawler struct {
+	seeds   []string
+	run     *crawlRun
+	c       chan CrawlResult
+	started atomic.Bool
+	closed  sync.Once
+}
 
-	fmt.Printf("found: %s %q\n", url, body)
-	for _, u := range urls {
-		wg.Add(1)
-		go Crawl(u, depth-1, fetcher, c, wg)
+// NewCrawler returns a crawl from seeds, configured by cfg, ready to
+// Start. It returns the same errors as CrawlSeeds.
+func NewCrawler(seeds []string, cfg CrawlConfig) (*Crawler, error) {
+	if cfg.Fetcher == nil {
+		return nil, errors.New("crawl: no Fetcher configured")
+	}
+	if cfg.Depth < 0 && cfg.Depth != Unlimited {
+		return nil, fmt.Errorf("crawl: invalid depth %d", cfg.Depth)
 	}
-	return
+	if len(seeds) == 0 && cfg.OnFrontier == nil {
+		return nil, errors.New("crawl: no seed urls")
+	}
+	for _, seed := range seeds {
+		if u, err := url.Parse(seed); err != nil {
+			return nil, fmt.Errorf("crawl: %w", err)
+		} else if !u.IsAbs() {
+			return nil, fmt.Errorf("crawl: seed %q is not an absolute url", seed)
+		}
+	}
+
+	cfg = cfg.withDefaults()
+	c := make(chan CrawlResult, cfg.BufferSize)
+	return &Crawler{seeds: seeds, run: newCrawlRun(seeds, cfg, c), c: c}, nil
 }
 
-func main() {
-	var c = make(chan fakeResult)
+// Start starts the crawl, which then runs as one started by
+// CrawlSeeds. A Crawler can only be started once, whether by Start,
+// Run or All.
+func (cr *Crawler) Start(ctx context.Context) (<-chan CrawlResult, error) {
+	if !cr.started.CompareAndSwap(false, true) {
+		return nil, errors.New("crawl: already started")
+	}
+	go cr.run.run(ctx)
+	return cr.c, nil
+}
 
-	var f = myFetcher{
-		cache: make(map[string]bool),
-		mux:   &sync.Mutex{},
+// Run runs the crawl to completion, like Start but waiting for it to
+// finish, and reports whether it failed as a whole. Its results are
+// sent on the channel returned by Results, which must be read, as for
+// a crawl begun by Start, unless the crawl is CountOnly or has a
+// Sink. Pages that fail are reported there and not by Run, unless
+// none of the seeds could be fetched: then Run returns the
+// *FetchError of the first seed to fail. If ctx is cancelled Run
+// returns ctx.Err(), if the crawl outlasts its MaxDuration,
+// ErrMaxDuration, if it is stopped by its MaxConsecutiveErrors, the
+// *CircuitOpenError, if by its IdleTimeout, ErrIdleTimeout, and if by
+// its AbortOnError, the *FetchError of the fetch that failed. If its
+// Sink fails Run returns the *SinkError, and if it is stopped by
+// Close, ErrClosed.
+//
+// Run fits the func() error of an errgroup, whose context it would be
+// given, so that a failed crawl cancels the rest of the group.
+func (cr *Crawler) Run(ctx context.Context) error {
+	if !cr.started.CompareAndSwap(false, true) {
+		return errors.New("crawl: already started")
 	}
+	return cr.run.run(ctx)
+}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go Crawl("https://golang.org/", 4, f, c, &wg)
+// Close stops the crawl if it is running, as if its context had been
+// cancelled, and waits for it to finish. Then it closes the idle
+// connections of the crawl's Fetcher, if it keeps any open, as an
+// HTTPFetcher does, looking through the fetchers of this package that
+// wrap it, so that a process that makes many crawlers doesn't hold on
+// to connections it won't use again. A Crawler closed before it is
+// started crawls nothing, and can't be started after. Close may be
+// called more than once, and after the crawl has finished, but not
+// from the crawl's hooks. It returns nil.
+func (cr *Crawler) Close() error {
+	cr.closed.Do(func() {
+		r := cr.run
+		close(r.closing)
+		if cr.started.CompareAndSwap(false, true) {
+			// run to close the results channel, and the Sink
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			r.run(ctx)
+		}
+		<-r.done
+		closeIdleConnections(r.cfg.Fetcher)
+	})
+	return nil
+}
 
-	// close c when all crawlers are done
+// Results returns the channel that the crawl's results are sent on,
+// the same one that Start returns. It may be called before the crawl
+// is started.
+func (cr *Crawler) Results() <-chan CrawlResult {
+	return cr.c
+}
+
+// All starts the crawl and returns an iterator over its results,
+// with each result's Err. Breaking out of the loop cancels the crawl,
+// and the iterator doesn't return until it has stopped. If ctx is
+// cancelled the last pair yielded carries ctx.Err(). A Crawler that
+// can't start yields the error from Start alone.
+func (cr *Crawler) All(ctx context.Context) iter.Seq2[CrawlResult, error] {
+	return func(yield func(CrawlResult, error) bool) {
+		crawlCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		c, err := cr.Start(crawlCtx)
+		if err != nil {
+			yield(CrawlResult{}, err)
+			return
+		}
+		defer func() {
+			cancel()
+			for range c {
+			}
+		}()
+		for r := range c {
+			if !yield(r, r.Err) {
+				return
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			yield(CrawlResult{}, err)
+		}
+	}
+}
+
+// CrawlSnapshot describes a crawl in progress.
+type CrawlSnapshot struct {
+	Queued    int // urls waiting to be fetched
+	InFlight  int // urls being fetched, or whose results are being sent
+	Visited   int // urls admitted to the crawl, whether fetched yet or not
+	Completed int // pages fetched or failed
+}
+
+// Snapshot returns the state of the crawl as of now. The queue is
+// held still while it is taken, so the numbers agree with each
+// other, but a crawl that is not paused may change straight after.
+func (cr *Crawler) Snapshot() CrawlSnapshot {
+	r := cr.run
+	var s CrawlSnapshot
+	r.q.inspect(func(queued, inflight int) {
+		s.Queued, s.InFlight = queued, inflight
+		s.Visited = r.visited.Len()
+		s.Completed = int(r.counters.fetched.Load() + r.counters.failed.Load())
+	})
+	return s
+}
+
+// Submit queues rawurl to be crawled at depth, for a crawl with an
+// OnFrontier hook, typically with a url and depth the hook was given,
+// by this crawl or another. It is crawled as a seed would be, without
+// a ParentURL, even if it has been crawled already: keeping track of
+// that is the caller's part. Submit returns an error if the crawl has
+// no OnFrontier hook, EndSubmit has been called or the crawl is over,
+// or rawurl is not an absolute url. It may be called before the crawl
+// is started, and from many goroutines at once.
+func (cr *Crawler) Submit(rawurl string, depth int) error {
+	r := cr.run
+	if r.cfg.OnFrontier == nil {
+		return errors.New("crawl: submit without OnFrontier")
+	}
+	if depth < 0 && depth != Unlimited {
+		return fmt.Errorf("crawl: invalid depth %d", depth)
+	}
+	if u, err := url.Parse(rawurl); err != nil {
+		return fmt.Errorf("crawl: %w", err)
+	} else if !u.IsAbs() {
+		return fmt.Errorf("crawl: submitted url %q is not an absolute url", rawurl)
+	}
+	for _, url := range r.cfg.rewrite([]string{rawurl}) {
+		r.visited.Add(r.key(url)) // for OnFrontier not to see it again
+		if !r.q.pushHeld(crawlTask{url: url, depth: depth, route: &route{url: url}}) {
+			return errors.New("crawl: submit to a finished crawl")
+		}
+	}
+	return nil
+}
+
+// EndSubmit tells a crawl with an OnFrontier hook that nothing more
+// will be submitted, so that it finishes once the urls submitted so
+// far have been crawled. It returns an error if the crawl has no
+// OnFrontier hook. EndSubmit may be called more than once, and after
+// the crawl is over.
+func (cr *Crawler) EndSubmit() error {
+	if cr.run.cfg.OnFrontier == nil {
+		return errors.New("crawl: EndSubmit without OnFrontier")
+	}
+	cr.run.q.endHold()
+	return nil
+}
+
+// Hosts returns the lower case hosts of the crawl's seeds and of every
+// link found so far, whether followed or not, sorted, for seeing how
+// far a site's links reach. It may be called while the crawl runs.
+func (cr *Crawler) Hosts() []string {
+	return cr.run.counters.hostList()
+}
+
+// CancelHost stops the crawl from fetching any more pages from host,
+// a host name as Hosts lists them, while it carries on with the
+// others: the host's queued urls are dropped and its links are not
+// followed from then on. Fetches from it in progress finish as usual,
+// but the urls they find on it are dropped too. A host may be
+// cancelled before the crawl is started, and CancelHost may be called
+// from many goroutines at once.
+func (cr *Crawler) CancelHost(host string) {
+	r := cr.run
+	host = strings.ToLower(host)
+	r.cancelled.add(host)
+	n := r.q.drop(func(t crawlTask) bool { return hostOf(t.url) == host })
+	r.cfg.Logger.Info("host cancelled", "host", host, "dropped", n)
+}
+
+// Pause stops the crawl from starting any more fetches until Resume
+// is called. Fetches in progress finish as usual: their results are
+// sent and the urls they find are queued. Cancelling the crawl's
+// context ends it whether it is paused or not. Pause may be called
+// before Start, and pausing a paused crawl does nothing.
+func (cr *Crawler) Pause() {
+	cr.run.q.setPaused(true)
+}
+
+// Resume lets a paused crawl carry on where it stopped.
+func (cr *Crawler) Resume() {
+	cr.run.success.Store(time.Now().UnixNano()) // for IdleTimeout
+	cr.run.q.setPaused(false)
+}
+
+// crawlRun holds the state shared by the workers of one crawl.
+type crawlRun struct {
+	seeds   []string // after the URLRewriter
+	cfg     CrawlConfig
+	c       chan<- CrawlResult
+	q       *taskQueue
+	hosts   *hostLimiter // nil if the hosts are not limited
+	scope   crawlScope
+	visited VisitedSet                 // urls admitted to the crawl
+	pages   atomic.Int64               // fetches started or completed, for MaxPages
+	host    hostPages                  // the same per host, for PerHostPageLimit
+	seedErr atomic.Pointer[FetchError] // first seed to fail, for Run
+	streak  atomic.Int64               // failures since the last success
+	abort   context.CancelCauseFunc    // stops the crawl early
+	success atomic.Int64               // UnixNano of the last success, for IdleTimeout
+	sinkMu  sync.Mutex                 // serializes writes to the Sink
+	closing chan struct{}              // closed by Crawler.Close
+	done    chan struct{}              // closed when run returns
+
+	counters  crawlCounters
+	cancelled cancelledHosts // see Crawler.CancelHost
+
+	// prior holds the BodyHash of each url in an earlier crawl, for
+	// CrawlChanged
+	prior map[string]string
+
+	deferMu  sync.Mutex
+	deferred []crawlTask // links held back for DeferFanOut, not yet admitted
+
+	// resume, if resumed is set, holds the tasks to start from in
+	// place of the seeds; see LoadCheckpoint
+	resume  []crawlTask
+	resumed bool
+}
+
+func newCrawlRun(seeds []string, cfg CrawlConfig, c chan<- CrawlResult) *crawlRun {
+	seeds = cfg.rewrite(seeds)
+	r := &crawlRun{
+		cfg:      cfg,
+		c:        c,
+		q:        newTaskQueue(cfg.BreadthFirst, cfg.Sequential || cfg.StableMaxPages, cfg.MaxQueueLength, cfg.Priority),
+		hosts:    newHostLimiter(cfg.Concurrency),
+		host:     hostPages{limit: cfg.PerHostPageLimit, limits: cfg.HostPageLimits},
+		seeds:    seeds,
+		scope:    newCrawlScope(seeds, cfg),
+		visited:  cfg.Visited,
+		counters: crawlCounters{start: time.Now()},
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, u := range cfg.AlreadySeen {
+		r.visited.Add(r.key(u))
+	}
+	r.counters.sawHosts(seeds)
+	if cfg.StableMaxPages {
+		r.q.reserve = func(t crawlTask) bool { return r.reservePage(t.url) }
+	}
+	if cfg.OnFrontier != nil {
+		r.q.hold() // until EndSubmit
+	}
+	return r
+}
+
+// key returns the key under which url is recorded in the visited set.
+func (r *crawlRun) key(url string) string {
+	return r.cfg.DedupKey(url)
+}
+
+// run crawls from the seeds with a pool of workers, then records the
+// stats and closes the results channel. It returns the error that
+// Crawler.Run does.
+func (r *crawlRun) run(ctx context.Context) error {
+	defer close(r.done)
+	parent := ctx
+	if r.cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.MaxDuration)
+		defer cancel()
+	}
+	timed := ctx
+	ctx, r.abort = context.WithCancelCause(ctx)
+	defer r.abort(nil)
 	go func() {
+		select {
+		case <-r.closing:
+			r.abort(ErrClosed)
+		case <-ctx.Done():
+		}
+	}()
+	if r.resumed {
+		r.q.restore(r.resume)
+	} else {
+		for _, seed := range r.seeds {
+			depth := r.relativeDepth(seed, r.cfg.Depth)
+			if r.admit(seed, r.key(seed), "", depth) {
+				r.q.push(crawlTask{url: seed, depth: depth, route: &route{url: seed}})
+			}
+		}
+	}
+	// paused workers, and those waiting for room in the queue, must
+	// see the crawl being cancelled, so they can wind it down
+	stop := context.AfterFunc(ctx, r.q.stop)
+	defer stop()
+	if r.cfg.IdleTimeout > 0 {
+		go r.watchIdle(ctx)
+	}
+
+	// the links held back for DeferFanOut are crawled once the
+	// rest has been, and may hold back more in turn
+	for more := true; more; more = ctx.Err() == nil && r.queueDeferred() {
+		var wg sync.WaitGroup
+		wg.Add(r.cfg.MaxWorkers)
+		for i := 0; i < r.cfg.MaxWorkers; i++ {
+			go func() {
+				defer wg.Done()
+				for t, ok := r.q.pop(); ok; t, ok = r.q.pop() {
+					r.runTask(ctx, t)
+				}
+			}()
+		}
 		wg.Wait()
-		close(c)
+	}
+
+	stats := r.counters.stats()
+	stats.DeadlineExceeded = timed.Err() != nil && parent.Err() == nil
+	if c, ok := context.Cause(ctx).(*CircuitOpenError); ok && timed.Err() == nil {
+		stats.CircuitOpen = c
+	}
+	stats.IdleTimedOut = context.Cause(ctx) == ErrIdleTimeout && timed.Err() == nil
+	sinkErr, _ := context.Cause(ctx).(*SinkError)
+	aborted, _ := context.Cause(ctx).(*FetchError) // for AbortOnError
+	if timed.Err() != nil {
+		aborted = nil
+	}
+	if r.cfg.Sink != nil {
+		if err := r.cfg.Sink.Close(); err != nil && sinkErr == nil {
+			sinkErr = &SinkError{err}
+		}
+	}
+	if r.cfg.Stats != nil {
+		*r.cfg.Stats = stats
+	}
+	close(r.c)
+
+	switch seedErr := r.seedErr.Load(); {
+	case parent.Err() != nil:
+		return parent.Err()
+	case context.Cause(ctx) == ErrClosed:
+		return ErrClosed
+	case sinkErr != nil:
+		return sinkErr
+	case stats.DeadlineExceeded:
+		return ErrMaxDuration
+	case stats.CircuitOpen != nil:
+		return stats.CircuitOpen
+	case stats.IdleTimedOut:
+		return ErrIdleTimeout
+	case aborted != nil:
+		return aborted
+	case seedErr != nil && stats.PagesByDepth[0] == 0:
+		return seedErr
+	}
+	return nil
+}
+
+// watchIdle stops the crawl with ErrIdleTimeout once no fetch has
+// succeeded for IdleTimeout, not counting any time paused. It returns
+// when ctx is done.
+func (r *crawlRun) watchIdle(ctx context.Context) {
+	r.success.Store(time.Now().UnixNano())
+	timer := time.NewTimer(r.cfg.IdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if r.q.isPaused() {
+			r.success.Store(time.Now().UnixNano())
+		}
+		idle := time.Since(time.Unix(0, r.success.Load()))
+		if idle >= r.cfg.IdleTimeout {
+			r.cfg.Logger.Error("no fetch succeeded for too long; stopping", "idle", idle)
+			r.abort(ErrIdleTimeout)
+			return
+		}
+		timer.Reset(r.cfg.IdleTimeout - idle)
+	}
+}
+
+// reservePage claims one of the crawl's MaxPages fetches, and one of
+// those the host of url may have, reporting false if they have all
+// been used.
+func (r *crawlRun) reservePage(url string) bool {
+	if !r.host.reserve(hostOf(url)) {
+		return false
+	}
+	if r.cfg.MaxPages <= 0 {
+		return true
+	}
+	for {
+		n := r.pages.Load()
+		if n >= int64(r.cfg.MaxPages) {
+			r.host.release(hostOf(url))
+			return false
+		}
+		if r.pages.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// releasePage returns a page of url claimed by reservePage that was
+// not fetched after all.
+func (r *crawlRun) releasePage(url string) {
+	r.host.release(hostOf(url))
+	if r.cfg.MaxPages > 0 {
+		r.pages.Add(-1)
+	}
+}
+
+// runTask crawls the task t taken from the queue and queues the urls
+// found on its page one level deeper, each only once however often
+// the page links to it. t is marked done however
+// runTask returns, so the queue's count of pending work can't drift.
+func (r *crawlRun) runTask(ctx context.Context, t crawlTask) {
+	defer r.q.done()
+	found := r.visit(ctx, t)
+	if t.asset {
+		return
+	}
+	depth := t.depth
+	if depth != Unlimited && depth > 0 {
+		depth--
+	}
+	// assets are fetched even from the last level of the crawl,
+	// being leaves whose links are never followed
+	for _, a := range uniqueURLs(found.assets, r.key) {
+		if r.admit(a.url, a.key, t.url, depth) {
+			r.q.push(crawlTask{
+				url:    a.url,
+				parent: t.url,
+				depth:  depth,
+				level:  t.level + 1,
+				route:  &route{a.url, t.route},
+				asset:  true,
+			})
+		}
+	}
+	if t.depth == 0 && !found.sitemap {
+		return
+	}
+	// a sitemap's urls are crawled at its own depth, as if
+	// the page linking to it had linked to them
+	if found.sitemap {
+		depth = t.depth
+	}
+	links := uniqueURLs(found.links, r.key)
+	if n := r.cfg.MaxLinksPerPage; n > 0 && len(links) > n {
+		links = links[:n]
+		r.counters.truncated.Add(1)
+	}
+	self := r.key(t.url)
+	queued, held := 0, false
+	for _, l := range links {
+		// a page linking to itself, as a logo does to the home
+		// page, has been crawled already
+		if l.key == self {
+			r.counters.self.Add(1)
+			continue
+		}
+		depth := r.externalDepth(l.url, r.relativeDepth(l.url, depth))
+		task := crawlTask{url: l.url, parent: t.url, depth: depth, level: t.level + 1}
+		if n := r.cfg.MaxFanOut; n > 0 && queued == n {
+			if r.cfg.DeferFanOut {
+				task.route = &route{l.url, t.route}
+				r.deferTask(task)
+			}
+			held = true
+			continue
+		}
+		if r.admit(l.url, l.key, t.url, depth) {
+			task.route = &route{l.url, t.route}
+			r.enqueue(task)
+			queued++
+		}
+	}
+	if held {
+		r.counters.fanOut.Add(1)
+	}
+}
+
+// deferTask holds back t, a link over MaxFanOut, for DeferFanOut.
+func (r *crawlRun) deferTask(t crawlTask) {
+	r.deferMu.Lock()
+	defer r.deferMu.Unlock()
+	r.deferred = append(r.deferred, t)
+}
+
+// queueDeferred queues those of the links held back by deferTask that
+// admit lets through, reporting whether it queued any.
+func (r *crawlRun) queueDeferred() bool {
+	r.deferMu.Lock()
+	tasks := r.deferred
+	r.deferred = nil
+	r.deferMu.Unlock()
+	queued := false
+	for _, t := range tasks {
+		if r.admit(t.url, r.key(t.url), t.parent, t.depth) {
+			r.enqueue(t)
+			queued = true
+		}
+	}
+	return queued
+}
+
+// enqueue queues t, a link admitted to the crawl, or passes it to the
+// OnFrontier hook if there is one.
+func (r *crawlRun) enqueue(t crawlTask) {
+	if r.cfg.OnFrontier != nil {
+		r.cfg.OnFrontier(t.url, t.depth)
+		return
+	}
+	r.q.push(t)
+}
+
+// relativeDepth returns the depth to crawl url at, found with depth
+// left: at least RelativeDepth if url matches one of the
+// IncludePatterns.
+func (r *crawlRun) relativeDepth(url string, depth int) int {
+	n := r.cfg.RelativeDepth
+	if n > 0 && depth != Unlimited && depth < n && slices.ContainsFunc(r.cfg.IncludePatterns, matches(url)) {
+		return n
+	}
+	return depth
+}
+
+// externalDepth returns the depth to crawl url at, found with depth
+// left: no more than ExternalLinkDepth allows if url is off the site.
+func (r *crawlRun) externalDepth(url string, depth int) int {
+	n := r.cfg.ExternalLinkDepth
+	if n <= 0 || r.scope.inSite(url) {
+		return depth
+	}
+	if depth == Unlimited {
+		return n - 1
+	}
+	return min(depth, n-1)
+}
+
+// admit reports whether url, whose key is key, should be crawled at
+// depth, and if so marks it visited. from is the page linking to url,
+// or empty for a seed. Marking urls when they are queued rather than
+// when they are fetched means that no url is ever fetched twice,
+// whatever the fetcher, and that workers never race for the same url.
+// That is also what ends an Unlimited crawl of a cyclic graph.
+func (r *crawlRun) admit(url, key, from string, depth int) bool {
+	if r.cancelled.covers(url) {
+		return false
+	}
+	if n := r.cfg.MaxURLLength; n > 0 && len(url) > n && from != "" {
+		r.counters.longURLs.Add(1)
+		return false
+	}
+	if n := r.cfg.MaxRepeatedSegments; n > 0 && repeatedSegments(url) > n && from != "" {
+		r.counters.traps.Add(1)
+		return false
+	}
+	if n := r.cfg.MaxPathSegments; n > 0 && pathSegments(url) > n && from != "" {
+		r.counters.deepPaths.Add(1)
+		return false
+	}
+	if from != "" && !r.scope.allowsScheme(url) {
+		r.counters.skippedScheme(schemeOf(url))
+		return false
+	}
+	if from != "" && !r.scope.allowsExt(url) {
+		return false
+	}
+	if !r.scope.inSite(url) && (from == "" || r.cfg.ExternalLinkDepth <= 0) {
+		return false
+	}
+	if !r.scope.allows(url) {
+		return false
+	}
+	if r.cfg.ShouldFollow != nil && !r.cfg.ShouldFollow(url, depth) {
+		return false
+	}
+	if !r.visited.Add(key) {
+		r.counters.skipped.Add(1)
+		if from != "" && r.cfg.OnDuplicateLink != nil {
+			r.cfg.OnDuplicateLink(LinkEvent{From: from, To: url})
+		}
+		return false
+	}
+	return true
+}
+
+// pageLinks is what visit found on a page to crawl next.
+type pageLinks struct {
+	links, assets []string
+	sitemap       bool // a sitemap followed for FollowSitemaps
+}
+
+// visit fetches the url of t, which must have been admitted, and
+// sends its result, returning the urls found on its page. It returns
+// nothing if the url was skipped by the fetcher or failed, or if ctx
+// or the page limit rule out fetching it.
+func (r *crawlRun) visit(ctx context.Context, t crawlTask) (found pageLinks) {
+	url, parent, depth := t.url, t.parent, t.depth
+	if ctx.Err() != nil {
+		return found
+	}
+	if r.cfg.MaxBytes > 0 && r.counters.bytes.Load() >= r.cfg.MaxBytes {
+		return found
+	}
+	if !t.reserved && !r.reservePage(url) {
+		return found
+	}
+	fetchCtx := ctx
+	if parent != "" {
+		fetchCtx = ContextWithParent(ctx, parent)
+	}
+	page, elapsed, err := r.fetch(fetchCtx, url, t.level)
+	if r.cfg.OnFetch != nil {
+		r.cfg.OnFetch(url, depth, err)
+	}
+
+	res := CrawlResult{
+		URL:           url,
+		Depth:         depth,
+		ParentURL:     parent,
+		Path:          t.route.urls(),
+		Asset:         t.asset,
+		FetchedAt:     time.Now(),
+		FetchDuration: elapsed,
+	}
+	if page != nil {
+		if !r.cfg.DryRun {
+			res.Body = page.Body
+		}
+		res.StatusCode, res.ContentType = page.StatusCode, page.ContentType
+		res.RedirectChain, res.TTFB = page.RedirectChain, page.TTFB
+		res.Timing = page.Timing
+		res.Canonical = page.Canonical
+		res.Title, res.Description = page.Title, page.Description
+		res.StructuredData = page.StructuredData
+		// the urls redirected through have been fetched now, so
+		// links to them needn't be
+		for _, u := range page.RedirectChain {
+			r.visited.Add(r.key(u))
+		}
+	}
+	if isSkip(err) {
+		// the fetcher didn't do any work, so the page
+		// can go to another url
+		r.releasePage(url)
+		r.counters.skipped.Add(1)
+		return found
+	} else if err != nil {
+		if ctx.Err() != nil {
+			return found
+		}
+		r.counters.failed.Add(1)
+		r.cfg.Logger.Warn("fetch failed", "url", url, "depth", depth, "err", err)
+		fe := &FetchError{URL: url, Depth: t.level, Parent: parent, Err: err}
+		if t.level == 0 {
+			r.seedErr.CompareAndSwap(nil, fe)
+		}
+		res.Err = fe
+		r.send(ctx, res)
+		if r.cfg.AbortOnError {
+			r.cfg.Logger.Error("fetch failed; stopping", "url", url)
+			r.abort(fe)
+			return found
+		}
+		if n := r.cfg.MaxConsecutiveErrors; n > 0 && r.streak.Add(1) == int64(n) {
+			r.cfg.Logger.Error("too many fetches failed; stopping", "failures", n)
+			r.abort(&CircuitOpenError{n, err})
+		}
+		return found
+	}
+	r.streak.Store(0)
+	if r.cfg.IdleTimeout > 0 {
+		r.success.Store(time.Now().UnixNano())
+	}
+	r.counters.fetchedAt(t.level)
+	r.counters.links.Add(int64(len(page.URLs)))
+	r.cfg.Logger.Info("found", "url", url, "depth", depth, "links", len(page.URLs))
+
+	// the links a fetcher reports needn't be absolute; they lead
+	// from the url that served the page
+	base := url
+	if n := len(page.RedirectChain); n > 0 {
+		base = page.RedirectChain[n-1]
+	}
+	urls, sitemap := resolveLinks(base, page.URLs), false
+	if r.cfg.FollowSitemaps {
+		if locs, ok := sitemapLocs(url, page); ok {
+			urls, sitemap = locs, true
+		} else {
+			urls = append(slices.Clip(urls), page.Sitemaps...)
+		}
+	}
+	links := r.cfg.rewrite(r.discover(url, depth, urls))
+	assets := r.cfg.rewrite(r.discover(url, depth, resolveLinks(base, page.Assets)))
+	r.counters.sawHosts(links)
+	r.counters.sawHosts(assets)
+	// the consumer gets its own copy, as the workers are still
+	// reading links while it has the result
+	res.Links, res.Assets = slices.Clone(links), slices.Clone(assets)
+	if r.cfg.RespectNofollow && len(page.Nofollow) > 0 {
+		nofollow := make(map[string]bool)
+		for _, u := range r.cfg.rewrite(resolveLinks(base, page.Nofollow)) {
+			nofollow[u] = true
+		}
+		// links may be the page's own, which a BodyCache keeps
+		links = slices.DeleteFunc(slices.Clone(links), func(u string) bool { return nofollow[u] })
+	}
+	res.DepthLimited = depth == 0 && len(links) > 0 && !sitemap && !t.asset
+	var blank bool
+	res.BodyHash, blank = page.bodyHash()
+	res.Empty = len(page.URLs) == 0 && blank
+	if h, ok := r.prior[url]; ok && h == res.BodyHash && !t.asset {
+		res.Unchanged = true
+		res.DepthLimited = false
+	}
+	if page.StatusCode < 400 && r.cfg.SoftNotFoundDetector != nil {
+		res.SoftNotFound = r.cfg.SoftNotFoundDetector(page.Body)
+		res.DepthLimited = res.DepthLimited && !res.SoftNotFound
+	}
+	if !r.send(ctx, res) || page.StatusCode >= 400 || res.SoftNotFound || res.Unchanged {
+		return found
+	}
+	return pageLinks{links, assets, sitemap}
+}
+
+// discover passes each of urls, the links found on the page from at
+// depth, to the OnLinkDiscovered hook, returning those it keeps.
+func (r *crawlRun) discover(from string, depth int, urls []string) []string {
+	if r.cfg.OnLinkDiscovered == nil {
+		return urls
+	}
+	out := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if r.cfg.OnLinkDiscovered(from, u, depth) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// fetch returns the page of url, found level links from a seed, from
+// the BodyCache, or fetches it after its DelayByDepth and within the
+// host limits if it isn't cached, along with how long the fetch took.
+func (r *crawlRun) fetch(ctx context.Context, url string, level int) (*Page, time.Duration, error) {
+	if r.cfg.BodyCache != nil {
+		if page, ok := r.cfg.BodyCache.Get(url); ok {
+			page.TTFB, page.Timing = 0, nil // nothing was fetched this time
+			return page, 0, nil
+		}
+	}
+	if r.cfg.DelayByDepth != nil {
+		if err := sleepCtx(ctx, r.cfg.DelayByDepth(level)); err != nil {
+			return nil, 0, err
+		}
+	}
+	host := hostOf(url)
+	if err := r.hosts.acquire(ctx, host); err != nil {
+		return nil, 0, err
+	}
+	if r.cancelled.covers(url) {
+		// cancelled while it waited
+		r.hosts.release(host)
+		return nil, 0, hostCancelledError{url}
+	}
+	if r.cfg.Metrics != nil {
+		r.cfg.Metrics.FetchStarted(host)
+	}
+	start := time.Now()
+	page, err := safeFetchPage(ctx, r.cfg.Fetcher, url)
+	elapsed := time.Since(start)
+	r.hosts.release(host)
+	if r.cfg.Metrics != nil {
+		reportFetch(r.cfg.Metrics, host, page, elapsed, err)
+	}
+	if page != nil {
+		r.counters.bytes.Add(int64(len(page.Body)))
+	}
+	if err == nil && page == nil {
+		page = &Page{} // a PageFetcher had nothing to report
+	}
+	if err == nil && r.cfg.BodyCache != nil {
+		r.cfg.BodyCache.Put(url, page)
+	}
+	return page, elapsed, err
+}
+
+// safeFetchPage is like fetchPage, but turns a panic in f into a
+// *FetcherPanicError, so that one bad page doesn't bring down the
+// crawl.
+func safeFetchPage(ctx context.Context, f Fetcher, url string) (page *Page, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			page, err = nil, &FetcherPanicError{url, v}
+		}
 	}()
+	return fetchPage(ctx, f, url)
+}
 
-	for r := range c {
-		fmt.Println(r)
+// send delivers res to the consumer, giving up if ctx is cancelled.
+// With CountOnly it only reports whether ctx is still live, and with
+// a Sink it writes res there instead, stopping the crawl if that
+// fails.
+func (r *crawlRun) send(ctx context.Context, res CrawlResult) bool {
+	if r.cfg.CountOnly {
+		return ctx.Err() == nil
+	}
+	if r.cfg.Sink != nil {
+		r.sinkMu.Lock()
+		defer r.sinkMu.Unlock()
+		if ctx.Err() != nil {
+			return false
+		}
+		if err := r.cfg.Sink.Write(res); err != nil {
+			r.abort(&SinkError{err})
+			return false
+		}
+		return true
+	}
+	select {
+	case r.c <- res:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
-type myFetcher struct {
-	cache map[string]bool
-	mux   *sync.Mutex
+func main() {
+	opts, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+
+	// stop crawling on the first ^C but still print what we have;
+	// a second ^C kills the program as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	cfg, err := opts.crawlConfig(os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// fail fast, rather than print a crawl of one error
+	if _, err := Probe(ctx, opts.seed, cfg.Fetcher); err != nil {
+		fmt.Fprintln(os.Stderr, "bad seed:", err)
+		os.Exit(1)
+	}
+	c, err := Crawl(ctx, opts.seed, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := writeResults(os.Stdout, opts.output, opts.seed, c, cfg.Stats); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "interrupted: results are incomplete")
+		os.Exit(1)
+	}
 }
 
+type myFetcher struct{}
+
 type fakeResult struct {
 	body string
 	urls []string
 }
 
-func (f myFetcher) Fetch(url string) (string, []string, error) {
-	f.mux.Lock()
-	if _, ok := f.cache[url]; ok {
-		f.mux.Unlock()
-		return "", nil, &AlreadyFetchedError{url}
+func (f myFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
 	}
-	// insert into cache so nobody else gets this url
-	f.cache[url] = true
-	f.mux.Unlock()
-
 	if res, ok := rawData[url]; ok {
-		return res.body, res.urls, nil
+		// rawData is shared by every fetch, so callers get a copy
+		// of the urls they may change
+		return res.body, slices.Clone(res.urls), nil
 	}
-	return "", nil, fmt.Errorf("not found: %s", url)
+	return "", nil, fmt.Errorf("%w: %s", ErrNotFound, url)
 }
 
 // fetcher is a populated fakeFetcher.
@@ -0,0 +1,2122 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+	"golang.org/x/sync/errgroup"
+)
+
+// rawDataGraph returns the link graph of rawData, the golang.org
+// pages the crawler serves without -http, for a FakeFetcher.
+func rawDataGraph() map[string][]string {
+	graph := make(map[string][]string, len(rawData))
+	for u, res := range rawData {
+		graph[u] = res.urls
+	}
+	return graph
+}
+
+// crawlRawData crawls rawData from https://golang.org/ with a
+// FakeFetcher, to a Depth of 4 unless cfg gives another, and returns
+// every result, failures included.
+func crawlRawData(t *testing.T, cfg CrawlConfig) []CrawlResult {
+	t.Helper()
+	cfg.Fetcher = crawltest.NewFakeFetcher(rawDataGraph())
+	if cfg.Depth == 0 {
+		cfg.Depth = 4
+	}
+	results, err := Crawl(context.Background(), "https://golang.org/", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []CrawlResult
+	for r := range results {
+		all = append(all, r)
+	}
+	return all
+}
+
+// syntheticGraph returns a site of n pages, each linking to links
+// others spread across the site, for tests. Page 0 is
+// http://example.com/p0, and every page can be reached from it.
+func syntheticGraph(n, links int) map[string][]string {
+	graph := make(map[string][]string, n)
+	for i := range n {
+		urls := make([]string, links)
+		for j := range urls {
+			urls[j] = fmt.Sprintf("http://example.com/p%d", (i*links+j+1)%n)
+		}
+		graph[fmt.Sprintf("http://example.com/p%d", i)] = urls
+	}
+	return graph
+}
+
+func TestCrawlResultLineage(t *testing.T) {
+	const root = "http://example.com/"
+	f := crawltest.NewFakeFetcher(map[string][]string{
+		root:       {root + "a", root + "b"},
+		root + "a": {root + "c"},
+		root + "b": {root + "c", root + "d"},
+		root + "c": {root},
+		root + "d": {root + "e"},
+		root + "e": nil,
+	})
+	cfg := CrawlConfig{Fetcher: f, Depth: 2, Sequential: true, BreadthFirst: true}
+	results, err := Crawl(context.Background(), root, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]CrawlResult)
+	for r := range results {
+		got[r.URL] = r
+	}
+
+	tests := []struct {
+		url, parent string
+		depth       int
+		path        []string
+	}{
+		{root, "", 2, []string{root}},
+		{root + "a", root, 1, []string{root, root + "a"}},
+		{root + "b", root, 1, []string{root, root + "b"}},
+		{root + "c", root + "a", 0, []string{root, root + "a", root + "c"}},
+		{root + "d", root + "b", 0, []string{root, root + "b", root + "d"}},
+	}
+	if len(got) != len(tests) {
+		t.Errorf("crawled %d pages, want %d; e is beyond the depth", len(got), len(tests))
+	}
+	for _, tt := range tests {
+		r, ok := got[tt.url]
+		if !ok {
+			t.Errorf("%s not crawled", tt.url)
+			continue
+		}
+		if r.ParentURL != tt.parent || r.Depth != tt.depth || !slices.Equal(r.Path, tt.path) {
+			t.Errorf("%s: parent %q, depth %d, path %q; want %q, %d, %q",
+				tt.url, r.ParentURL, r.Depth, r.Path, tt.parent, tt.depth, tt.path)
+		}
+	}
+}
+
+func TestRawDataPaths(t *testing.T) {
+	want := map[string][]string{
+		"https://golang.org/":         {"https://golang.org/"},
+		"https://golang.org/cmd/":     {"https://golang.org/", "https://golang.org/cmd/"},
+		"https://golang.org/pkg/":     {"https://golang.org/", "https://golang.org/pkg/"},
+		"https://golang.org/pkg/fmt/": {"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/fmt/"},
+		"https://golang.org/pkg/os/":  {"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/os/"},
+	}
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+	}{
+		{"default", CrawlConfig{}},
+		{"many workers", CrawlConfig{MaxWorkers: 8}},
+		{"breadth-first", CrawlConfig{BreadthFirst: true}},
+		{"sequential", CrawlConfig{Sequential: true}},
+	}
+	for _, tt := range tests {
+		got := make(map[string][]string)
+		for _, r := range crawlRawData(t, tt.cfg) {
+			got[r.URL] = r.Path
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: paths\n%q\nwant\n%q", tt.name, got, want)
+		}
+	}
+}
+
+func TestAlreadyFetchedErrorSkips(t *testing.T) {
+	const root = "http://example.com/"
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"pointer", &AlreadyFetchedError{root + "a"}},
+		{"value", AlreadyFetchedError{root + "a"}},
+		{"wrapped pointer", fmt.Errorf("cache: %w", &AlreadyFetchedError{root + "a"})},
+		{"wrapped value", fmt.Errorf("cache: %w", AlreadyFetchedError{root + "a"})},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(map[string][]string{root: {root + "a"}})
+		f.SetError(root+"a", tt.err)
+		var stats CrawlStats
+		pages, err := CrawlErrors(context.Background(), root, 1, f, WithStats(&stats))
+		if err != nil || len(pages) != 1 {
+			t.Errorf("%s: crawled %d pages, error %v; want the root and no error", tt.name, len(pages), err)
+		}
+		if stats.PagesSkipped != 1 || stats.PagesFailed != 0 {
+			t.Errorf("%s: %d skipped, %d failed; want 1 skipped", tt.name, stats.PagesSkipped, stats.PagesFailed)
+		}
+	}
+}
+
+func TestErrNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	hf := NewHTTPFetcher(srv.Client())
+
+	tests := []struct {
+		name     string
+		fetcher  Fetcher
+		url      string
+		notFound bool
+	}{
+		{"rawData", myFetcher{}, "https://golang.org/cmd/", true},
+		{"404", hf, srv.URL + "/missing", true},
+		{"410", hf, srv.URL + "/gone", true},
+		{"500", hf, srv.URL + "/broken", false},
+	}
+	for _, tt := range tests {
+		_, _, err := tt.fetcher.Fetch(context.Background(), tt.url)
+		if err == nil {
+			t.Errorf("%s: Fetch(%q) succeeded", tt.name, tt.url)
+			continue
+		}
+		if got := errors.Is(err, ErrNotFound); got != tt.notFound {
+			t.Errorf("%s: errors.Is(%v, ErrNotFound) = %v, want %v", tt.name, err, got, tt.notFound)
+		}
+
+		// and still once the crawl has wrapped it in a *FetchError
+		_, err = CrawlErrors(context.Background(), tt.url, 0, tt.fetcher)
+		var fe *FetchError
+		if !errors.As(err, &fe) || errors.Is(err, ErrNotFound) != tt.notFound {
+			t.Errorf("%s: crawl error %v, want a *FetchError, not found %v", tt.name, err, tt.notFound)
+		}
+	}
+}
+
+func TestFetchErrorContext(t *testing.T) {
+	tests := []struct {
+		name string
+		seed string
+		want FetchError
+		msg  string
+	}{
+		{"linked", "https://golang.org/", FetchError{URL: "https://golang.org/cmd/", Depth: 1, Parent: "https://golang.org/"},
+			"fetch https://golang.org/cmd/ (depth 1, parent https://golang.org/): "},
+		{"seed", "https://golang.org/cmd/", FetchError{URL: "https://golang.org/cmd/"},
+			"fetch https://golang.org/cmd/ (depth 0, seed): "},
+	}
+	for _, tt := range tests {
+		_, err := CrawlErrors(context.Background(), tt.seed, 4, myFetcher{})
+		var fe *FetchError
+		if !errors.As(err, &fe) {
+			t.Fatalf("%s: crawl error %v, want a *FetchError", tt.name, err)
+		}
+		if fe.URL != tt.want.URL || fe.Depth != tt.want.Depth || fe.Parent != tt.want.Parent {
+			t.Errorf("%s: %+v, want %+v", tt.name, *fe, tt.want)
+		}
+		if !strings.HasPrefix(fe.Error(), tt.msg) || !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: error %q, want %q and ErrNotFound", tt.name, fe.Error(), tt.msg)
+		}
+	}
+}
+
+// bodyFetcher fetches the pages of a graph with the bodies given,
+// rather than the FakeFetcher's.
+type bodyFetcher struct {
+	*crawltest.FakeFetcher
+	bodies map[string]string
+}
+
+func (f bodyFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	_, urls, err := f.FakeFetcher.Fetch(ctx, url)
+	return f.bodies[url], urls, err
+}
+
+func TestEmptyPages(t *testing.T) {
+	const root = "http://example.com/"
+	tests := []struct {
+		name  string
+		body  string
+		links []string
+		empty bool
+	}{
+		{"nil urls", "", nil, true},
+		{"no urls", "", []string{}, true},
+		{"white space", " \n\t\r\n ", nil, true},
+		{"text", "hello", nil, false},
+		{"links", "", []string{root + "a"}, false},
+	}
+	for _, tt := range tests {
+		f := bodyFetcher{
+			crawltest.NewFakeFetcher(map[string][]string{root: tt.links, root + "a": nil}),
+			map[string]string{root: tt.body, root + "a": "a"},
+		}
+		results, err := Crawl(context.Background(), root, CrawlConfig{Fetcher: f, Depth: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []CrawlResult
+		for r := range results {
+			got = append(got, r)
+		}
+		// the page is sent, not suppressed, and its links followed
+		if len(got) != 1+len(tt.links) {
+			t.Fatalf("%s: %d results, want %d", tt.name, len(got), 1+len(tt.links))
+		}
+		i := slices.IndexFunc(got, func(r CrawlResult) bool { return r.URL == root })
+		if r := got[i]; r.Err != nil || r.Body != tt.body || r.Empty != tt.empty {
+			t.Errorf("%s: result %q, %v, Empty %v; want Empty %v", tt.name, r.Body, r.Err, r.Empty, tt.empty)
+		}
+	}
+}
+
+func TestSoftNotFound(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root:             {root + "missing", root + "a"},
+		root + "missing": {root + "junk"},
+		root + "a":       nil,
+		root + "junk":    nil,
+	}
+	bodies := map[string]string{
+		root:             "home",
+		root + "missing": "<h1>404 Not Found</h1> <a href=/junk>try this</a>",
+		root + "a":       "a",
+		root + "junk":    "junk",
+	}
+	tests := []struct {
+		name   string
+		detect func(string) bool
+		soft   bool
+	}{
+		{"no detector", nil, false},
+		{"detector", func(body string) bool { return strings.Contains(body, "404 Not Found") }, true},
+	}
+	for _, tt := range tests {
+		f := bodyFetcher{crawltest.NewFakeFetcher(graph), bodies}
+		pages, err := CrawlErrors(context.Background(), root, 4, f, WithSoftNotFoundDetector(tt.detect))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		for _, p := range pages {
+			if p.SoftNotFound != (tt.soft && p.URL == root+"missing") {
+				t.Errorf("%s: %s has SoftNotFound %v", tt.name, p.URL, p.SoftNotFound)
+			}
+		}
+		// the missing page is still recorded, but its links aren't followed
+		if n := f.Fetches(root + "missing"); n != 1 {
+			t.Errorf("%s: missing page fetched %d times, want once", tt.name, n)
+		}
+		if got := f.Fetches(root+"junk") == 1; got == tt.soft {
+			t.Errorf("%s: link from the missing page followed %v, want %v", tt.name, got, !tt.soft)
+		}
+	}
+}
+
+func TestFetcherPanic(t *testing.T) {
+	checkGoroutines(t)
+	tests := []struct {
+		name    string
+		url     string
+		workers int
+		want    int // pages crawled without error
+	}{
+		{"child", "https://golang.org/pkg/", DefaultMaxWorkers, 1},
+		{"leaf", "https://golang.org/pkg/fmt/", DefaultMaxWorkers, 3},
+		{"leaf, one worker", "https://golang.org/pkg/fmt/", 1, 3},
+		{"seed", "https://golang.org/", DefaultMaxWorkers, 0},
+	}
+	for _, tt := range tests {
+		f := panickingFetcher{crawltest.NewFakeFetcher(rawDataGraph()), tt.url}
+		results, err := Crawl(context.Background(), "https://golang.org/", CrawlConfig{
+			Fetcher: f, Depth: Unlimited, MaxWorkers: tt.workers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		var ok int
+		var panicErr error
+		go func() {
+			defer close(done)
+			for r := range results {
+				var pe *FetcherPanicError
+				switch {
+				case errors.As(r.Err, &pe):
+					panicErr = r.Err
+				case r.Err == nil:
+					ok++
+				}
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: crawl still running 5s after the fetcher panicked", tt.name)
+		}
+		if panicErr == nil || !strings.Contains(panicErr.Error(), tt.url) || !strings.Contains(panicErr.Error(), "fetcher bug") {
+			t.Errorf("%s: panic reported as %v", tt.name, panicErr)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: %d pages crawled, want %d", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	// every page links on around the site, back to the seed in the end
+	graph := syntheticGraph(40, 2)
+	level := levels(graph, "http://example.com/p0")
+	for _, depth := range []int{0, 1, 2, 3, Unlimited} {
+		var want int
+		for _, l := range level {
+			if depth == Unlimited || l <= depth {
+				want++
+			}
+		}
+		f := crawltest.NewFakeFetcher(graph)
+		pages, err := CrawlErrors(context.Background(), "http://example.com/p0", depth, f)
+		if err != nil {
+			t.Errorf("depth %d: %v", depth, err)
+		}
+		if len(pages) != want {
+			t.Errorf("depth %d: crawled %d pages, want %d", depth, len(pages), want)
+		}
+		for u := range graph {
+			if n := f.Fetches(u); n > 1 {
+				t.Errorf("depth %d: %s fetched %d times", depth, u, n)
+			}
+		}
+	}
+}
+
+func TestOnFetch(t *testing.T) {
+	graph := syntheticGraph(100, 4)
+	f := crawltest.NewFakeFetcher(graph)
+	f.SetError("http://example.com/p7", errors.New("server down"))
+	f.SetError("http://example.com/p9", &AlreadyFetchedError{"http://example.com/p9"})
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	var failed int
+	onFetch := func(url string, depth int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[url]++
+		if err != nil {
+			failed++
+		}
+	}
+	cfg := CrawlConfig{Fetcher: f, Depth: Unlimited, MaxWorkers: 8, OnFetch: onFetch, CountOnly: true}
+	results, err := Crawl(context.Background(), "http://example.com/p0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range results {
+	}
+
+	var n int
+	for u := range graph {
+		if calls[u] != f.Fetches(u) {
+			t.Errorf("%s: OnFetch called %d times for %d fetches", u, calls[u], f.Fetches(u))
+		}
+		n += calls[u]
+	}
+	if n != totalFetches(f, graph) || failed != 2 {
+		t.Errorf("OnFetch called %d times, %d with errors; want %d and 2", n, failed, totalFetches(f, graph))
+	}
+}
+
+func TestCrawlSeeds(t *testing.T) {
+	graph := map[string][]string{
+		"http://a.example/":       {"http://a.example/x", "http://shared.example/"},
+		"http://b.example/":       {"http://b.example/y", "http://shared.example/"},
+		"http://a.example/x":      nil,
+		"http://b.example/y":      nil,
+		"http://shared.example/":  {"http://shared.example/z"},
+		"http://shared.example/z": nil,
+	}
+	seeds := []string{"http://a.example/", "http://b.example/"}
+	tests := []struct {
+		name     string
+		maxPages int
+		want     int
+	}{
+		{"whole graph", 0, 6},
+		{"max pages across seeds", 3, 3},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		var stats CrawlStats
+		cfg := CrawlConfig{Fetcher: f, Depth: 2, MaxPages: tt.maxPages, Stats: &stats}
+		results, err := CrawlSeeds(context.Background(), seeds, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got int
+		for r := range results {
+			got++
+			if r.Depth == 2 && r.ParentURL != "" {
+				t.Errorf("%s: %s has depth 2, like a seed, but parent %s", tt.name, r.URL, r.ParentURL)
+			}
+		}
+		if got != tt.want || stats.PagesFetched != tt.want || totalFetches(f, graph) != tt.want {
+			t.Errorf("%s: %d results, %d fetched, %d fetches; want %d",
+				tt.name, got, stats.PagesFetched, totalFetches(f, graph), tt.want)
+		}
+		if n := f.Fetches("http://shared.example/"); n > 1 {
+			t.Errorf("%s: the shared page was fetched %d times", tt.name, n)
+		}
+	}
+}
+
+func TestDryRunAndCountOnly(t *testing.T) {
+	var full CrawlStats
+	want := crawlRawData(t, CrawlConfig{Stats: &full})
+	full.Elapsed = 0
+	links := make(map[string][]string)
+	for _, r := range want {
+		links[r.URL] = r.Links
+	}
+
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+		n    int // results
+	}{
+		{"dry run", CrawlConfig{DryRun: true}, len(want)},
+		{"count only", CrawlConfig{CountOnly: true}, 0},
+		{"both", CrawlConfig{DryRun: true, CountOnly: true}, 0},
+	}
+	for _, tt := range tests {
+		var stats CrawlStats
+		cfg := tt.cfg
+		cfg.Stats = &stats
+		results := crawlRawData(t, cfg)
+		if len(results) != tt.n {
+			t.Errorf("%s: %d results, want %d", tt.name, len(results), tt.n)
+		}
+		for _, r := range results {
+			if r.Body != "" {
+				t.Errorf("%s: %s has a body", tt.name, r.URL)
+			}
+			// the links are the same as with bodies, for the graph
+			if !slices.Equal(r.Links, links[r.URL]) {
+				t.Errorf("%s: %s links to %q, want %q", tt.name, r.URL, r.Links, links[r.URL])
+			}
+		}
+		// bodies are still fetched, and counted
+		stats.Elapsed = 0
+		if !reflect.DeepEqual(stats, full) {
+			t.Errorf("%s: stats\n%+v\nwant\n%+v", tt.name, stats, full)
+		}
+	}
+}
+
+func TestRawDataConcurrentCrawls(t *testing.T) {
+	// a caller changing the links it is given doesn't change rawData
+	_, urls, err := myFetcher{}.Fetch(context.Background(), "https://golang.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls[0] = "https://example.com/"
+	if rawData["https://golang.org/"].urls[0] != "https://golang.org/pkg/" {
+		t.Fatal("changing the links Fetch returned changed rawData")
+	}
+
+	// run with -race: many crawls at once share rawData
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			cfg := CrawlConfig{Fetcher: myFetcher{}, Depth: Unlimited, MaxWorkers: 8}
+			results, err := Crawl(context.Background(), "https://golang.org/", cfg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var n int
+			for r := range results {
+				n++
+				for i := range r.Links {
+					r.Links[i] += "#changed"
+				}
+			}
+			if n != len(rawData)+1 {
+				t.Errorf("crawled %d pages, want %d", n, len(rawData)+1)
+			}
+		})
+	}
+	wg.Wait()
+	if !reflect.DeepEqual(rawDataGraph(), map[string][]string{
+		"https://golang.org/":         {"https://golang.org/pkg/", "https://golang.org/cmd/"},
+		"https://golang.org/pkg/":     {"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"},
+		"https://golang.org/pkg/fmt/": {"https://golang.org/", "https://golang.org/pkg/"},
+		"https://golang.org/pkg/os/":  {"https://golang.org/", "https://golang.org/pkg/"},
+	}) {
+		t.Errorf("the crawls changed rawData: %q", rawDataGraph())
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "err" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	crawlRawData(t, CrawlConfig{Logger: logger, Sequential: true})
+
+	want := []string{
+		`level=INFO msg=found url=https://golang.org/ depth=4 links=2`,
+		`level=WARN msg="fetch failed" url=https://golang.org/cmd/ depth=3`,
+		`level=INFO msg=found url=https://golang.org/pkg/ depth=3 links=4`,
+		`level=INFO msg=found url=https://golang.org/pkg/fmt/ depth=2 links=2`,
+		`level=INFO msg=found url=https://golang.org/pkg/os/ depth=2 links=2`,
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); !slices.Equal(got, want) {
+		t.Errorf("logged\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// the default logs nothing, but doesn't fail either
+	if results := crawlRawData(t, CrawlConfig{}); len(results) != len(want) {
+		t.Errorf("%d results without a Logger, want %d", len(results), len(want))
+	}
+}
+
+func TestSequential(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph map[string][]string
+		seed  string
+		order []string // the order of the results, if known
+	}{
+		{"rawData", rawDataGraph(), "https://golang.org/", []string{
+			"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/",
+			"https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+		{"synthetic", syntheticGraph(200, 5), "http://example.com/p0", nil},
+	}
+	for _, tt := range tests {
+		var runs [2]bytes.Buffer
+		for i := range runs {
+			f := crawltest.NewFakeFetcher(tt.graph)
+			results, err := Crawl(context.Background(), tt.seed, CrawlConfig{
+				Fetcher: f, Depth: Unlimited, MaxWorkers: 8, Sequential: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for r := range results {
+				fmt.Fprintf(&runs[i], "%s %d %q %v\n", r.URL, r.Depth, r.Body, r.Err)
+				got = append(got, r.URL)
+			}
+			if tt.order != nil && !slices.Equal(got, tt.order) {
+				t.Errorf("%s: crawled in the order %q, want %q", tt.name, got, tt.order)
+			}
+		}
+		if !bytes.Equal(runs[0].Bytes(), runs[1].Bytes()) {
+			t.Errorf("%s: sequential crawls differ:\n%s\nand\n%s", tt.name, runs[0].Bytes(), runs[1].Bytes())
+		}
+	}
+}
+
+func TestDepthLimited(t *testing.T) {
+	tests := []struct {
+		depth int
+		want  []string // the pages marked DepthLimited
+	}{
+		{0, []string{"https://golang.org/"}},
+		// golang.org/cmd/ failed, and has no links
+		{1, []string{"https://golang.org/pkg/"}},
+		{2, []string{"https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"}},
+		// every link from those leads back to pages crawled already
+		{3, nil},
+		{Unlimited, nil},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(rawDataGraph())
+		pages, _ := CrawlErrors(context.Background(), "https://golang.org/", tt.depth, f)
+		var got []string
+		for _, r := range pages {
+			if r.DepthLimited {
+				got = append(got, r.URL)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("depth %d: DepthLimited %q, want %q", tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestCrawlerPause(t *testing.T) {
+	graph := syntheticGraph(100, 3)
+	f := crawltest.NewFakeFetcher(graph)
+	for u := range graph {
+		f.SetDelay(u, 2*time.Millisecond)
+	}
+	cr, err := NewCrawler([]string{"http://example.com/p0"}, CrawlConfig{Fetcher: f, Depth: Unlimited, MaxWorkers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := cr.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int)
+	go func() {
+		var n int
+		for range results {
+			n++
+		}
+		done <- n
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cr.Pause()
+	// let the fetches in progress finish
+	time.Sleep(20 * time.Millisecond)
+	paused := totalFetches(f, graph)
+	time.Sleep(50 * time.Millisecond)
+	if n := totalFetches(f, graph); n != paused {
+		t.Errorf("%d fetches while paused", n-paused)
+	}
+	if paused == 0 || paused == len(graph) {
+		t.Errorf("paused after %d fetches, want part of the crawl", paused)
+	}
+
+	cr.Resume()
+	select {
+	case n := <-done:
+		if n != len(graph) || totalFetches(f, graph) != len(graph) {
+			t.Errorf("resumed crawl gave %d results from %d fetches, want %d", n, totalFetches(f, graph), len(graph))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl didn't finish once resumed")
+	}
+}
+
+func TestCrawlerSnapshot(t *testing.T) {
+	graph := syntheticGraph(100, 3)
+	f := crawltest.NewFakeFetcher(graph)
+	for u := range graph {
+		f.SetDelay(u, 2*time.Millisecond)
+	}
+	cr, err := NewCrawler([]string{"http://example.com/p0"}, CrawlConfig{Fetcher: f, Depth: Unlimited, MaxWorkers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cr.Snapshot(); s != (CrawlSnapshot{}) {
+		t.Errorf("snapshot before the crawl: %+v", s)
+	}
+	results, err := cr.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		for range results {
+		}
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cr.Pause()
+	time.Sleep(20 * time.Millisecond)
+	s := cr.Snapshot()
+	if s.Completed == 0 || s.Queued == 0 || s.InFlight != 0 {
+		t.Errorf("paused snapshot %+v, want completed and queued pages with none in flight", s)
+	}
+	if s.Visited < s.Completed+s.Queued+s.InFlight {
+		t.Errorf("paused snapshot %+v: fewer visited than completed, queued and in flight", s)
+	}
+	if again := cr.Snapshot(); again != s {
+		t.Errorf("paused crawl moved from %+v to %+v", s, again)
+	}
+
+	cr.Resume()
+	<-done
+	want := CrawlSnapshot{Visited: len(graph), Completed: len(graph)}
+	if s := cr.Snapshot(); s != want {
+		t.Errorf("finished snapshot %+v, want %+v", s, want)
+	}
+}
+
+func TestCrawlerClose(t *testing.T) {
+	checkGoroutines(t)
+	graph := syntheticGraph(100, 3)
+	// the mid-crawl fetches hang after the first 10, so that the
+	// crawl can't finish before it is closed
+	hanging := &hangingFetcher{n: 10}
+	tests := []struct {
+		name  string
+		start func(cr *Crawler) error // nil if the crawl isn't started
+		want  error                   // from start
+	}{
+		{"before the start", nil, nil},
+		{"after the crawl", func(cr *Crawler) error { return cr.Run(context.Background()) }, nil},
+		{"mid-crawl", func(cr *Crawler) error {
+			done := make(chan error)
+			go func() { done <- cr.Run(context.Background()) }()
+			for hanging.fetches.Load() <= hanging.n {
+				time.Sleep(time.Millisecond)
+			}
+			cr.Close()
+			return <-done
+		}, ErrClosed},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		for u := range graph {
+			f.SetDelay(u, time.Millisecond)
+		}
+		var cf Fetcher = f
+		if tt.want == ErrClosed {
+			hanging.Fetcher, cf = f, hanging
+		}
+		cr, err := NewCrawler([]string{"http://example.com/p0"}, CrawlConfig{Fetcher: cf, Depth: Unlimited, CountOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.start != nil {
+			if err := tt.start(cr); err != tt.want {
+				t.Errorf("%s: crawl returned %v, want %v", tt.name, err, tt.want)
+			}
+		}
+		// again and again
+		for range 3 {
+			if err := cr.Close(); err != nil {
+				t.Errorf("%s: Close: %v", tt.name, err)
+			}
+		}
+		if _, ok := <-cr.Results(); ok {
+			t.Errorf("%s: the results channel is open after Close", tt.name)
+		}
+		if tt.start == nil {
+			if err := cr.Run(context.Background()); err == nil || totalFetches(f, graph) != 0 {
+				t.Errorf("%s: Run after Close: %v, %d fetches; want an error and none", tt.name, err, totalFetches(f, graph))
+			}
+		}
+	}
+}
+
+func TestCrawlerCloseConnections(t *testing.T) {
+	var mu sync.Mutex
+	open := 0 // connections to the server
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/a">a</a> <a href="/b">b</a>`)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case http.StateNew:
+			open++
+		case http.StateClosed, http.StateHijacked:
+			open--
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	checkGoroutines(t)
+
+	fetchers := []struct {
+		name string
+		new  func(*http.Client) Fetcher
+	}{
+		{"HTTPFetcher", func(c *http.Client) Fetcher { return NewHTTPFetcher(c) }},
+		// Close finds the HTTPFetcher under the wrappers
+		{"wrapped", func(c *http.Client) Fetcher {
+			return Chain(NewHTTPFetcher(c), Retry(2, time.Millisecond), Timeout(time.Minute), RateLimit(time.Microsecond))
+		}},
+	}
+	for _, tt := range fetchers {
+		for range 50 {
+			// a Transport of its own, as each crawler of a long-running
+			// process might have
+			client := &http.Client{Transport: &http.Transport{}}
+			cr, err := NewCrawler([]string{srv.URL + "/"}, CrawlConfig{Fetcher: tt.new(client), Depth: 1, CountOnly: true, MaxWorkers: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cr.Run(context.Background()); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			cr.Close()
+		}
+		// the server sees the closes a moment after they're made
+		deadline := time.Now().Add(time.Second)
+		mu.Lock()
+		for open > 0 && time.Now().Before(deadline) {
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+		}
+		if open > 0 {
+			t.Errorf("%s: %d connections still open after closing every crawler", tt.name, open)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestCrawlerRunErrgroup(t *testing.T) {
+	tests := []struct {
+		name  string
+		fail  string // the url to fail, if any
+		fatal bool
+	}{
+		{"no failures", "", false},
+		{"page failure", "https://golang.org/pkg/", false},
+		{"seed failure", "https://golang.org/", true},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(rawDataGraph())
+		if tt.fail != "" {
+			f.SetError(tt.fail, errors.New("server on fire"))
+		}
+		cr, err := NewCrawler([]string{"https://golang.org/"}, CrawlConfig{Fetcher: f, Depth: Unlimited})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		g, ctx := errgroup.WithContext(context.Background())
+		g.Go(func() error { return cr.Run(ctx) })
+		var failed []string
+		g.Go(func() error {
+			for r := range cr.Results() {
+				if r.Err != nil {
+					failed = append(failed, r.URL)
+				}
+			}
+			return nil
+		})
+		// another task of the group, stopped by a failed crawl
+		cancelled := make(chan bool, 1)
+		g.Go(func() error {
+			select {
+			case <-ctx.Done():
+				cancelled <- true
+			case <-time.After(100 * time.Millisecond):
+				cancelled <- false
+			}
+			return nil
+		})
+		err = g.Wait()
+
+		var fe *FetchError
+		if got := errors.As(err, &fe); got != tt.fatal || got && fe.URL != tt.fail {
+			t.Errorf("%s: group error %v, want a *FetchError for the seed %v", tt.name, err, tt.fatal)
+		}
+		if got := <-cancelled; got != tt.fatal {
+			t.Errorf("%s: rest of the group cancelled %v, want %v", tt.name, got, tt.fatal)
+		}
+		// golang.org/cmd/ is not found whenever the root is
+		want := []string{"https://golang.org/cmd/"}
+		if tt.fail != "" {
+			want = append(want, tt.fail)
+		}
+		if tt.fatal {
+			want = []string{tt.fail}
+		}
+		slices.Sort(failed)
+		slices.Sort(want)
+		if !slices.Equal(failed, want) {
+			t.Errorf("%s: failed pages %q, want %q", tt.name, failed, want)
+		}
+	}
+}
+
+func TestCrawlerAll(t *testing.T) {
+	checkGoroutines(t)
+	graph := syntheticGraph(100, 3)
+	tests := []struct {
+		name    string
+		breakAt int // 0 to range over the whole crawl
+	}{
+		{"whole crawl", 0},
+		{"break early", 5},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		for u := range graph {
+			f.SetDelay(u, time.Millisecond)
+		}
+		cr, err := NewCrawler([]string{"http://example.com/p0"}, CrawlConfig{Fetcher: f, Depth: Unlimited, MaxWorkers: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for r, err := range cr.All(context.Background()) {
+			if err != nil {
+				t.Errorf("%s: %s: %v", tt.name, r.URL, err)
+			}
+			if n++; n == tt.breakAt {
+				break
+			}
+		}
+
+		fetched := totalFetches(f, graph)
+		if tt.breakAt == 0 {
+			if n != len(graph) || fetched != len(graph) {
+				t.Errorf("%s: %d results from %d fetches, want %d", tt.name, n, fetched, len(graph))
+			}
+			continue
+		}
+		// the crawl stopped when the loop did
+		time.Sleep(20 * time.Millisecond)
+		if later := totalFetches(f, graph); later != fetched || fetched >= len(graph) {
+			t.Errorf("%s: %d fetches at the break, %d after; want the crawl cancelled", tt.name, fetched, later)
+		}
+	}
+}
+
+func TestOnFrontier(t *testing.T) {
+	tests := []struct {
+		name  string
+		seed  string
+		graph map[string][]string
+		depth int
+		want  []string // crawled
+	}{
+		{"synthetic", "http://example.com/p0", syntheticGraph(50, 3), Unlimited, slices.Sorted(maps.Keys(syntheticGraph(50, 3)))},
+		// the links of the depth 0 pages aren't passed on
+		{"rawData, depth 1", "https://golang.org/", rawDataGraph(), 1,
+			[]string{"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/"}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(tt.graph)
+		type link struct {
+			url   string
+			depth int
+		}
+		// the external queue
+		frontier := make(chan link, 1000)
+		cr, err := NewCrawler(nil, CrawlConfig{
+			Fetcher: f, Depth: tt.depth, MaxWorkers: 4,
+			OnFrontier: func(url string, depth int) { frontier <- link{url, depth} },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := cr.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cr.Submit(tt.seed, tt.depth); err != nil {
+			t.Fatal(err)
+		}
+
+		passed := make(map[string]int)
+		var got []string
+		for len(got) < len(tt.want) {
+			select {
+			case l := <-frontier:
+				passed[l.url]++
+				if l.depth != tt.depth && (tt.depth == Unlimited || l.depth >= tt.depth) {
+					t.Errorf("%s: %s passed on at depth %d", tt.name, l.url, l.depth)
+				}
+				if err := cr.Submit(l.url, l.depth); err != nil {
+					t.Errorf("%s: Submit(%s): %v", tt.name, l.url, err)
+				}
+			case r := <-results:
+				got = append(got, r.URL)
+			}
+		}
+		if err := cr.EndSubmit(); err != nil {
+			t.Error(err)
+		}
+		for r := range results {
+			got = append(got, r.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		for u, n := range passed {
+			if n != 1 || u == tt.seed {
+				t.Errorf("%s: %s passed to OnFrontier %d times", tt.name, u, n)
+			}
+		}
+		if len(passed) != len(tt.want)-1 {
+			t.Errorf("%s: %d urls passed to OnFrontier, want all but the seed", tt.name, len(passed))
+		}
+		if err := cr.Submit(tt.seed, tt.depth); err == nil {
+			t.Errorf("%s: Submit after the crawl succeeded", tt.name)
+		}
+	}
+
+	// it needs the hook
+	cr, err := NewCrawler([]string{"http://example.com/"}, CrawlConfig{Fetcher: crawltest.NewFakeFetcher(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+	if cr.Submit("http://example.com/", 1) == nil || cr.EndSubmit() == nil {
+		t.Error("Submit and EndSubmit accepted without OnFrontier")
+	}
+}
+
+func TestDiamondFetchedOnce(t *testing.T) {
+	graph := map[string][]string{
+		"http://example.com/a": {"http://example.com/b", "http://example.com/c"},
+		"http://example.com/b": {"http://example.com/d"},
+		"http://example.com/c": {"http://example.com/d"},
+		"http://example.com/d": {"http://example.com/a"},
+	}
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+	}{
+		{"default", CrawlConfig{}},
+		{"many workers", CrawlConfig{MaxWorkers: 16}},
+		{"breadth-first", CrawlConfig{MaxWorkers: 16, BreadthFirst: true}},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			f := crawltest.NewFakeFetcher(graph)
+			cfg := tt.cfg
+			cfg.Fetcher, cfg.Depth, cfg.CountOnly = f, Unlimited, true
+			results, err := Crawl(context.Background(), "http://example.com/a", cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range results {
+			}
+			for u := range graph {
+				if n := f.Fetches(u); n != 1 {
+					t.Fatalf("%s: %s fetched %d times, want once", tt.name, u, n)
+				}
+			}
+		}
+	}
+}
+
+func TestDuplicateLinksOnPage(t *testing.T) {
+	const root = "https://golang.org/"
+	tests := []struct {
+		name  string
+		links []string
+	}{
+		{"repeated", []string{root + "pkg/", root + "pkg/", root + "pkg/"}},
+		{"same once normalized", []string{root + "pkg/", "HTTPS://golang.org/pkg/", root + "pkg/#top"}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(map[string][]string{root: tt.links, root + "pkg/": nil})
+		attempts := 0
+		stats := new(CrawlStats)
+		_, err := CrawlErrors(context.Background(), root, 1, f, WithStats(stats),
+			WithShouldFollow(func(url string, depth int) bool {
+				if url != root {
+					attempts++
+				}
+				return true
+			}))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if attempts != 1 || f.Fetches(root+"pkg/") != 1 || stats.PagesSkipped != 0 {
+			t.Errorf("%s: %d enqueue attempts, %d fetches, %d skipped; want one enqueue and fetch",
+				tt.name, attempts, f.Fetches(root+"pkg/"), stats.PagesSkipped)
+		}
+	}
+}
+
+func TestSelfLinks(t *testing.T) {
+	const root = "https://golang.org/"
+	tests := []struct {
+		name    string
+		graph   map[string][]string
+		self    int
+		skipped int
+	}{
+		{"none", rawDataGraph(), 0, 6},
+		// as a logo links home from the home page
+		{"the home page", map[string][]string{root: {root, root + "pkg/"}, root + "pkg/": {root}}, 1, 1},
+		{"same once normalized", map[string][]string{root: {"HTTPS://golang.org/#top", root + "pkg/"}, root + "pkg/": nil}, 1, 0},
+		{"every page", map[string][]string{
+			root:           {root, root + "pkg/"},
+			root + "pkg/":  {root + "pkg/", root + "pkg/a"},
+			root + "pkg/a": {root + "pkg/a", root + "pkg/a", root},
+		}, 3, 1},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(tt.graph)
+		var stats CrawlStats
+		var attempts, duplicates []string
+		cfg := CrawlConfig{
+			Fetcher: f, Depth: Unlimited, Stats: &stats, Sequential: true,
+			ShouldFollow: func(url string, depth int) bool {
+				attempts = append(attempts, url)
+				return true
+			},
+			OnDuplicateLink: func(e LinkEvent) { duplicates = append(duplicates, e.To) },
+		}
+		results, err := Crawl(context.Background(), root, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crawled := 0
+		for range results {
+			crawled++
+		}
+		if stats.SelfLinks != tt.self || stats.PagesSkipped != tt.skipped {
+			t.Errorf("%s: %d self links, %d skipped; want %d, %d", tt.name, stats.SelfLinks, stats.PagesSkipped, tt.self, tt.skipped)
+		}
+		// not refetched, nor even queued
+		for u := range tt.graph {
+			if n := f.Fetches(u); n > 1 {
+				t.Errorf("%s: %s fetched %d times", tt.name, u, n)
+			}
+		}
+		if len(duplicates) != tt.skipped || len(attempts) != crawled+tt.skipped {
+			t.Errorf("%s: %d duplicate links reported, links to %q considered; want only those that aren't self links",
+				tt.name, len(duplicates), attempts)
+		}
+	}
+}
+
+func TestOnDuplicateLink(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root + "a": {root + "b", root + "c"},
+		root + "b": {root + "d"},
+		root + "c": {root + "d"},
+		root + "d": nil,
+	}
+	want := []LinkEvent{{root + "a", root + "b"}, {root + "a", root + "c"}, {root + "b", root + "d"}, {root + "c", root + "d"}}
+	for range 20 {
+		f := crawltest.NewFakeFetcher(graph)
+		var mu sync.Mutex
+		var edges, dups []LinkEvent
+		pages, err := CrawlErrors(context.Background(), root+"a", Unlimited, f, WithOnDuplicateLink(func(e LinkEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			dups = append(dups, e)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range pages {
+			if p.ParentURL != "" {
+				edges = append(edges, LinkEvent{p.ParentURL, p.URL})
+			}
+		}
+		if len(dups) != 1 || dups[0].To != root+"d" || f.Fetches(root+"d") != 1 {
+			t.Fatalf("duplicate links %q, d fetched %d times; want the second link to d, fetched once", dups, f.Fetches(root+"d"))
+		}
+		edges = append(edges, dups...)
+		slices.SortFunc(edges, func(a, b LinkEvent) int { return cmp.Or(strings.Compare(a.From, b.From), strings.Compare(a.To, b.To)) })
+		if !slices.Equal(edges, want) {
+			t.Fatalf("edges %q, want %q", edges, want)
+		}
+	}
+}
+
+// levels returns how many links from seed each page in graph is.
+func levels(graph map[string][]string, seed string) map[string]int {
+	level := map[string]int{seed: 0}
+	for queue := []string{seed}; len(queue) > 0; queue = queue[1:] {
+		for _, l := range graph[queue[0]] {
+			if _, ok := level[l]; !ok {
+				level[l] = level[queue[0]] + 1
+				queue = append(queue, l)
+			}
+		}
+	}
+	return level
+}
+
+// orderFetcher records the order its fetches start in.
+type orderFetcher struct {
+	Fetcher
+
+	mu    sync.Mutex
+	order []string
+}
+
+func (f *orderFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.mu.Lock()
+	f.order = append(f.order, url)
+	f.mu.Unlock()
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func TestBreadthFirst(t *testing.T) {
+	graph := syntheticGraph(300, 3)
+	level := levels(graph, "http://example.com/p0")
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+		want int
+	}{
+		{"one worker", CrawlConfig{MaxWorkers: 1}, 300},
+		{"many workers", CrawlConfig{MaxWorkers: 8}, 300},
+		{"sequential", CrawlConfig{Sequential: true}, 300},
+		{"max pages", CrawlConfig{MaxWorkers: 8, MaxPages: 40}, 40},
+		{"depth", CrawlConfig{MaxWorkers: 8, Depth: 2}, 13},
+	}
+	for _, tt := range tests {
+		f := &orderFetcher{Fetcher: crawltest.NewFakeFetcher(graph)}
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.BreadthFirst, cfg.CountOnly = f, true, true
+		if cfg.Depth == 0 {
+			cfg.Depth = Unlimited
+		}
+		results, err := Crawl(context.Background(), "http://example.com/p0", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range results {
+		}
+
+		if len(f.order) != tt.want {
+			t.Errorf("%s: fetched %d pages, want %d", tt.name, len(f.order), tt.want)
+		}
+		for i := 1; i < len(f.order); i++ {
+			if prev, cur := f.order[i-1], f.order[i]; level[cur] < level[prev] {
+				t.Errorf("%s: fetched %s, %d links from the seed, after %s, %d links from it",
+					tt.name, cur, level[cur], prev, level[prev])
+				break
+			}
+		}
+	}
+}
+
+// totalFetches returns how many fetches f has made of the pages in
+// graph.
+func totalFetches(f *crawltest.FakeFetcher, graph map[string][]string) int {
+	var n int
+	for u := range graph {
+		n += f.Fetches(u)
+	}
+	return n
+}
+
+func TestMaxPages(t *testing.T) {
+	graph := syntheticGraph(100, 5)
+	tests := []struct {
+		name     string
+		maxPages int
+		opts     []CrawlOption
+		want     int
+	}{
+		{"one", 1, nil, 1},
+		{"five", 5, nil, 5},
+		{"five sequential", 5, []CrawlOption{WithSequential()}, 5},
+		{"five breadth-first", 5, []CrawlOption{WithBreadthFirst()}, 5},
+		{"more than the site", 500, nil, 100},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		opts := append([]CrawlOption{WithMaxPages(tt.maxPages)}, tt.opts...)
+		pages, err := CrawlErrors(context.Background(), "http://example.com/p0", 10, f, opts...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if n := totalFetches(f, graph); n != tt.want || len(pages) != tt.want {
+			t.Errorf("%s: %d fetches, %d pages, want %d", tt.name, n, len(pages), tt.want)
+		}
+	}
+}
+
+func TestStableMaxPages(t *testing.T) {
+	graph := syntheticGraph(200, 5)
+	pages := func(ns ...int) []string {
+		var urls []string
+		for _, n := range ns {
+			urls = append(urls, fmt.Sprintf("http://example.com/p%d", n))
+		}
+		return slices.Sorted(slices.Values(urls))
+	}
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+		skip string // a url the fetcher skips
+		want []string
+	}{
+		// the first two levels, then the smallest urls of the third:
+		// p10 to p13 rather than p6 to p9
+		{"max pages", CrawlConfig{MaxPages: 10}, "", pages(0, 1, 2, 3, 4, 5, 10, 11, 12, 13)},
+		{"per host", CrawlConfig{PerHostPageLimit: 7}, "", pages(0, 1, 2, 3, 4, 5, 10)},
+		// a skipped page leaves its room to the next url
+		{"skipped", CrawlConfig{MaxPages: 10}, "http://example.com/p3", pages(0, 1, 2, 3, 4, 5, 10, 11, 12, 13, 14)},
+	}
+	for _, tt := range tests {
+		for run := range 10 {
+			f := crawltest.NewFakeFetcher(graph)
+			for u := range graph {
+				f.SetDelay(u, rand.N(2*time.Millisecond))
+			}
+			if tt.skip != "" {
+				f.SetError(tt.skip, &AlreadyFetchedError{tt.skip})
+			}
+			cfg := tt.cfg
+			cfg.Fetcher, cfg.Depth, cfg.MaxWorkers, cfg.StableMaxPages = f, Unlimited, 8, true
+			results, err := Crawl(context.Background(), "http://example.com/p0", cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range results {
+			}
+			var got []string
+			for u := range graph {
+				if f.Fetches(u) > 0 {
+					got = append(got, u)
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("%s, run %d: fetched %q, want %q", tt.name, run, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestPriority(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{root: nil, root + "pkg/x/z": nil}
+	for i := range 10 {
+		u := fmt.Sprintf("%sa%d", root, i)
+		graph[root] = append(graph[root], u)
+		graph[u] = nil
+		if i == 5 {
+			graph[root] = append(graph[root], root+"pkg/x", root+"pkg/y")
+		}
+	}
+	graph[root+"pkg/x"] = []string{root + "pkg/x/z", root + "a0"}
+	graph[root+"pkg/y"] = nil
+	pkgFirst := func(url string, depth int) int {
+		if strings.Contains(url, "/pkg/") {
+			return 1
+		}
+		return 0
+	}
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+		want []string // in order
+	}{
+		{"no priority", CrawlConfig{Sequential: true}, []string{root, root + "a0", root + "a1", root + "a2"}},
+		{"sequential", CrawlConfig{Sequential: true, Priority: pkgFirst},
+			[]string{root, root + "pkg/x", root + "pkg/x/z", root + "pkg/y"}},
+		{"one worker", CrawlConfig{MaxWorkers: 1, Priority: pkgFirst},
+			[]string{root, root + "pkg/x", root + "pkg/y", root + "pkg/x/z"}},
+		// only within each level
+		{"breadth-first", CrawlConfig{Sequential: true, BreadthFirst: true, Priority: pkgFirst},
+			[]string{root, root + "pkg/x", root + "pkg/y", root + "a0"}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth, cfg.MaxPages = f, Unlimited, 4
+		results, err := Crawl(context.Background(), root, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for r := range results {
+			got = append(got, r.URL)
+		}
+		if !cfg.Sequential {
+			// the one worker's fetches may finish in any order
+			slices.Sort(got)
+			tt.want = slices.Sorted(slices.Values(tt.want))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		if n := totalFetches(f, graph); n != 4 {
+			t.Errorf("%s: %d fetches, want 4", tt.name, n)
+		}
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	graph := syntheticGraph(10, 2)
+	size := int64(len(crawltest.Body("http://example.com/p0"))) // as for every page
+	tests := []struct {
+		name     string
+		maxBytes int64
+		want     int
+	}{
+		{"unlimited", 0, 10},
+		{"one byte", 1, 1},
+		{"three pages exactly", 3 * size, 3},
+		{"just over three pages", 3*size + 1, 4},
+		{"more than the site", 100 * size, 10},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		var stats CrawlStats
+		pages, err := CrawlErrors(context.Background(), "http://example.com/p0", Unlimited, f,
+			WithMaxBytes(tt.maxBytes), WithSequential(), WithStats(&stats))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if n := totalFetches(f, graph); n != tt.want || len(pages) != tt.want {
+			t.Errorf("%s: %d fetches, %d pages, want %d", tt.name, n, len(pages), tt.want)
+		}
+		if want := int64(tt.want) * size; stats.BytesFetched != want {
+			t.Errorf("%s: BytesFetched = %d, want %d", tt.name, stats.BytesFetched, want)
+		}
+	}
+}
+
+func TestMaxURLLength(t *testing.T) {
+	const root = "http://example.com/"
+	long := root + "cal?session=" + strings.Repeat("x", 5000-len(root+"cal?session="))
+	graph := map[string][]string{
+		root:               {root + "a", long, root + "b"},
+		root + "a":         nil,
+		root + "b":         nil,
+		long:               {root + "from-long"},
+		root + "from-long": nil,
+	}
+	tests := []struct {
+		name    string
+		seed    string
+		limit   int
+		want    int // pages crawled
+		skipped int
+	}{
+		{"unlimited", root, 0, 5, 0},
+		{"2048", root, 2048, 3, 1},
+		{"at the limit", root, 5000, 5, 0},
+		{"under the limit", root, 4999, 3, 1},
+		// the seeds are always crawled
+		{"long seed", long, 2048, 2, 0},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		var stats CrawlStats
+		pages, err := CrawlErrors(context.Background(), tt.seed, Unlimited, f, WithMaxURLLength(tt.limit), WithStats(&stats))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if len(pages) != tt.want || stats.SkippedLongURLs != tt.skipped {
+			t.Errorf("%s: crawled %d pages, skipped %d long urls; want %d, %d", tt.name, len(pages), stats.SkippedLongURLs, tt.want, tt.skipped)
+		}
+		if n := f.Fetches(long); tt.skipped > 0 && n != 0 {
+			t.Errorf("%s: the long url was fetched %d times", tt.name, n)
+		}
+	}
+}
+
+func TestMaxRepeatedSegments(t *testing.T) {
+	const root = "http://example.com/"
+	// a relative link that keeps extending the path, as on a
+	// misconfigured site: /cal/ links to /cal/cal/ and so on
+	graph := map[string][]string{
+		root:              {root + "cal/", root + "a/b/c/", root + "a/b/a/b/"},
+		root + "a/b/c/":   nil,
+		root + "a/b/a/b/": nil,
+	}
+	for i := 1; i <= 10; i++ {
+		page := root + strings.Repeat("cal/", i)
+		graph[page] = []string{page + "cal/"}
+	}
+	tests := []struct {
+		name  string
+		limit int
+		cal   int // pages of the trap crawled
+		traps int
+	}{
+		{"no limit", 0, 10, 0},
+		{"1", 1, 1, 1},
+		{"3", 3, 3, 1},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		var stats CrawlStats
+		pages, _ := CrawlErrors(context.Background(), root, Unlimited, f, WithMaxRepeatedSegments(tt.limit), WithStats(&stats))
+		cal := 0
+		for _, p := range pages {
+			if strings.Contains(p.URL, "/cal/") {
+				cal++
+			}
+		}
+		// the pages without repeats pass
+		if len(pages)-cal != 3 || cal != tt.cal || stats.SuspectedTraps != tt.traps {
+			t.Errorf("%s: crawled %d pages, %d of the trap, %d suspected; want 3 others, %d, %d",
+				tt.name, len(pages), cal, stats.SuspectedTraps, tt.cal, tt.traps)
+		}
+		if n := f.Fetches(root + strings.Repeat("cal/", tt.cal+1)); tt.limit > 0 && n != 0 {
+			t.Errorf("%s: fetched the trap page past the limit %d times", tt.name, n)
+		}
+	}
+}
+
+func TestMaxPathSegments(t *testing.T) {
+	const root = "http://example.com/"
+	links := []string{
+		root + "a", root + "a/b", root + "a/b/c/", root + "a/b/c/d",
+		root + "a/b/c/d/e/f", root + "x//y//z", root + "q?p=/a/b/c/d/e",
+	}
+	graph := map[string][]string{root: links, root + "s/e/e/d/": {root + "a/b/c/d/e/f"}}
+	for _, u := range links {
+		graph[u] = nil
+	}
+	tests := []struct {
+		name  string
+		seed  string
+		limit int
+		want  []string // links followed
+	}{
+		{"no limit", root, 0, links},
+		{"3", root, 3, []string{
+			root + "a", root + "a/b", root + "a/b/c/", root + "x//y//z", root + "q?p=/a/b/c/d/e",
+		}},
+		{"1", root, 1, []string{root + "a", root + "q?p=/a/b/c/d/e"}},
+		// the seed is crawled whatever its path
+		{"a deep seed", root + "s/e/e/d/", 3, nil},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		var stats CrawlStats
+		results, err := Crawl(context.Background(), tt.seed, CrawlConfig{Fetcher: f, Depth: 1, MaxPathSegments: tt.limit, Stats: &stats})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for r := range results {
+			if r.URL != tt.seed {
+				got = append(got, r.URL)
+			}
+		}
+		slices.Sort(got)
+		want := slices.Sorted(slices.Values(tt.want))
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: followed %q, want %q", tt.name, got, want)
+		}
+		if f.Fetches(tt.seed) != 1 {
+			t.Errorf("%s: the seed wasn't crawled", tt.name)
+		}
+		if skipped := len(graph[tt.seed]) - len(tt.want); stats.DeepPaths != skipped {
+			t.Errorf("%s: DeepPaths = %d, want %d", tt.name, stats.DeepPaths, skipped)
+		}
+	}
+}
+
+func TestMaxQueueLength(t *testing.T) {
+	const seed, width, bound = "http://example.com/", 50, 20
+	// a tree two levels deep, every page linking to width new ones
+	graph := map[string][]string{}
+	for i := range width {
+		child := fmt.Sprintf("%s%d", seed, i)
+		graph[seed] = append(graph[seed], child)
+		for j := range width {
+			leaf := fmt.Sprintf("%s/%d", child, j)
+			graph[child] = append(graph[child], leaf)
+			graph[leaf] = nil
+		}
+	}
+	f := crawltest.NewFakeFetcher(graph)
+	cr, err := NewCrawler([]string{seed}, CrawlConfig{
+		Fetcher: f, Depth: Unlimited, MaxWorkers: 4, MaxQueueLength: bound, CountOnly: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- cr.Run(context.Background()) }()
+
+	peak := 0
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+			peak = max(peak, cr.Snapshot().Queued)
+			time.Sleep(50 * time.Microsecond)
+		}
+	}
+	// the queue may outgrow the bound by the links along one path
+	if peak > bound+2*width {
+		t.Errorf("queue reached %d urls, want about %d", peak, bound)
+	}
+	if s := cr.Snapshot(); s.Completed != 1+width+width*width {
+		t.Errorf("crawled %d pages, want all %d", s.Completed, 1+width+width*width)
+	}
+}
+
+func TestMaxLinksPerPage(t *testing.T) {
+	const seed = "http://example.com/"
+	graph := map[string][]string{seed: nil}
+	for i := range 1000 {
+		u := fmt.Sprintf("%sp%d", seed, i)
+		graph[seed] = append(graph[seed], u)
+		graph[u] = nil
+	}
+	tests := []struct {
+		max, want, truncated int
+	}{
+		{0, 1000, 0},
+		{10, 10, 1},
+		{1000, 1000, 0},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		stats := new(CrawlStats)
+		pages, err := CrawlErrors(context.Background(), seed, 1, f, WithMaxLinksPerPage(tt.max), WithStats(stats))
+		if err != nil {
+			t.Errorf("max %d: %v", tt.max, err)
+		}
+		if n := totalFetches(f, graph) - 1; n != tt.want || len(pages)-1 != tt.want {
+			t.Errorf("max %d: followed %d links, %d pages; want %d", tt.max, n, len(pages)-1, tt.want)
+		}
+		// the first links are the ones followed
+		if tt.max > 0 && f.Fetches(graph[seed][tt.max-1]) != 1 {
+			t.Errorf("max %d: link %d wasn't followed", tt.max, tt.max)
+		}
+		if stats.TruncatedPages != tt.truncated {
+			t.Errorf("max %d: TruncatedPages = %d, want %d", tt.max, stats.TruncatedPages, tt.truncated)
+		}
+	}
+}
+
+func TestMaxFanOut(t *testing.T) {
+	const seed = "http://example.com/"
+	// a hub of 50 new links, the first of them to a page with one of
+	// its own
+	graph := map[string][]string{seed: {seed + "a"}, seed + "a": {seed + "deep"}, seed + "deep": {seed}}
+	for i := range 49 {
+		u := fmt.Sprintf("%sh%d", seed, i)
+		graph[seed] = append(graph[seed], u)
+		graph[u] = []string{seed, seed + "a"} // not new
+	}
+	first := []string{seed, seed + "a", seed + "deep", seed + "h0", seed + "h1", seed + "h2", seed + "h3"}
+	tests := []struct {
+		name         string
+		cfg          CrawlConfig
+		crawled      int
+		limitedPages int
+	}{
+		{"no limit", CrawlConfig{}, 52, 0},
+		{"dropped", CrawlConfig{MaxFanOut: 5}, len(first), 1},
+		{"deferred", CrawlConfig{MaxFanOut: 5, DeferFanOut: true}, 52, 1},
+		{"deferred, with room for 3 more", CrawlConfig{MaxFanOut: 5, DeferFanOut: true, MaxPages: len(first) + 3}, len(first) + 3, 1},
+		{"more than the links", CrawlConfig{MaxFanOut: 50}, 52, 0},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		var stats CrawlStats
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth, cfg.Sequential, cfg.Stats = f, Unlimited, true, &stats
+		results, err := Crawl(context.Background(), seed, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for r := range results {
+			got = append(got, r.URL)
+			if r.URL == seed && len(r.Links) != 50 {
+				t.Errorf("%s: the hub lists %d links, want all 50", tt.name, len(r.Links))
+			}
+		}
+		if len(got) != tt.crawled || totalFetches(f, graph) != tt.crawled {
+			t.Errorf("%s: crawled %d pages in %d fetches, want %d", tt.name, len(got), totalFetches(f, graph), tt.crawled)
+		}
+		// the hub's first five links are queued at once, and crawled with
+		// what they lead to before those held back
+		if tt.cfg.MaxFanOut == 5 {
+			if early := slices.Sorted(slices.Values(got[:min(len(got), len(first))])); !slices.Equal(early, first) {
+				t.Errorf("%s: crawled %q first, want %q", tt.name, early, first)
+			}
+		}
+		if stats.FanOutLimited != tt.limitedPages {
+			t.Errorf("%s: FanOutLimited = %d, want %d", tt.name, stats.FanOutLimited, tt.limitedPages)
+		}
+	}
+}
+
+func TestAbortOnError(t *testing.T) {
+	const root = "http://example.com/"
+	pages := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	graph := map[string][]string{root: nil}
+	for _, p := range pages {
+		graph[root] = append(graph[root], root+p)
+		graph[root+p] = []string{root + p + "/more"}
+		graph[root+p+"/more"] = nil
+	}
+	errDown := errors.New("server down")
+	tests := []struct {
+		name    string
+		cfg     CrawlConfig
+		slow    bool // h takes an hour, unless cancelled
+		fetched int  // at most, the root's included
+		failed  int  // results with errors
+		wantErr bool
+	}{
+		// all but c/more, which only c links to
+		{"continue", CrawlConfig{Sequential: true}, false, 16, 1, false},
+		{"continue, 8 workers", CrawlConfig{MaxWorkers: 8}, false, 16, 1, false},
+		// a sequential crawl fetches a, a/more, b, b/more and then c
+		{"abort", CrawlConfig{Sequential: true, AbortOnError: true}, false, 6, 1, true},
+		// the hour-long fetch of h is cancelled
+		{"abort, 8 workers", CrawlConfig{MaxWorkers: 8, AbortOnError: true}, true, 16, 1, true},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		f.SetError(root+"c", errDown)
+		if tt.slow {
+			f.SetDelay(root+"h", time.Hour)
+		}
+		sink := new(SliceSink)
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth, cfg.Sink = f, Unlimited, sink
+		cr, err := NewCrawler([]string{root}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		err = cr.Run(context.Background())
+		if d := time.Since(start); d > 10*time.Second {
+			t.Errorf("%s: the crawl took %v", tt.name, d)
+		}
+		var fe *FetchError
+		if tt.wantErr && (!errors.As(err, &fe) || fe.URL != root+"c" || !errors.Is(err, errDown)) {
+			t.Errorf("%s: Run = %v, want the *FetchError of c", tt.name, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("%s: Run = %v, want nil", tt.name, err)
+		}
+		if n := totalFetches(f, graph); n > tt.fetched || (!tt.wantErr || tt.cfg.Sequential) && n != tt.fetched {
+			t.Errorf("%s: %d fetches, want %d", tt.name, n, tt.fetched)
+		}
+		failed := 0
+		for _, r := range sink.Results() {
+			if r.Err != nil && !errors.Is(r.Err, context.Canceled) {
+				failed++
+			}
+		}
+		if failed != tt.failed {
+			t.Errorf("%s: %d failures reported, want %d", tt.name, failed, tt.failed)
+		}
+	}
+}
+
+func TestMaxConsecutiveErrors(t *testing.T) {
+	const root = "http://example.com/"
+	pages := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	graph := map[string][]string{root: nil}
+	for _, p := range pages {
+		graph[root] = append(graph[root], root+p)
+		graph[root+p] = nil
+	}
+	errDown := errors.New("server down")
+	tests := []struct {
+		name    string
+		failing string // the pages that fail
+		max     int
+		fetched int // fetches, the root's included
+		open    bool
+	}{
+		{"no limit", "cdefgh", 0, 9, false},
+		// a sequential crawl fetches a to h in order
+		{"stops at the limit", "cdefgh", 3, 6, true},
+		{"reset by a success", "bcefgh", 3, 8, true},
+		{"never reached", "bdfh", 2, 9, false},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		for _, p := range tt.failing {
+			f.SetError(root+string(p), errDown)
+		}
+		var stats CrawlStats
+		_, err := CrawlErrors(context.Background(), root, 1, f,
+			WithMaxConsecutiveErrors(tt.max), WithSequential(), WithStats(&stats))
+		if n := totalFetches(f, graph); n != tt.fetched {
+			t.Errorf("%s: %d fetches, want %d", tt.name, n, tt.fetched)
+		}
+		if open := stats.CircuitOpen != nil; open != tt.open {
+			t.Errorf("%s: CircuitOpen %v, want set %v", tt.name, stats.CircuitOpen, tt.open)
+		}
+		var ce *CircuitOpenError
+		if tt.open && (!errors.As(err, &ce) || !errors.Is(err, errDown)) {
+			t.Errorf("%s: crawl error %v, want a *CircuitOpenError wrapping the last failure", tt.name, err)
+		}
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	graph := syntheticGraph(100, 3)
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		deadline bool
+	}{
+		{"fast", 0, false},
+		{"slow", 20 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		for u := range graph {
+			f.SetDelay(u, tt.delay)
+		}
+		start := time.Now()
+		pages, err := CrawlErrors(context.Background(), "http://example.com/p0", Unlimited, f,
+			WithMaxDuration(50*time.Millisecond), WithSequential())
+		elapsed := time.Since(start)
+
+		if got := errors.Is(err, ErrMaxDuration); got != tt.deadline {
+			t.Errorf("%s: %v, want ErrMaxDuration %v", tt.name, err, tt.deadline)
+		}
+		if !tt.deadline {
+			if err != nil || len(pages) != len(graph) {
+				t.Errorf("%s: crawled %d pages, %v; want all %d", tt.name, len(pages), err, len(graph))
+			}
+			continue
+		}
+		// 100 pages one at a time would take two seconds
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("%s: crawl returned after %v, want about 50ms", tt.name, elapsed)
+		}
+		if len(pages) == 0 || len(pages) >= len(graph) {
+			t.Errorf("%s: crawled %d pages, want those fetched before the deadline", tt.name, len(pages))
+		}
+	}
+}
+
+// parentsFirst reports the first of results to come before its
+// parent's result, if its parent is among them.
+func parentsFirst(results []CrawlResult) (CrawlResult, bool) {
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if r.ParentURL != "" && !seen[r.ParentURL] {
+			return r, false
+		}
+		seen[r.URL] = true
+	}
+	return CrawlResult{}, true
+}
+
+func TestParentsFirst(t *testing.T) {
+	graph := syntheticGraph(300, 4)
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+	}{
+		{"default", CrawlConfig{}},
+		{"32 workers", CrawlConfig{MaxWorkers: 32}},
+		{"breadth-first", CrawlConfig{MaxWorkers: 8, BreadthFirst: true}},
+		{"sequential", CrawlConfig{Sequential: true}},
+		{"buffered", CrawlConfig{MaxWorkers: 8, BufferSize: 100}},
+		{"sink", CrawlConfig{MaxWorkers: 8, Sink: new(SliceSink)}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		// so that pages finish in another order than they started
+		rng := rand.New(rand.NewPCG(1, 2))
+		for u := range graph {
+			f.SetDelay(u, time.Duration(rng.IntN(500))*time.Microsecond)
+		}
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth = f, Unlimited
+		cr, err := NewCrawler([]string{"http://example.com/p0"}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []CrawlResult
+		if cfg.Sink != nil {
+			if err := cr.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			results = cfg.Sink.(*SliceSink).Results()
+		} else {
+			c, err := cr.Start(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for r := range c {
+				results = append(results, r)
+			}
+		}
+		if len(results) != len(graph) {
+			t.Errorf("%s: %d results, want %d", tt.name, len(results), len(graph))
+		}
+		if r, ok := parentsFirst(results); !ok {
+			t.Errorf("%s: %s came before its parent %s", tt.name, r.URL, r.ParentURL)
+		}
+	}
+}
+
+func TestDelayByDepth(t *testing.T) {
+	// each level on a host of its own, for a timingFetcher
+	graph := map[string][]string{
+		"http://l0.example/":  {"http://l1.example/a", "http://l1.example/b"},
+		"http://l1.example/a": {"http://l2.example/a"},
+		"http://l1.example/b": {"http://l2.example/b"},
+		"http://l2.example/a": {"http://l3.example/a"},
+		"http://l2.example/b": nil,
+		"http://l3.example/a": nil,
+	}
+	const unit = 20 * time.Millisecond
+	tests := []struct {
+		name  string
+		delay func(depth int) time.Duration
+	}{
+		{"none", nil},
+		{"by depth", func(depth int) time.Duration { return time.Duration(depth) * unit }},
+		{"deep only", func(depth int) time.Duration {
+			if depth >= 2 {
+				return 2 * unit
+			}
+			return 0
+		}},
+	}
+	for _, tt := range tests {
+		tf := &timingFetcher{Fetcher: crawltest.NewFakeFetcher(graph)}
+		delay := tt.delay
+		if delay == nil {
+			delay = func(int) time.Duration { return 0 }
+		}
+		begin := time.Now()
+		pages, err := CrawlErrors(context.Background(), "http://l0.example/", Unlimited, tf, WithDelayByDepth(tt.delay))
+		if err != nil || len(pages) != len(graph) {
+			t.Fatalf("%s: crawled %d pages, %v", tt.name, len(pages), err)
+		}
+		// the first fetch of each level waits its delay after the
+		// first of the level above
+		last := begin
+		for level := range 4 {
+			starts := tf.starts[fmt.Sprintf("l%d.example", level)]
+			first := slices.MinFunc(starts, time.Time.Compare)
+			if d := first.Sub(last); d < delay(level) {
+				t.Errorf("%s: level %d started %v after the level above, want at least %v", tt.name, level, d, delay(level))
+			}
+			// with no delay to wait, a level starts well before a
+			// delayed one would
+			if d := first.Sub(last); delay(level) == 0 && d >= 2*unit {
+				t.Errorf("%s: level %d started %v after the level above, with no delay", tt.name, level, d)
+			}
+			last = first
+		}
+	}
+}
+
+// hangingFetcher stops answering after its first n fetches, each
+// later one waiting until its context is done.
+type hangingFetcher struct {
+	Fetcher
+	n       int32
+	fetches atomic.Int32
+}
+
+func (f *hangingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	if f.fetches.Add(1) > f.n {
+		<-ctx.Done()
+		return "", nil, ctx.Err()
+	}
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	const idle = 50 * time.Millisecond
+	graph := syntheticGraph(100, 3)
+	tests := []struct {
+		name    string
+		hangs   int32         // after this many fetches, or never if 0
+		delay   time.Duration // of every fetch
+		workers int
+	}{
+		{"hangs after 5", 5, 0, 1},
+		{"hangs after 20, 4 workers", 20, 0, 4},
+		{"hangs at once", -1, 0, 4},
+		// each success restarts the wait, so slow progress is no hang
+		{"slow", 0, idle / 5, 4},
+	}
+	for _, tt := range tests {
+		fake := crawltest.NewFakeFetcher(graph)
+		for u := range graph {
+			fake.SetDelay(u, tt.delay)
+		}
+		var f Fetcher = fake
+		if tt.hangs != 0 {
+			f = &hangingFetcher{Fetcher: fake, n: max(tt.hangs, 0)}
+		}
+		var stats CrawlStats
+		start := time.Now()
+		results, err := Crawl(context.Background(), "http://example.com/p0", CrawlConfig{
+			Fetcher: f, Depth: Unlimited, MaxWorkers: tt.workers, IdleTimeout: idle, Stats: &stats,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pages int
+		for r := range results {
+			if r.Err == nil {
+				pages++
+			}
+		}
+		elapsed := time.Since(start)
+
+		if tt.hangs == 0 {
+			if stats.IdleTimedOut || pages != len(graph) {
+				t.Errorf("%s: crawled %d pages, idle timed out %v; want all %d", tt.name, pages, stats.IdleTimedOut, len(graph))
+			}
+			continue
+		}
+		if !stats.IdleTimedOut {
+			t.Errorf("%s: Stats.IdleTimedOut not set", tt.name)
+		}
+		if want := int(max(tt.hangs, 0)); pages != want {
+			t.Errorf("%s: crawled %d pages, want the %d before the hang", tt.name, pages, want)
+		}
+		if elapsed < idle || elapsed > 10*idle {
+			t.Errorf("%s: crawl returned after %v, want about %v", tt.name, elapsed, idle)
+		}
+	}
+
+	// CrawlErrors reports it
+	f := &hangingFetcher{Fetcher: crawltest.NewFakeFetcher(graph), n: 5}
+	if _, err := CrawlErrors(context.Background(), "http://example.com/p0", Unlimited, f, WithIdleTimeout(idle)); !errors.Is(err, ErrIdleTimeout) {
+		t.Errorf("CrawlErrors: %v, want ErrIdleTimeout", err)
+	}
+}
+
+func BenchmarkCrawl(b *testing.B) {
+	fetcher := crawltest.NewFakeFetcher(syntheticGraph(2000, 20))
+	cfg := CrawlConfig{Fetcher: fetcher, Depth: Unlimited, CountOnly: true}
+	b.ReportAllocs()
+	for b.Loop() {
+		var stats CrawlStats
+		cfg.Stats = &stats
+		results, err := Crawl(context.Background(), "http://example.com/p0", cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for range results {
+		}
+		if stats.PagesFetched != 2000 {
+			b.Fatalf("fetched %d pages, want 2000", stats.PagesFetched)
+		}
+	}
+}
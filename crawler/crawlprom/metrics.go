@@ -0,0 +1,80 @@
+// Package crawlprom reports the fetches of a crawl as Prometheus
+// metrics, as the crawler's CrawlMetrics.
+package crawlprom
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is a CrawlMetrics of the crawler keeping these metrics:
+//
+//	crawler_pages_fetched_total          pages fetched successfully
+//	crawler_fetch_errors_total           failed fetches, by host and status
+//	crawler_fetch_duration_seconds       how long fetches took, failed ones too
+//	crawler_fetches_in_flight            fetches in progress
+//
+// The status label is the HTTP status of the response, or "0" for a
+// fetch that got none, such as one that couldn't connect. Skipped
+// fetches are only counted while in flight. Metrics is safe for
+// concurrent use, and one may be shared by several crawls.
+type Metrics struct {
+	fetched  prometheus.Counter
+	errors   *prometheus.CounterVec
+	duration prometheus.Histogram
+	inflight prometheus.Gauge
+}
+
+// New returns a Metrics whose metrics are registered with reg. It
+// returns an error if any of them can't be, as when reg already has
+// metrics of the same names.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		fetched: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "crawler_pages_fetched_total",
+			Help: "Pages fetched successfully.",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "crawler_fetch_errors_total",
+			Help: "Fetches that failed, by host and HTTP status, 0 if none.",
+		}, []string{"host", "status"}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "crawler_fetch_duration_seconds",
+			Help:    "How long fetches took.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		inflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "crawler_fetches_in_flight",
+			Help: "Fetches in progress.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.fetched, m.errors, m.duration, m.inflight} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// FetchStarted implements CrawlMetrics.
+func (m *Metrics) FetchStarted(host string) {
+	m.inflight.Inc()
+}
+
+// FetchSkipped implements CrawlMetrics.
+func (m *Metrics) FetchSkipped(host string) {
+	m.inflight.Dec()
+}
+
+// FetchDone implements CrawlMetrics.
+func (m *Metrics) FetchDone(host string, status int, elapsed time.Duration, err error) {
+	m.inflight.Dec()
+	m.duration.Observe(elapsed.Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(host, strconv.Itoa(status)).Inc()
+	} else {
+		m.fetched.Inc()
+	}
+}
@@ -0,0 +1,105 @@
+// Package crawltest provides a fake Fetcher for testing crawls
+// without a network or hand-written page data.
+package crawltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned, wrapped with the url, for urls that are
+// not in a FakeFetcher's graph.
+var ErrNotFound = errors.New("not found")
+
+// FakeFetcher is a Fetcher of the crawler serving a made-up site from
+// a link graph. Each url in the graph is a page linking to the urls
+// it maps to, with a body of Body(url). Urls that are not in the
+// graph, including those only linked to, give an error wrapping
+// ErrNotFound. It is safe for concurrent use.
+type FakeFetcher struct {
+	graph map[string][]string
+
+	mu      sync.Mutex
+	errs    map[string]error         // see SetError
+	delays  map[string]time.Duration // see SetDelay
+	fetches map[string]int
+}
+
+// NewFakeFetcher returns a FakeFetcher serving graph, which maps the
+// url of each page to the urls it links to. The fetcher keeps graph,
+// which must not be changed while it is in use.
+func NewFakeFetcher(graph map[string][]string) *FakeFetcher {
+	return &FakeFetcher{
+		graph:   graph,
+		errs:    make(map[string]error),
+		delays:  make(map[string]time.Duration),
+		fetches: make(map[string]int),
+	}
+}
+
+// Body returns the body of the page at url.
+func Body(url string) string {
+	return "Page " + url
+}
+
+// SetError has fetches of url fail with err, whether or not url is in
+// the graph. A nil err undoes it.
+func (f *FakeFetcher) SetError(url string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, url)
+	} else {
+		f.errs[url] = err
+	}
+}
+
+// SetDelay has fetches of url take d before they return, as if the
+// server were slow. A fetch whose context is cancelled first returns
+// the context's error.
+func (f *FakeFetcher) SetDelay(url string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delays[url] = d
+}
+
+// Fetches returns how many times url has been fetched, including
+// fetches that failed.
+func (f *FakeFetcher) Fetches(url string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetches[url]
+}
+
+// Fetch implements the crawler's Fetcher.
+func (f *FakeFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.mu.Lock()
+	f.fetches[url]++
+	err, delay := f.errs[url], f.delays[url]
+	f.mu.Unlock()
+
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		}
+	} else if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	links, ok := f.graph[url]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrNotFound, url)
+	}
+	// callers get a copy of the links they may change
+	return Body(url), slices.Clone(links), nil
+}
@@ -0,0 +1,103 @@
+package crawltest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+var graph = map[string][]string{
+	"http://a/":  {"http://a/1", "http://a/2"},
+	"http://a/1": {"http://a/"},
+	"http://a/2": nil,
+}
+
+func TestFakeFetcher(t *testing.T) {
+	f := NewFakeFetcher(graph)
+	tests := []struct {
+		url   string
+		body  string
+		links []string
+		err   error
+	}{
+		{"http://a/", "Page http://a/", graph["http://a/"], nil},
+		{"http://a/1", "Page http://a/1", graph["http://a/1"], nil},
+		{"http://a/2", "Page http://a/2", nil, nil},
+		{"http://a/missing", "", nil, ErrNotFound},
+	}
+	for _, tt := range tests {
+		body, links, err := f.Fetch(context.Background(), tt.url)
+		if body != tt.body || !slices.Equal(links, tt.links) || !errors.Is(err, tt.err) {
+			t.Errorf("Fetch(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.url, body, links, err, tt.body, tt.links, tt.err)
+		}
+		if n := f.Fetches(tt.url); n != 1 {
+			t.Errorf("Fetches(%q) = %d, want 1", tt.url, n)
+		}
+	}
+
+	// callers may change the links they get
+	_, links, _ := f.Fetch(context.Background(), "http://a/")
+	links[0] = "changed"
+	if _, links, _ := f.Fetch(context.Background(), "http://a/"); links[0] != "http://a/1" {
+		t.Errorf("changing the links of a fetch changed the graph")
+	}
+}
+
+func TestFakeFetcherSetError(t *testing.T) {
+	f := NewFakeFetcher(graph)
+	errBoom := errors.New("boom")
+	f.SetError("http://a/1", errBoom)
+	f.SetError("http://a/missing", errBoom)
+
+	for _, url := range []string{"http://a/1", "http://a/missing"} {
+		if _, _, err := f.Fetch(context.Background(), url); err != errBoom {
+			t.Errorf("Fetch(%q) with an error set: %v, want %v", url, err, errBoom)
+		}
+	}
+	if _, _, err := f.Fetch(context.Background(), "http://a/"); err != nil {
+		t.Errorf("Fetch of a page without an error set: %v", err)
+	}
+
+	f.SetError("http://a/1", nil)
+	if _, _, err := f.Fetch(context.Background(), "http://a/1"); err != nil {
+		t.Errorf("Fetch after the error was undone: %v", err)
+	}
+	if n := f.Fetches("http://a/1"); n != 2 {
+		t.Errorf("Fetches counted %d fetches, want 2, failures included", n)
+	}
+}
+
+func TestFakeFetcherSetDelay(t *testing.T) {
+	f := NewFakeFetcher(graph)
+	const delay = 50 * time.Millisecond
+	f.SetDelay("http://a/1", delay)
+
+	start := time.Now()
+	if _, _, err := f.Fetch(context.Background(), "http://a/1"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < delay {
+		t.Errorf("delayed fetch took %v, want at least %v", d, delay)
+	}
+
+	// a cancelled fetch returns early with the context's error
+	ctx, cancel := context.WithTimeout(context.Background(), delay/5)
+	defer cancel()
+	start = time.Now()
+	if _, _, err := f.Fetch(ctx, "http://a/1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch with an expiring context: %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d >= delay {
+		t.Errorf("cancelled fetch took %v, the whole delay", d)
+	}
+
+	// an undelayed fetch with a cancelled context fails too
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := f.Fetch(cancelled, "http://a/"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Fetch with a cancelled context: %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// dotEscaper escapes the characters that are special in a DOT
+// quoted string.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// WriteDOT writes graph, as returned by BuildGraph, to w as a
+// Graphviz digraph, with a node for each page and an edge for each
+// link. Nodes and edges are written in sorted order, so the same
+// graph always gives the same output.
+func WriteDOT(w io.Writer, graph map[string][]string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph crawl {")
+	pages := sortedKeys(graph)
+	for _, u := range pages {
+		fmt.Fprintf(bw, "\t%s;\n", dotQuote(u))
+	}
+	for _, u := range pages {
+		for _, v := range sortedUnique(graph[u]) {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", dotQuote(u), dotQuote(v))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// dotLine matches the lines WriteDOT writes between the braces: a
+// quoted node, or an edge between two.
+var dotLine = regexp.MustCompile(`^\t"(?:[^"\\]|\\.)*"(?: -> "(?:[^"\\]|\\.)*")?;$`)
+
+// checkDOT fails t if dot is not a digraph of the lines WriteDOT
+// writes.
+func checkDOT(t *testing.T, dot string) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(dot, "\n"), "\n")
+	if len(lines) < 2 || lines[0] != "digraph crawl {" || lines[len(lines)-1] != "}" {
+		t.Fatalf("not a digraph:\n%s", dot)
+	}
+	for _, l := range lines[1 : len(lines)-1] {
+		if !dotLine.MatchString(l) {
+			t.Errorf("bad DOT line %q", l)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, BuildGraph(crawlRawData(t, CrawlConfig{}))); err != nil {
+		t.Fatal(err)
+	}
+	checkDOT(t, buf.String())
+	checkGolden(t, "dot.golden", buf.Bytes())
+}
+
+func TestWriteDOTQuoting(t *testing.T) {
+	graph := map[string][]string{
+		`http://example.com/"quoted"`: {`http://example.com/back\slash`, "http://example.com/new\nline"},
+	}
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, graph); err != nil {
+		t.Fatal(err)
+	}
+	checkDOT(t, buf.String())
+	for _, want := range []string{`"http://example.com/\"quoted\""`, `"http://example.com/back\\slash"`, `"http://example.com/new\nline"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output has no %s:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"slices"
+	"time"
+)
+
+// atomNS is the XML namespace of Atom feeds.
+const atomNS = "http://www.w3.org/2005/Atom"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	NS      string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+// WriteFeed writes an Atom feed of the pages in results that were
+// fetched successfully to w, for monitoring tools that subscribe to
+// feeds. Each page is an entry with its url, its Title, or its url
+// again if it has none, and when it was fetched; the feed is that of
+// the crawl from the first seed in results, updated when the last
+// page was fetched. Entries are sorted by url and listed once each.
+func WriteFeed(w io.Writer, results []CrawlResult) error {
+	feed := atomFeed{NS: atomNS, Author: atomAuthor{Name: DefaultUserAgent}}
+	if i := slices.IndexFunc(results, func(r CrawlResult) bool { return r.ParentURL == "" }); i >= 0 {
+		feed.ID, feed.Title = results[i].URL, "Crawl of "+results[i].URL
+	}
+
+	results = slices.Clone(results)
+	sortResults(results)
+	var updated time.Time
+	var fetched []time.Time // of each entry
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		if n := len(feed.Entries); n > 0 && feed.Entries[n-1].ID == r.URL {
+			continue
+		}
+		title := r.Title
+		if title == "" {
+			title = r.URL
+		}
+		feed.Entries = append(feed.Entries, atomEntry{ID: r.URL, Title: title, Link: atomLink{Href: r.URL}})
+		fetched = append(fetched, r.FetchedAt)
+		if r.FetchedAt.After(updated) {
+			updated = r.FetchedAt
+		}
+	}
+	feed.Updated = atomTime(updated)
+	for i, t := range fetched {
+		// pages not known to have been fetched date from the crawl
+		if t.IsZero() {
+			t = updated
+		}
+		feed.Entries[i].Updated = atomTime(t)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// atomTime formats t as the date of an Atom feed or entry.
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
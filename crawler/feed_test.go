@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errWriteFailed
+}
+
+func TestWriteFeed(t *testing.T) {
+	results := crawlRawData(t, CrawlConfig{})
+	// for a stable feed
+	fetched := map[string]time.Time{
+		"https://golang.org/":         time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		"https://golang.org/pkg/":     time.Date(2024, 5, 1, 12, 0, 1, 0, time.UTC),
+		"https://golang.org/pkg/fmt/": time.Date(2024, 5, 1, 12, 0, 3, 0, time.FixedZone("CEST", 2*60*60)),
+		// pkg/os/ has no time
+	}
+	for i := range results {
+		results[i].FetchedAt = fetched[results[i].URL]
+		if results[i].URL == "https://golang.org/" {
+			results[i].Title = "The Go Programming Language & more"
+		}
+	}
+	// a page listed twice is one entry
+	results = append(results, results[0])
+
+	var buf bytes.Buffer
+	if err := WriteFeed(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "feed.golden", buf.Bytes())
+
+	var feed struct {
+		ID      string `xml:"id"`
+		Entries []struct {
+			ID    string `xml:"id"`
+			Title string `xml:"title"`
+			Link  struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &feed); err != nil {
+		t.Fatalf("the feed isn't well-formed: %v", err)
+	}
+	// one entry a page fetched, so not cmd/
+	var got []string
+	for _, e := range feed.Entries {
+		got = append(got, e.ID)
+		if e.Link.Href != e.ID || e.Title == "" {
+			t.Errorf("entry %+v", e)
+		}
+		if _, err := time.Parse(time.RFC3339, e.Updated); err != nil {
+			t.Errorf("%s: updated %q: %v", e.ID, e.Updated, err)
+		}
+	}
+	want := []string{"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"}
+	if !slices.Equal(got, want) || feed.ID != "https://golang.org/" {
+		t.Errorf("feed %s has entries %q, want the feed of https://golang.org/ with %q", feed.ID, got, want)
+	}
+
+	if err := WriteFeed(failingWriter{}, results); !errors.Is(err, errWriteFailed) {
+		t.Errorf("WriteFeed to a failing writer: %v, want its error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// NotLocalError is returned, as *NotLocalError, when a FileFetcher is
+// asked for a url it has no file for, such as a link off the site it
+// reads. The crawl skips such urls.
+type NotLocalError struct {
+	url string
+}
+
+func (e NotLocalError) Error() string {
+	return fmt.Sprintf("Not a local file %v", e.url)
+}
+
+func (e NotLocalError) skip() {}
+
+// FileFetcher is a Fetcher that reads pages from files rather than
+// over HTTP, for crawling a static site's build output to check its
+// links offline. It reads file urls, such as file:///srv/site/, and
+// if Root is set http and https urls too. A url for a directory gives
+// its index.html, as a web server would. HTML files are parsed for
+// links like an HTTPFetcher's pages, relative links resolving against
+// the file's directory; other files are returned without their body
+// or links. A missing file gives an error wrapping ErrNotFound.
+//
+// Its fields must not change once it has started fetching.
+type FileFetcher struct {
+	// Root, if set, is the directory http and https urls are read
+	// from, by their path alone whatever their host, so that links
+	// to /about resolve within the site rather than the file system.
+	// A crawl from the site's own url, https://example.com/ say,
+	// would be restricted to it with SameHostOnly. Without Root only
+	// file urls are read, and others give a *NotLocalError.
+	Root string
+
+	// ExtractAssets and ExtractStructuredData have the fetcher
+	// report the resources each HTML file uses and its JSON-LD
+	// structured data, as for an HTTPFetcher.
+	ExtractAssets, ExtractStructuredData bool
+}
+
+// Fetch implements Fetcher.
+func (f *FileFetcher) Fetch(ctx context.Context, rawurl string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, rawurl))
+}
+
+// FetchPage implements PageFetcher. The page's ContentType is guessed
+// from the file's extension, or failing that from its contents.
+func (f *FileFetcher) FetchPage(ctx context.Context, rawurl string) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name, err := f.file(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	base := rawurl
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		// links in the index resolve against the directory
+		if u, err := url.Parse(rawurl); err == nil && !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+			base = u.String()
+		}
+		name = filepath.Join(name, "index.html")
+	}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, rawurl)
+	} else if err != nil {
+		return nil, err
+	}
+
+	page := &Page{ContentType: mime.TypeByExtension(filepath.Ext(name))}
+	if page.ContentType == "" {
+		page.ContentType = http.DetectContentType(b)
+	}
+	mediatype, _, _ := mime.ParseMediaType(page.ContentType)
+	if !slices.Contains(DefaultContentTypes, mediatype) {
+		return page, nil
+	}
+	page.Body = string(b)
+	var meta pageMeta
+	page.URLs, meta, err = scanLinks(base, page.Body, false, false)
+	if err != nil {
+		return nil, err
+	}
+	meta.fill(page)
+	if f.ExtractAssets {
+		page.Assets = meta.assets
+	}
+	if f.ExtractStructuredData {
+		page.StructuredData = meta.structured
+	}
+	return page, nil
+}
+
+// file returns the name of the file rawurl is read from.
+func (f *FileFetcher) file(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return "", &NotLocalError{rawurl}
+		}
+		return filepath.FromSlash(u.Path), nil
+	case "http", "https":
+		if f.Root == "" {
+			return "", &NotLocalError{rawurl}
+		}
+		// cleaned as an absolute path, so ".." can't leave Root
+		return filepath.Join(f.Root, filepath.FromSlash(path.Clean("/"+u.Path))), nil
+	}
+	return "", &NotLocalError{rawurl}
+}
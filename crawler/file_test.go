@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeSite writes files, which map slash-separated names to their
+// contents, under a new temporary directory it returns.
+func writeSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFileFetcher(t *testing.T) {
+	dir := writeSite(t, map[string]string{
+		"index.html":      `<a href="about.html">about</a> <a href="docs/">docs</a> <a href="https://example.org/">off site</a>`,
+		"about.html":      `<a href="index.html">home</a> <img src="logo.png">`,
+		"logo.png":        "\x89PNG\r\n\x1a\n",
+		"docs/index.html": `<a href="guide.html">guide</a> <a href="../about.html">about</a>`,
+		"docs/guide.html": `<a href="missing.html">broken</a> <a href="index.html">docs</a>`,
+	})
+	fileRoot := "file://" + filepath.ToSlash(dir) + "/"
+	tests := []struct {
+		name string
+		f    *FileFetcher
+		seed string
+	}{
+		{"file urls", &FileFetcher{}, fileRoot},
+		{"Root", &FileFetcher{Root: dir}, "https://example.com/"},
+	}
+	for _, tt := range tests {
+		results, err := Crawl(context.Background(), tt.seed, CrawlConfig{Fetcher: tt.f, Depth: Unlimited, SameHostOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var all []CrawlResult
+		var got []string
+		for r := range results {
+			all = append(all, r)
+			if r.Err == nil {
+				got = append(got, r.URL)
+			}
+		}
+		slices.Sort(got)
+		want := []string{
+			tt.seed, tt.seed + "about.html", tt.seed + "docs/", tt.seed + "docs/guide.html", tt.seed + "docs/index.html",
+			// the same file as the seed, by another url
+			tt.seed + "index.html",
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, want)
+		}
+		if dead, want := DeadLinks(all), []string{tt.seed + "docs/missing.html"}; !slices.Equal(dead, want) {
+			t.Errorf("%s: dead links %q, want %q", tt.name, dead, want)
+		}
+	}
+}
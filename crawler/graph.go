@@ -0,0 +1,254 @@
+package main
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"sort"
+)
+
+// BuildGraph returns the link graph of a crawl, mapping the url of
+// each page fetched successfully to the urls it links to. Linked urls
+// need not have been fetched themselves.
+func BuildGraph(results []CrawlResult) map[string][]string {
+	graph := make(map[string][]string)
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		if _, dup := graph[r.URL]; dup {
+			continue
+		}
+		graph[r.URL] = append([]string{}, r.Links...)
+	}
+	return graph
+}
+
+// DeadLinks returns the urls of the pages in results that failed to
+// fetch, such as those that were not found or answered with an HTTP
+// error, sorted and each listed once. Pages the fetcher chose to skip
+// are not dead.
+func DeadLinks(results []CrawlResult) []string {
+	var dead []string
+	for _, r := range results {
+		if r.Err != nil && !isSkip(r.Err) {
+			dead = append(dead, r.URL)
+		}
+	}
+	return sortedUnique(dead)
+}
+
+// GroupByHost buckets results by the host of their urls, keeping each
+// bucket in the order of results. Hosts are as NormalizeURL gives
+// them: lower case, with a port only if it isn't the scheme's
+// default, so http://Example.com:80/ and http://example.com/ share a
+// bucket but example.com:8080 has its own. Results whose urls don't
+// parse as absolute urls are left out.
+func GroupByHost(results []CrawlResult) map[string][]CrawlResult {
+	hosts := make(map[string][]CrawlResult)
+	for _, r := range results {
+		n, err := NormalizeURL(r.URL)
+		if err != nil {
+			continue
+		}
+		u, err := url.Parse(n)
+		if err != nil {
+			continue
+		}
+		hosts[u.Host] = append(hosts[u.Host], r)
+	}
+	return hosts
+}
+
+// DuplicateContent maps the BodyHash of each body served at more than
+// one url in results to those urls, sorted, for finding copies of a
+// page that would count against a site with search engines, or that a
+// crawl could skip. Pages without a BodyHash are left out.
+func DuplicateContent(results []CrawlResult) map[string][]string {
+	byHash := make(map[string][]string)
+	for _, r := range results {
+		if r.BodyHash != "" {
+			byHash[r.BodyHash] = append(byHash[r.BodyHash], r.URL)
+		}
+	}
+	dups := make(map[string][]string)
+	for h, urls := range byHash {
+		if urls = sortedUnique(urls); len(urls) > 1 {
+			dups[h] = urls
+		}
+	}
+	return dups
+}
+
+// SortTopological reorders results so that each comes after the
+// result of its ParentURL, if that is among them, keeping them in
+// their order otherwise. Results straight from a crawl are in such an
+// order already; SortTopological restores it after they have been
+// sorted some other way or gathered from several crawls. When several
+// results share a url the first is taken as the parent.
+func SortTopological(results []CrawlResult) {
+	byURL := make(map[string]int, len(results))
+	for i, r := range results {
+		if _, dup := byURL[r.URL]; !dup {
+			byURL[r.URL] = i
+		}
+	}
+	out := make([]CrawlResult, 0, len(results))
+	placed := make([]bool, len(results))
+	var place func(i int)
+	place = func(i int) {
+		if placed[i] {
+			return
+		}
+		// marked first, so that a cycle of parents ends
+		placed[i] = true
+		if p, ok := byURL[results[i].ParentURL]; ok && results[i].ParentURL != "" {
+			place(p)
+		}
+		out = append(out, results[i])
+	}
+	for i := range results {
+		place(i)
+	}
+	copy(results, out)
+}
+
+// FindCycles returns the cycles in graph among the pages that are
+// keys of it, as found by a depth-first search: one for each link
+// that leads back to a page on the current search path. Each cycle
+// lists its pages in link order, starting from the page the search
+// reached first, so a self-link gives a cycle of one page. Pages and
+// links are visited in sorted order, so the result is deterministic.
+func FindCycles(graph map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+
+	var visit func(u string)
+	visit = func(u string) {
+		state[u] = onStack
+		stack = append(stack, u)
+		for _, v := range sortedUnique(graph[u]) {
+			if _, fetched := graph[v]; !fetched {
+				continue
+			}
+			switch state[v] {
+			case unvisited:
+				visit(v)
+			case onStack:
+				// v is an ancestor of u: a back edge closes a cycle
+				i := len(stack) - 1
+				for stack[i] != v {
+					i--
+				}
+				cycles = append(cycles, append([]string{}, stack[i:]...))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[u] = done
+	}
+
+	for _, u := range sortedKeys(graph) {
+		if state[u] == unvisited {
+			visit(u)
+		}
+	}
+	return cycles
+}
+
+// sortResults sorts results by url, so that the output made from them
+// doesn't depend on the order a concurrent crawl happened to fetch
+// pages in. Results for the same url, as from separate crawls, are
+// sorted nearest the seed first, then by parent.
+func sortResults(results []CrawlResult) {
+	slices.SortStableFunc(results, func(a, b CrawlResult) int {
+		return cmp.Or(
+			cmp.Compare(a.URL, b.URL),
+			cmp.Compare(b.Depth, a.Depth), // remaining depth, so higher is nearer
+			cmp.Compare(a.ParentURL, b.ParentURL),
+		)
+	})
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedUnique returns a sorted copy of s without duplicates.
+func sortedUnique(s []string) []string {
+	out := append([]string{}, s...)
+	sort.Strings(out)
+	return slices.Compact(out)
+}
+
+// InboundCounts returns, for every url linked to in graph, the number
+// of distinct pages that link to it. Links from a page to itself
+// don't count.
+func InboundCounts(graph map[string][]string) map[string]int {
+	counts := make(map[string]int)
+	for u, links := range graph {
+		for _, v := range sortedUnique(links) {
+			if v != u {
+				counts[v]++
+			}
+		}
+	}
+	return counts
+}
+
+// PageRank ranks the pages of graph, and the urls they link to, by
+// running iterations rounds of the PageRank algorithm with the given
+// damping factor, usually 0.85. The ranks add up to 1. A page with no
+// links shares its rank among all pages equally.
+func PageRank(graph map[string][]string, iterations int, damping float64) map[string]float64 {
+	// every url in the graph, and each page's links without repeats
+	out := make(map[string][]string)
+	for u, links := range graph {
+		out[u] = sortedUnique(links)
+		for _, v := range links {
+			if _, ok := out[v]; !ok {
+				out[v] = nil
+			}
+		}
+	}
+	nodes := sortedKeys(out)
+	n := float64(len(nodes))
+	if n == 0 {
+		return map[string]float64{}
+	}
+
+	rank := make(map[string]float64, len(nodes))
+	for _, u := range nodes {
+		rank[u] = 1 / n
+	}
+	for range iterations {
+		// rank held by pages without links, spread over everything
+		var dangling float64
+		for _, u := range nodes {
+			if len(out[u]) == 0 {
+				dangling += rank[u]
+			}
+		}
+		base := (1-damping)/n + damping*dangling/n
+		next := make(map[string]float64, len(nodes))
+		for _, u := range nodes {
+			next[u] += base
+			for _, v := range out[u] {
+				next[v] += damping * rank[u] / float64(len(out[u]))
+			}
+		}
+		rank = next
+	}
+	return rank
+}
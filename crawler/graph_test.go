@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestBuildGraph(t *testing.T) {
+	graph := BuildGraph(crawlRawData(t, CrawlConfig{}))
+	want := map[string][]string{
+		"https://golang.org/": {"https://golang.org/pkg/", "https://golang.org/cmd/"},
+		"https://golang.org/pkg/": {
+			"https://golang.org/", "https://golang.org/cmd/",
+			"https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		},
+		"https://golang.org/pkg/fmt/": {"https://golang.org/", "https://golang.org/pkg/"},
+		"https://golang.org/pkg/os/":  {"https://golang.org/", "https://golang.org/pkg/"},
+	}
+	// golang.org/cmd/ is linked to but not found, so it's no key
+	if !reflect.DeepEqual(graph, want) {
+		t.Errorf("BuildGraph:\n%q\nwant\n%q", graph, want)
+	}
+}
+
+func TestFindCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph map[string][]string
+		want  [][]string
+	}{
+		{"rawData", BuildGraph(crawlRawData(t, CrawlConfig{})), [][]string{
+			{"https://golang.org/", "https://golang.org/pkg/"},
+			{"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/fmt/"},
+			{"https://golang.org/pkg/", "https://golang.org/pkg/fmt/"},
+			{"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/os/"},
+			{"https://golang.org/pkg/", "https://golang.org/pkg/os/"},
+		}},
+		{"acyclic", map[string][]string{"a": {"b", "c"}, "b": {"c"}, "c": nil}, nil},
+		{"self-link", map[string][]string{"a": {"a", "b"}, "b": nil}, [][]string{{"a"}}},
+		{"unfetched links", map[string][]string{"a": {"b"}, "b": {"x"}}, nil},
+		{"ring", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}, [][]string{{"a", "b", "c"}}},
+	}
+	for _, tt := range tests {
+		if got := FindCycles(tt.graph); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: FindCycles = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeadLinks(t *testing.T) {
+	notFound := &FetchError{URL: "b", Err: ErrNotFound}
+	tests := []struct {
+		name    string
+		results []CrawlResult
+		want    []string
+	}{
+		{"rawData", crawlRawData(t, CrawlConfig{}), []string{"https://golang.org/cmd/"}},
+		{"none", []CrawlResult{{URL: "a"}}, nil},
+		{"sorted and once each", []CrawlResult{
+			{URL: "c", Err: notFound}, {URL: "a"}, {URL: "b", Err: notFound}, {URL: "c", Err: notFound},
+		}, []string{"b", "c"}},
+		{"skipped", []CrawlResult{{URL: "a", Err: hostCancelledError{"a"}}}, nil},
+	}
+	for _, tt := range tests {
+		if got := DeadLinks(tt.results); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: DeadLinks = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGroupByHost(t *testing.T) {
+	tests := []struct {
+		name string
+		urls []string
+		want map[string][]string
+	}{
+		{"two hosts", []string{
+			"https://golang.org/", "https://example.com/a", "https://golang.org/pkg/", "https://example.com/b",
+		}, map[string][]string{
+			"golang.org":  {"https://golang.org/", "https://golang.org/pkg/"},
+			"example.com": {"https://example.com/a", "https://example.com/b"},
+		}},
+		{"normalized", []string{
+			"http://Example.com:80/", "http://example.com/x", "http://example.com:8080/", "https://EXAMPLE.com:443/y",
+		}, map[string][]string{
+			"example.com":      {"http://Example.com:80/", "http://example.com/x", "https://EXAMPLE.com:443/y"},
+			"example.com:8080": {"http://example.com:8080/"},
+		}},
+		{"malformed", []string{
+			"http://[::1", "https://golang.org/", "/relative", "", "http://example.com/%zz", "golang.org/pkg/",
+		}, map[string][]string{
+			"golang.org": {"https://golang.org/"},
+		}},
+		{"none", nil, map[string][]string{}},
+	}
+	for _, tt := range tests {
+		var results []CrawlResult
+		for _, u := range tt.urls {
+			results = append(results, CrawlResult{URL: u})
+		}
+		got := make(map[string][]string)
+		for host, rs := range GroupByHost(results) {
+			for _, r := range rs {
+				got[host] = append(got[host], r.URL)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: GroupByHost = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateContent(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root:               {root + "a", root + "a?ref=nav", root + "b", root + "c", root + "empty", root + "blank"},
+		root + "a":         nil,
+		root + "a?ref=nav": nil,
+		root + "b":         nil,
+		root + "c":         nil,
+		root + "empty":     nil,
+		root + "blank":     nil,
+	}
+	bodies := map[string]string{
+		root:               "home",
+		root + "a":         "the same page",
+		root + "a?ref=nav": "the same page",
+		root + "b":         "another page",
+		root + "c":         "the same page",
+		root + "empty":     "",
+		root + "blank":     "",
+	}
+	sum := sha256.Sum256([]byte("the same page"))
+	same := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+	}{
+		{"crawl", CrawlConfig{}},
+		// the hash is of the body left out
+		{"dry run", CrawlConfig{DryRun: true}},
+	}
+	for _, tt := range tests {
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth = bodyFetcher{crawltest.NewFakeFetcher(graph), bodies}, 1
+		c, err := Crawl(context.Background(), root, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []CrawlResult
+		for r := range c {
+			results = append(results, r)
+		}
+		want := map[string][]string{same: {root + "a", root + "a?ref=nav", root + "c"}}
+		if got := DuplicateContent(results); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: DuplicateContent = %q, want %q", tt.name, got, want)
+		}
+	}
+
+	// a url crawled twice, as across two crawls, isn't its own copy
+	results := []CrawlResult{{URL: "a", BodyHash: "h"}, {URL: "a", BodyHash: "h"}, {URL: "b", BodyHash: "h2"}, {URL: "c"}, {URL: "d"}}
+	if got := DuplicateContent(results); len(got) != 0 {
+		t.Errorf("DuplicateContent(%+v) = %q, want none", results, got)
+	}
+}
+
+func TestInboundCounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph map[string][]string
+		want  map[string]int
+	}{
+		{"rawData", BuildGraph(crawlRawData(t, CrawlConfig{})), map[string]int{
+			"https://golang.org/":         3,
+			"https://golang.org/pkg/":     3,
+			"https://golang.org/cmd/":     2,
+			"https://golang.org/pkg/fmt/": 1,
+			"https://golang.org/pkg/os/":  1,
+		}},
+		{"repeats and self-links", map[string][]string{"a": {"b", "b", "a"}, "b": {"a"}}, map[string]int{"a": 1, "b": 1}},
+		{"empty", nil, map[string]int{}},
+	}
+	for _, tt := range tests {
+		if got := InboundCounts(tt.graph); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: InboundCounts = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	graph := BuildGraph(crawlRawData(t, CrawlConfig{}))
+	rank := PageRank(graph, 50, 0.85)
+	if len(rank) != 5 {
+		t.Errorf("ranked %d urls, want the 4 pages and golang.org/cmd/", len(rank))
+	}
+	var sum float64
+	for _, r := range rank {
+		sum += r
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("ranks add up to %v, want 1", sum)
+	}
+	// the pages linked from every other page outrank the rest
+	for _, top := range []string{"https://golang.org/", "https://golang.org/pkg/"} {
+		for _, u := range []string{"https://golang.org/cmd/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"} {
+			if rank[u] >= rank[top] {
+				t.Errorf("%s ranks %v, at least %s's %v", u, rank[u], top, rank[top])
+			}
+		}
+	}
+	if got := PageRank(nil, 10, 0.85); len(got) != 0 {
+		t.Errorf("PageRank of no pages = %v", got)
+	}
+}
+
+func TestSortResults(t *testing.T) {
+	results := []CrawlResult{
+		{URL: "c", Depth: 1, ParentURL: "a"},
+		{URL: "b", Depth: 2, ParentURL: "a"},
+		{URL: "c", Depth: 2, ParentURL: "b"},
+		{URL: "a", Depth: 3},
+		{URL: "c", Depth: 2, ParentURL: "a"},
+	}
+	want := []CrawlResult{
+		{URL: "a", Depth: 3},
+		{URL: "b", Depth: 2, ParentURL: "a"},
+		// nearest the seed first, then by parent
+		{URL: "c", Depth: 2, ParentURL: "a"},
+		{URL: "c", Depth: 2, ParentURL: "b"},
+		{URL: "c", Depth: 1, ParentURL: "a"},
+	}
+	for range 10 {
+		rand.Shuffle(len(results), reflect.Swapper(results))
+		sortResults(results)
+		if !reflect.DeepEqual(results, want) {
+			t.Fatalf("sortResults = %+v, want %+v", results, want)
+		}
+	}
+}
+
+func TestSortTopological(t *testing.T) {
+	results := crawlRawData(t, CrawlConfig{MaxWorkers: 8})
+	for range 10 {
+		rand.Shuffle(len(results), reflect.Swapper(results))
+		SortTopological(results)
+		if r, ok := parentsFirst(results); !ok {
+			t.Fatalf("%s came before its parent %s", r.URL, r.ParentURL)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		results []CrawlResult
+		want    []string
+	}{
+		{"in order already", []CrawlResult{{URL: "a"}, {URL: "b", ParentURL: "a"}, {URL: "c", ParentURL: "b"}}, []string{"a", "b", "c"}},
+		{"children first", []CrawlResult{{URL: "c", ParentURL: "b"}, {URL: "b", ParentURL: "a"}, {URL: "a"}}, []string{"a", "b", "c"}},
+		// the order is kept but for what must move
+		{"stable", []CrawlResult{{URL: "x"}, {URL: "b", ParentURL: "a"}, {URL: "y"}, {URL: "a"}}, []string{"x", "a", "b", "y"}},
+		{"parent missing", []CrawlResult{{URL: "b", ParentURL: "gone"}, {URL: "a"}}, []string{"b", "a"}},
+		{"cycle", []CrawlResult{{URL: "a", ParentURL: "b"}, {URL: "b", ParentURL: "a"}}, []string{"b", "a"}},
+		{"repeated url", []CrawlResult{{URL: "b", ParentURL: "a"}, {URL: "a"}, {URL: "a", ParentURL: "b"}}, []string{"a", "b", "a"}},
+	}
+	for _, tt := range tests {
+		SortTopological(tt.results)
+		var got []string
+		for _, r := range tt.results {
+			got = append(got, r.URL)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: SortTopological gave %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutputStable(t *testing.T) {
+	writers := map[string]func(w io.Writer, results []CrawlResult) error{
+		"json":    func(w io.Writer, results []CrawlResult) error { return WriteJSON(w, results, true) },
+		"sitemap": WriteSitemap,
+		"dot":     func(w io.Writer, results []CrawlResult) error { return WriteDOT(w, BuildGraph(results)) },
+		"tree": func(w io.Writer, results []CrawlResult) error {
+			PrintTree(w, results, "https://golang.org/")
+			return nil
+		},
+	}
+	outputs := make(map[string]string)
+	for i := range 20 {
+		results := crawlRawData(t, CrawlConfig{MaxWorkers: 8})
+		for name, write := range writers {
+			var buf bytes.Buffer
+			if err := write(&buf, results); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if i == 0 {
+				outputs[name] = buf.String()
+			} else if buf.String() != outputs[name] {
+				t.Errorf("%s: crawl %d gave\n%s\nthe first gave\n%s", name, i+1, buf.String(), outputs[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the fields of an HTTPFetcher made by NewHTTPFetcher.
+const (
+	DefaultUserAgent    = "gotests-crawler/1.0"
+	DefaultMaxRedirects = 10
+	DefaultMaxBodyBytes = 10 << 20
+
+	// DefaultMaxIdleConnsPerHost lets every worker of a crawl of
+	// DefaultMaxWorkers keep its connection to a host open, where
+	// net/http would close all but two.
+	DefaultMaxIdleConnsPerHost = DefaultMaxWorkers
+)
+
+// DefaultContentTypes are the media types whose pages an HTTPFetcher
+// made by NewHTTPFetcher parses for links.
+var DefaultContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// TooManyRedirectsError is returned, as *TooManyRedirectsError, when
+// fetching a url takes more redirects than an HTTPFetcher allows.
+type TooManyRedirectsError struct {
+	url string
+}
+
+func (e TooManyRedirectsError) Error() string {
+	return fmt.Sprintf("Too many redirects fetching %v", e.url)
+}
+
+// RedirectRefusedError is returned, as *RedirectRefusedError, when a
+// url redirects to a host that an HTTPFetcher may not visit.
+type RedirectRefusedError struct {
+	url, to string
+}
+
+func (e RedirectRefusedError) Error() string {
+	return fmt.Sprintf("Refused redirect from %v to %v", e.url, e.to)
+}
+
+// BodyTooLargeError is returned, as *BodyTooLargeError, when a page
+// is bigger than an HTTPFetcher will read.
+type BodyTooLargeError struct {
+	url   string
+	limit int64
+}
+
+func (e BodyTooLargeError) Error() string {
+	return fmt.Sprintf("Body of %v is over %d bytes", e.url, e.limit)
+}
+
+// HTTPFetcher is a Fetcher that retrieves pages over HTTP and
+// returns the links found in their anchor tags.
+//
+// Its exported fields may be changed after NewHTTPFetcher returns,
+// but not once it has started fetching.
+type HTTPFetcher struct {
+	client *http.Client
+	auth   map[string]basicAuth // by host; see SetBasicAuth
+
+	transportOnce sync.Once
+	ownTransport  http.RoundTripper // the client's, with TLSConfig and the connection settings
+
+	// UserAgent is sent as the User-Agent of every request. It
+	// overrides any User-Agent in Header.
+	UserAgent string
+
+	// Header holds extra headers, such as Accept, to send with
+	// every request.
+	Header http.Header
+
+	// AcceptLanguage, if set, is sent as the Accept-Language of
+	// every request, such as "fr-CH, fr;q=0.9, en;q=0.8", to ask
+	// for pages in those languages. It overrides any
+	// Accept-Language in Header.
+	AcceptLanguage string
+
+	// MaxRedirects is the most redirects followed for one fetch.
+	MaxRedirects int
+
+	// AllowedHosts, if set, lists the only hosts that a redirect
+	// may lead to. It is usually the same list the crawl is
+	// restricted to.
+	AllowedHosts []string
+
+	// ContentTypes lists the media types, such as "text/html", of
+	// the pages to read and parse for links. Other pages are
+	// returned without their body or links, so that following a
+	// link to a large binary doesn't download it. A response with
+	// no Content-Type is parsed. If ContentTypes is empty, every
+	// page is parsed.
+	ContentTypes []string
+
+	// MaxBodyBytes is the most bytes of a body read for one
+	// page. Bigger pages fail with a *BodyTooLargeError. Zero
+	// means no limit.
+	MaxBodyBytes int64
+
+	// LinkExtractor, if set, finds the links on each page parsed,
+	// in place of the default of the hrefs of its anchor tags. It
+	// is given the url the page was fetched from, after redirects,
+	// along with the page's body and Content-Type. Pages of types
+	// not in ContentTypes, such as JSON or XML, have to be added
+	// there to reach it.
+	LinkExtractor func(base, body, contentType string) ([]string, error)
+
+	// FollowMetaRefresh adds the target of a page's
+	// <meta http-equiv="refresh"> to its links, as a browser would
+	// go there. It has no effect with a LinkExtractor.
+	FollowMetaRefresh bool
+
+	// FollowGetForms adds to a page's links the url each of its
+	// GET forms submits to when submitted untouched: its action,
+	// with the default values of its fields as the query. This
+	// finds the pages of sites reached only through a search form,
+	// say. POST forms are left alone, as are <select> fields. It
+	// has no effect with a LinkExtractor.
+	FollowGetForms bool
+
+	// ExtractAssets has the fetcher report the images, scripts,
+	// stylesheets and other resources each page uses, in its
+	// Assets, for a crawl that checks them as well as the pages. It
+	// has no effect with a LinkExtractor.
+	ExtractAssets bool
+
+	// ExtractStructuredData has the fetcher report the JSON-LD
+	// structured data of each page, the contents of its
+	// <script type="application/ld+json"> tags, in its
+	// StructuredData. Scripts that aren't valid JSON are left out.
+	// It has no effect with a LinkExtractor.
+	ExtractStructuredData bool
+
+	// Validators, if set, remembers the ETag and Last-Modified of
+	// each page fetched, so that fetching it again asks the server
+	// to send it only if it has changed. An unchanged page comes
+	// back with status 304 Not Modified and no body, but with the
+	// links and the rest of what the fetcher found on it before.
+	Validators ValidatorCache
+
+	// HeadFirst has the fetcher send a HEAD request for each url
+	// before fetching it, and not fetch pages that the headers show
+	// it would not read: those of types not in ContentTypes, which
+	// are returned without their body or links as usual, and those
+	// whose Content-Length is over MaxBodyBytes, which fail with a
+	// *BodyTooLargeError. This saves downloading pages that would be
+	// thrown away, at the cost of a request for every page that
+	// isn't. A server that refuses HEAD requests is fetched from as
+	// usual.
+	HeadFirst bool
+
+	// TLSConfig, if set, replaces the TLS configuration of the
+	// client's transport, to trust a private certificate authority,
+	// say, or present a client certificate. Certificates are
+	// verified unless it sets InsecureSkipVerify. It only applies
+	// to a client whose Transport is nil or an *http.Transport.
+	TLSConfig *tls.Config
+
+	// MaxIdleConnsPerHost is the most connections to any one host
+	// kept open for reuse once a fetch is done with them. Keeping
+	// one per worker saves dialing, and a TLS handshake, for every
+	// page of a crawl that stays on one host. Zero leaves the
+	// transport's setting, two for net/http's. Like the other
+	// connection settings it only applies to a client whose
+	// Transport is nil or an *http.Transport.
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost, if positive, is the most connections open to
+	// any one host at once; fetches beyond that wait for one.
+	MaxConnsPerHost int
+
+	// IdleConnTimeout, if positive, is how long a connection kept
+	// for reuse may stay idle before it is closed.
+	IdleConnTimeout time.Duration
+
+	// ForceHTTP2 has the fetcher try HTTP/2 with every server that
+	// supports it, even with a TLSConfig or a transport of its own,
+	// which would otherwise turn that off.
+	ForceHTTP2 bool
+
+	// SendReferer has the fetcher send, as the Referer of each
+	// request, the url of the page that linked to the one fetched,
+	// taken from the fetch's context, as a browser following the
+	// link would. Some sites serve pages differently depending on
+	// it. It is off by default, as it tells each site the urls of
+	// the pages that link to it. Like a browser the fetcher sends no
+	// Referer from an https page to an http one, and leaves out any
+	// fragment and user name.
+	SendReferer bool
+
+	// TraceTiming has the fetcher time the phases of each fetch, in
+	// the Timing of its page: the DNS lookup, connect, TLS handshake
+	// and wait for the first byte. It is off by default, as it costs
+	// a little on every request.
+	TraceTiming bool
+
+	// Jar, if set, keeps the cookies servers set and sends them
+	// back with later requests, so a session begun on one page
+	// carries on to the next. It replaces any Jar of the client.
+	Jar http.CookieJar
+}
+
+// basicAuth holds the credentials for one host.
+type basicAuth struct {
+	username, password string
+}
+
+// SetBasicAuth has the fetcher authenticate its requests to host
+// with HTTP basic authentication. The credentials are sent to that
+// host only, never to other hosts a crawl may lead to. Like the
+// exported fields, they must not change once fetching has started.
+func (f *HTTPFetcher) SetBasicAuth(host, username, password string) {
+	if f.auth == nil {
+		f.auth = make(map[string]basicAuth)
+	}
+	f.auth[strings.ToLower(host)] = basicAuth{username, password}
+}
+
+// NewHTTPFetcher returns an HTTPFetcher that issues its requests with
+// client and identifies itself as DefaultUserAgent. If client is nil,
+// http.DefaultClient is used.
+func NewHTTPFetcher(client *http.Client) *HTTPFetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPFetcher{
+		client:       client,
+		UserAgent:    DefaultUserAgent,
+		Header:       make(http.Header),
+		MaxRedirects: DefaultMaxRedirects,
+		ContentTypes: slices.Clone(DefaultContentTypes),
+		MaxBodyBytes: DefaultMaxBodyBytes,
+		Validators:   new(MemoryValidatorCache),
+
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+	}
+}
+
+// NewProxyClient returns an http.Client for an HTTPFetcher that sends
+// its requests through the proxy at proxyURL, which may be an http,
+// https or socks5 url. If proxyURL is empty the proxy is taken from
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func NewProxyClient(proxyURL string) (*http.Client, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL == "" {
+		t.Proxy = http.ProxyFromEnvironment
+		return &http.Client{Transport: t}, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %q: unsupported scheme %q", proxyURL, u.Scheme)
+	}
+	t.Proxy = http.ProxyURL(u)
+	return &http.Client{Transport: t}, nil
+}
+
+// clientFor returns the client to fetch rawurl with: the fetcher's
+// client, but enforcing its redirect policy.
+func (f *HTTPFetcher) clientFor(rawurl string) *http.Client {
+	c := *f.client
+	if f.Jar != nil {
+		c.Jar = f.Jar
+	}
+	if f.tunesTransport() {
+		c.Transport = f.transport()
+	}
+	next := f.client.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > f.MaxRedirects {
+			return &TooManyRedirectsError{rawurl}
+		}
+		if len(f.AllowedHosts) > 0 && !slices.ContainsFunc(f.AllowedHosts, func(h string) bool {
+			return strings.EqualFold(h, req.URL.Hostname())
+		}) {
+			return &RedirectRefusedError{rawurl, req.URL.String()}
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		return nil
+	}
+	return &c
+}
+
+// tunesTransport reports whether the fetcher changes the settings of
+// the client's transport.
+func (f *HTTPFetcher) tunesTransport() bool {
+	return f.TLSConfig != nil || f.MaxIdleConnsPerHost > 0 || f.MaxConnsPerHost > 0 ||
+		f.IdleConnTimeout > 0 || f.ForceHTTP2
+}
+
+// transport returns the client's transport with TLSConfig and the
+// connection settings applied, made on first use so that its
+// connections are reused.
+func (f *HTTPFetcher) transport() http.RoundTripper {
+	f.transportOnce.Do(func() {
+		rt := f.client.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		if t, ok := rt.(*http.Transport); ok {
+			t = t.Clone()
+			if f.TLSConfig != nil {
+				t.TLSClientConfig = f.TLSConfig
+			}
+			if f.MaxIdleConnsPerHost > 0 {
+				t.MaxIdleConnsPerHost = f.MaxIdleConnsPerHost
+				// the limit for all hosts mustn't get in its way
+				if t.MaxIdleConns > 0 && t.MaxIdleConns < f.MaxIdleConnsPerHost {
+					t.MaxIdleConns = f.MaxIdleConnsPerHost
+				}
+			}
+			if f.MaxConnsPerHost > 0 {
+				t.MaxConnsPerHost = f.MaxConnsPerHost
+			}
+			if f.IdleConnTimeout > 0 {
+				t.IdleConnTimeout = f.IdleConnTimeout
+			}
+			if f.ForceHTTP2 {
+				t.ForceAttemptHTTP2 = true
+			}
+			rt = t
+		}
+		f.ownTransport = rt
+	})
+	return f.ownTransport
+}
+
+// CloseIdleConnections closes the connections the fetcher keeps open
+// for reuse that are not in use, as http.Client does.
+func (f *HTTPFetcher) CloseIdleConnections() {
+	f.client.CloseIdleConnections()
+	if f.tunesTransport() {
+		if c, ok := f.transport().(idleCloser); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+// newRequest returns a request for rawurl carrying the fetcher's
+// headers.
+func (f *HTTPFetcher) newRequest(ctx context.Context, method, rawurl string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.Header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	if f.AcceptLanguage != "" {
+		req.Header.Set("Accept-Language", f.AcceptLanguage)
+	}
+	if a, ok := f.auth[strings.ToLower(req.URL.Hostname())]; ok {
+		req.SetBasicAuth(a.username, a.password)
+	}
+	if f.SendReferer {
+		if ref := referer(ctx, req.URL); ref != "" {
+			req.Header.Set("Referer", ref)
+		}
+	}
+	return req, nil
+}
+
+// referer returns the Referer to send with a request for u made with
+// ctx, for SendReferer, or "" if there is none to send.
+func referer(ctx context.Context, u *url.URL) string {
+	parent, ok := ParentFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	p, err := url.Parse(parent)
+	if err != nil || !p.IsAbs() {
+		return ""
+	}
+	if strings.EqualFold(p.Scheme, "https") && !strings.EqualFold(u.Scheme, "https") {
+		return ""
+	}
+	p.User, p.Fragment, p.RawFragment = nil, "", ""
+	return p.String()
+}
+
+// parses reports whether the fetcher reads and parses pages with the
+// given Content-Type header.
+func (f *HTTPFetcher) parses(contentType string) bool {
+	if len(f.ContentTypes) == 0 || contentType == "" {
+		return true
+	}
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(f.ContentTypes, func(t string) bool {
+		return strings.EqualFold(t, mediatype)
+	})
+}
+
+// readBody reads the body of rawurl from r, up to MaxBodyBytes.
+func (f *HTTPFetcher) readBody(rawurl string, r io.Reader) ([]byte, error) {
+	if f.MaxBodyBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	// read one byte too many to tell a body of exactly the limit
+	// from a bigger one
+	b, err := io.ReadAll(io.LimitReader(r, f.MaxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > f.MaxBodyBytes {
+		return nil, &BodyTooLargeError{rawurl, f.MaxBodyBytes}
+	}
+	return b, nil
+}
+
+// extractLinks finds the links on a page with LinkExtractor, or in
+// its anchor tags if that isn't set, along with what else the page
+// says about itself.
+func (f *HTTPFetcher) extractLinks(base, body, contentType string) ([]string, pageMeta, error) {
+	if f.LinkExtractor != nil {
+		links, err := f.LinkExtractor(base, body, contentType)
+		return links, pageMeta{}, err
+	}
+	return scanLinks(base, body, f.FollowMetaRefresh, f.FollowGetForms)
+}
+
+// Fetch implements Fetcher.
+func (f *HTTPFetcher) Fetch(ctx context.Context, rawurl string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, rawurl))
+}
+
+// FetchPage implements PageFetcher. A response with a status other
+// than 2xx gives a *StatusError, along with a page holding the status
+// code and body but no links. A page whose content type isn't in
+// ContentTypes is returned without its body or links.
+func (f *HTTPFetcher) FetchPage(ctx context.Context, rawurl string) (*Page, error) {
+	// the first byte of each response along a redirect chain
+	// replaces the last, leaving the one that served the page
+	start := time.Now()
+	var ttfb time.Duration
+	var timing *timingTrace
+	if f.TraceTiming {
+		timing = new(timingTrace)
+		ctx = httptrace.WithClientTrace(ctx, timing.trace())
+	}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { ttfb = time.Since(start) },
+	})
+	if f.HeadFirst {
+		if page, err := f.head(ctx, rawurl); page != nil || err != nil {
+			return page, err
+		}
+	}
+	req, err := f.newRequest(ctx, http.MethodGet, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	var cached Validators
+	var haveCached bool
+	if f.Validators != nil {
+		cached, haveCached = f.Validators.Get(rawurl)
+		if haveCached {
+			cached.setConditional(req)
+		}
+	}
+	resp, err := f.clientFor(rawurl).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	page := &Page{
+		StatusCode:    resp.StatusCode,
+		ContentType:   resp.Header.Get("Content-Type"),
+		RedirectChain: redirectChain(resp),
+		TTFB:          ttfb,
+	}
+	if timing != nil {
+		page.Timing = timing.timing()
+	}
+	if resp.StatusCode == http.StatusNotModified && haveCached {
+		cached.restore(page)
+		return page, nil
+	}
+	ok := resp.StatusCode >= 200 && resp.StatusCode <= 299
+	if ok && !f.parses(page.ContentType) {
+		// a leaf: closing the body unread abandons the download
+		f.remember(rawurl, resp, page)
+		return page, nil
+	}
+
+	body, err := decodedBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", rawurl, err)
+	}
+	b, err := f.readBody(rawurl, body)
+	if err != nil {
+		return nil, err
+	}
+	page.Body = string(b)
+	if !ok {
+		return page, &StatusError{URL: rawurl, StatusCode: resp.StatusCode}
+	}
+
+	// resolve links against the final url in case of redirects
+	var meta pageMeta
+	page.URLs, meta, err = f.extractLinks(resp.Request.URL.String(), page.Body, page.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	meta.fill(page)
+	if f.ExtractAssets {
+		page.Assets = meta.assets
+	}
+	if f.ExtractStructuredData {
+		page.StructuredData = meta.structured
+	}
+	f.remember(rawurl, resp, page)
+	return page, nil
+}
+
+// head sends a HEAD request for rawurl, for HeadFirst. It returns
+// the page or error to give instead of fetching rawurl, or nil and
+// nil if rawurl should be fetched, as it should if the server doesn't
+// answer HEAD requests.
+func (f *HTTPFetcher) head(ctx context.Context, rawurl string) (*Page, error) {
+	req, err := f.newRequest(ctx, http.MethodHead, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.clientFor(rawurl).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// such as 405 Method Not Allowed; the GET will tell
+		return nil, nil
+	}
+	if !f.parses(resp.Header.Get("Content-Type")) {
+		return &Page{
+			StatusCode:    resp.StatusCode,
+			ContentType:   resp.Header.Get("Content-Type"),
+			RedirectChain: redirectChain(resp),
+		}, nil
+	}
+	if f.MaxBodyBytes > 0 && resp.ContentLength > f.MaxBodyBytes {
+		return nil, &BodyTooLargeError{rawurl, f.MaxBodyBytes}
+	}
+	return nil, nil
+}
+
+// decodedBody returns the body of resp, decompressed according to its
+// Content-Encoding. The transport decompresses gzip itself when it
+// asked for it, but not when Header asks for it instead. MaxBodyBytes
+// applies to the decompressed body, so a small download can't expand
+// without limit.
+func decodedBody(resp *http.Response) (io.Reader, error) {
+	if resp.Uncompressed {
+		return resp.Body, nil
+	}
+	switch enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// meant to be zlib, but some servers send raw deflate
+		br := bufio.NewReader(resp.Body)
+		if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint(h[0])<<8|uint(h[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", enc)
+	}
+}
+
+// redirectChain returns the urls requested on the way to resp, first
+// to last, or nil if there were no redirects.
+func redirectChain(resp *http.Response) []string {
+	if resp.Request.Response == nil {
+		return nil
+	}
+	var chain []string
+	for req := resp.Request; req != nil; {
+		chain = append(chain, req.URL.String())
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// remember stores the validators of resp, the response for rawurl,
+// along with what was found on page, its page, if it has any
+// validators.
+func (f *HTTPFetcher) remember(rawurl string, resp *http.Response, page *Page) {
+	if f.Validators == nil {
+		return
+	}
+	if v, ok := validatorsOf(resp); ok {
+		v.record(page)
+		f.Validators.Put(rawurl, v)
+	}
+}
+
+// timingTrace times the phases of a fetch for TraceTiming. Each phase
+// of a later response, along a redirect chain, replaces that of the
+// one before. It is safe for concurrent use, as the dials of one
+// request may race each other.
+type timingTrace struct {
+	mu                       sync.Mutex
+	dnsStart, connectStart   time.Time
+	tlsStart, wroteRequest   time.Time
+	dns, connect, tls, first time.Duration
+}
+
+// trace returns the hooks that time the fetch.
+func (t *timingTrace) trace() *httptrace.ClientTrace {
+	// mark records the time of a phase starting in *at
+	mark := func(at *time.Time) {
+		t.mu.Lock()
+		*at = time.Now()
+		t.mu.Unlock()
+	}
+	// took records how long a phase begun at *at took in *d
+	took := func(d *time.Duration, at *time.Time) {
+		t.mu.Lock()
+		if !at.IsZero() {
+			*d = time.Since(*at)
+		}
+		t.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		// each request along a redirect chain starts afresh
+		GetConn: func(string) {
+			t.mu.Lock()
+			t.dnsStart, t.connectStart, t.tlsStart, t.wroteRequest = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+			t.dns, t.connect, t.tls, t.first = 0, 0, 0, 0
+			t.mu.Unlock()
+		},
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { took(&t.dns, &t.dnsStart) },
+		ConnectStart:         func(string, string) { mark(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { took(&t.connect, &t.connectStart) },
+		TLSHandshakeStart:    func() { mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { took(&t.tls, &t.tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(&t.wroteRequest) },
+		GotFirstResponseByte: func() { took(&t.first, &t.wroteRequest) },
+	}
+}
+
+// timing returns the phases timed so far.
+func (t *timingTrace) timing() *Timing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Timing{DNSLookup: t.dns, Connect: t.connect, TLSHandshake: t.tls, TTFB: t.first}
+}
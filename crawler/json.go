@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"time"
+)
+
+// jsonResult is the JSON form of a CrawlResult.
+type jsonResult struct {
+	URL          string            `json:"url"`
+	Depth        int               `json:"depth"`
+	Parent       string            `json:"parent,omitempty"`
+	Status       int               `json:"status,omitempty"`
+	Redirects    []string          `json:"redirects,omitempty"`
+	Canonical    string            `json:"canonical,omitempty"`
+	Title        string            `json:"title,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Links        []string          `json:"links"`
+	Assets       []string          `json:"assets,omitempty"`
+	Structured   []json.RawMessage `json:"structured_data,omitempty"`
+	Asset        bool              `json:"asset,omitempty"`
+	BodyHash     string            `json:"body_hash,omitempty"`
+	Unchanged    bool              `json:"unchanged,omitempty"`
+	DepthLimited bool              `json:"depth_limited,omitempty"`
+	SoftNotFound bool              `json:"soft_not_found,omitempty"`
+	Empty        bool              `json:"empty,omitempty"`
+	Timing       *jsonTiming       `json:"timing,omitempty"`
+	Body         string            `json:"body,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// jsonTiming is the JSON form of a Timing, in milliseconds.
+type jsonTiming struct {
+	DNSLookup    float64 `json:"dns_ms"`
+	Connect      float64 `json:"connect_ms"`
+	TLSHandshake float64 `json:"tls_ms"`
+	TTFB         float64 `json:"ttfb_ms"`
+}
+
+// millis returns d in milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newJSONResult(r CrawlResult, withBody bool) jsonResult {
+	j := jsonResult{
+		URL:          r.URL,
+		Depth:        r.Depth,
+		Parent:       r.ParentURL,
+		Status:       r.StatusCode,
+		Redirects:    r.RedirectChain,
+		Canonical:    r.Canonical,
+		Title:        r.Title,
+		Description:  r.Description,
+		Links:        r.Links,
+		Assets:       r.Assets,
+		Asset:        r.Asset,
+		BodyHash:     r.BodyHash,
+		Unchanged:    r.Unchanged,
+		DepthLimited: r.DepthLimited,
+		SoftNotFound: r.SoftNotFound,
+		Empty:        r.Empty,
+	}
+	if t := r.Timing; t != nil {
+		j.Timing = &jsonTiming{
+			DNSLookup:    millis(t.DNSLookup),
+			Connect:      millis(t.Connect),
+			TLSHandshake: millis(t.TLSHandshake),
+			TTFB:         millis(t.TTFB),
+		}
+	}
+	for _, b := range r.StructuredData {
+		j.Structured = append(j.Structured, b)
+	}
+	if j.Links == nil {
+		j.Links = []string{}
+	}
+	if withBody {
+		j.Body = r.Body
+	}
+	if r.Err != nil {
+		j.Error = r.Err.Error()
+	}
+	return j
+}
+
+// WriteJSON writes results to w as a JSON array with one object per
+// page, sorted by url. Page bodies can be large and are left out
+// unless withBodies is set. Each result is encoded and written in
+// turn, so the whole document is never held in memory.
+func WriteJSON(w io.Writer, results []CrawlResult, withBodies bool) error {
+	results = slices.Clone(results)
+	sortResults(results)
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, r := range results {
+		b, err := json.Marshal(newJSONResult(r, withBodies))
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if i == 0 {
+			sep = "\n"
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+// StreamNDJSON writes each result received from results to w as it
+// arrives, as one JSON object per line, until results is closed. Page
+// bodies are left out. Each line is written on its own, and flushed
+// if w has a Flush method, so that memory use doesn't grow with the
+// crawl. It returns at the first error writing to w, after which the
+// caller should cancel the crawl, as nothing reads results any more.
+func StreamNDJSON(w io.Writer, results <-chan CrawlResult) error {
+	flusher, _ := w.(interface{ Flush() error })
+	for r := range results {
+		b, err := json.Marshal(newJSONResult(r, false))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		if flusher != nil {
+			if err := flusher.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	results := crawlRawData(t, CrawlConfig{})
+	var want []string
+	for _, r := range results {
+		want = append(want, r.URL)
+	}
+	slices.Sort(want)
+
+	for _, withBodies := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := WriteJSON(&buf, results, withBodies); err != nil {
+			t.Fatal(err)
+		}
+		var got []jsonResult
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("WriteJSON wrote invalid JSON: %v\n%s", err, buf.Bytes())
+		}
+		var urls []string
+		for _, j := range got {
+			urls = append(urls, j.URL)
+			if (j.Body != "") != (withBodies && j.Error == "") {
+				t.Errorf("withBodies %v: %s has body %q", withBodies, j.URL, j.Body)
+			}
+			if j.URL == "https://golang.org/pkg/fmt/" &&
+				(j.Parent != "https://golang.org/pkg/" || j.Depth != 2 || len(j.Links) != 2) {
+				t.Errorf("%s: parent %q, depth %d, links %q", j.URL, j.Parent, j.Depth, j.Links)
+			}
+		}
+		if !slices.Equal(urls, want) {
+			t.Errorf("withBodies %v: urls %q, want %q", withBodies, urls, want)
+		}
+	}
+}
+
+// flushWriter counts its flushes, failing writes after the first n
+// if n is positive.
+type flushWriter struct {
+	bytes.Buffer
+	n       int
+	writes  int
+	flushes int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	if w.writes++; w.n > 0 && w.writes > w.n {
+		return 0, errWriteFailed
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *flushWriter) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	results := crawlRawData(t, CrawlConfig{Sequential: true})
+	send := func() <-chan CrawlResult {
+		c := make(chan CrawlResult)
+		go func() {
+			for _, r := range results {
+				c <- r
+			}
+			close(c)
+		}()
+		return c
+	}
+	var w flushWriter
+	if err := StreamNDJSON(&w, send()); err != nil {
+		t.Fatal(err)
+	}
+
+	var i int
+	for sc := bufio.NewScanner(&w.Buffer); sc.Scan(); i++ {
+		var j jsonResult
+		if err := json.Unmarshal(sc.Bytes(), &j); err != nil {
+			t.Fatalf("line %d: %v\n%s", i+1, err, sc.Bytes())
+		}
+		r := results[i]
+		if j.URL != r.URL || j.Depth != r.Depth || (j.Error != "") != (r.Err != nil) || j.Body != "" {
+			t.Errorf("line %d: %+v, want %s at depth %d, error %v", i+1, j, r.URL, r.Depth, r.Err)
+		}
+	}
+	if i != len(results) || w.flushes != len(results) {
+		t.Errorf("%d lines, %d flushes; want %d of each", i, w.flushes, len(results))
+	}
+
+	// a closed channel is nothing to write
+	empty := make(chan CrawlResult)
+	close(empty)
+	var buf bytes.Buffer
+	if err := StreamNDJSON(&buf, empty); err != nil || buf.Len() != 0 {
+		t.Errorf("StreamNDJSON of no results: %q, %v", buf.String(), err)
+	}
+
+	// a write error stops it reading more results
+	c := send()
+	if err := StreamNDJSON(&flushWriter{n: 2}, c); !errors.Is(err, errWriteFailed) {
+		t.Errorf("StreamNDJSON with a failing writer: %v", err)
+	}
+	left := 0
+	for range c {
+		left++
+	}
+	if left != len(results)-3 {
+		t.Errorf("%d results read after the failed write, want none", len(results)-3-left)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// This file holds the crawl entry points that predate CrawlConfig.
+// They are thin wrappers around Crawl, configured by CrawlOptions.
+
+// CrawlInto uses fetcher to crawl pages starting with url, to a
+// maximum of depth levels of links (see CrawlConfig.Depth), sending
+// each page fetched on c and logging fetch errors. It calls wg.Done
+// when the crawl is finished, so the caller should wg.Add(1) before
+// starting it in a goroutine.
+//
+// Once ctx is cancelled no new fetches are started and the crawl
+// winds down, even if it is blocked sending on c, so a consumer that
+// wants to stop reading early only has to cancel ctx.
+func CrawlInto(ctx context.Context, url string, depth int, fetcher Fetcher, c chan<- CrawlResult, wg *sync.WaitGroup, opts ...CrawlOption) {
+	defer wg.Done()
+	cfg := legacyConfig(depth, 0, fetcher, opts)
+	results, err := Crawl(ctx, url, cfg)
+	if err != nil {
+		cfg.logger().Error("crawl failed to start", "url", url, "err", err)
+		return
+	}
+	forward(ctx, results, c)
+}
+
+// CrawlN crawls pages starting with url, to a maximum of depth, like
+// CrawlInto, but never runs more than maxWorkers fetches at once.
+// Results are sent on the returned channel, which is closed when the
+// crawl is finished or ctx is cancelled. The channel is unbuffered
+// unless WithBufferSize is given.
+//
+// The consumer need not read every result: if it stops reading it
+// must cancel ctx, after which the crawl stops and the channel is
+// closed. Nothing is left running.
+func CrawlN(ctx context.Context, url string, depth, maxWorkers int, fetcher Fetcher, opts ...CrawlOption) <-chan CrawlResult {
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+	cfg := legacyConfig(depth, maxWorkers, fetcher, opts)
+	c := make(chan CrawlResult, max(cfg.BufferSize, 0))
+
+	results, err := Crawl(ctx, url, cfg)
+	if err != nil {
+		cfg.logger().Error("crawl failed to start", "url", url, "err", err)
+		close(c)
+		return c
+	}
+	go func() {
+		defer close(c)
+		forward(ctx, results, c)
+	}()
+	return c
+}
+
+// CrawlErrors crawls pages starting with url, to a maximum of depth,
+// and returns every page fetched along with the joined errors of the
+// pages that failed. Each failure is a *FetchError naming its url,
+// so a nil error means the whole graph was crawled successfully.
+// If ctx is cancelled the crawl stops early; the pages fetched so far
+// are still returned and the error includes ctx.Err(). Likewise if
+// the crawl runs out of time given by WithMaxDuration the error
+// includes ErrMaxDuration, if it is stopped by
+// WithMaxConsecutiveErrors, the *CircuitOpenError, and if by
+// WithIdleTimeout, ErrIdleTimeout.
+func CrawlErrors(ctx context.Context, url string, depth int, fetcher Fetcher, opts ...CrawlOption) ([]CrawlResult, error) {
+	cfg := legacyConfig(depth, 0, fetcher, opts)
+	if cfg.Stats == nil {
+		cfg.Stats = new(CrawlStats)
+	}
+	results, err := Crawl(ctx, url, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []CrawlResult
+	var errs []error
+	for r := range results {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		pages = append(pages, r)
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	} else if cfg.Stats.DeadlineExceeded {
+		errs = append(errs, ErrMaxDuration)
+	} else if cfg.Stats.CircuitOpen != nil {
+		errs = append(errs, cfg.Stats.CircuitOpen)
+	} else if cfg.Stats.IdleTimedOut {
+		errs = append(errs, ErrIdleTimeout)
+	}
+	return pages, errors.Join(errs...)
+}
+
+// legacyConfig returns the configuration of a crawl to depth, which
+// means the same as CrawlConfig.Depth, with opts applied.
+func legacyConfig(depth, maxWorkers int, fetcher Fetcher, opts []CrawlOption) CrawlConfig {
+	cfg := CrawlConfig{Fetcher: fetcher, Depth: depth, MaxWorkers: maxWorkers}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// forward sends the pages in results on c, dropping the failures,
+// which the crawl has already logged.
+// If ctx is cancelled it stops sending but still drains results, so
+// that the crawl's stats are complete by the time it returns.
+func forward(ctx context.Context, results <-chan CrawlResult, c chan<- CrawlResult) {
+	defer func() {
+		for range results {
+		}
+	}()
+	for r := range results {
+		if r.Err != nil {
+			continue
+		}
+		select {
+		case c <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
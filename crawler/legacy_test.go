@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// checkGoroutines fails t if, once it ends, more goroutines are left
+// running than there were when checkGoroutines was called.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		// give goroutines that were told to stop time to return
+		deadline := time.Now().Add(time.Second)
+		n := runtime.NumGoroutine()
+		for ; n > before && time.Now().Before(deadline); n = runtime.NumGoroutine() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n > before {
+			buf := make([]byte, 1<<16)
+			t.Errorf("%d goroutines leaked:\n%s", n-before, buf[:runtime.Stack(buf, true)])
+		}
+	})
+}
+
+// cancellingFetcher cancels a crawl's context as its nth fetch
+// starts, and counts the fetches started after that.
+type cancellingFetcher struct {
+	Fetcher
+	n      int
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	fetches int
+	late    int
+}
+
+func (f *cancellingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.mu.Lock()
+	if ctx.Err() != nil {
+		f.late++
+	}
+	f.fetches++
+	if f.fetches == f.n {
+		f.cancel()
+	}
+	f.mu.Unlock()
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+// overlapFetcher records the most fetches it has had in flight at
+// once.
+type overlapFetcher struct {
+	Fetcher
+	inFlight, most atomic.Int32
+}
+
+func (f *overlapFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for m := f.most.Load(); n > m && !f.most.CompareAndSwap(m, n); m = f.most.Load() {
+	}
+	time.Sleep(time.Millisecond)
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func TestCrawlNMaxWorkers(t *testing.T) {
+	for _, workers := range []int{1, 2, 4} {
+		f := &overlapFetcher{Fetcher: crawltest.NewFakeFetcher(syntheticGraph(50, 5))}
+		var pages int
+		for range CrawlN(context.Background(), "http://example.com/p0", 10, workers, f) {
+			pages++
+		}
+		if pages != 50 {
+			t.Errorf("CrawlN with %d workers crawled %d pages, want 50", workers, pages)
+		}
+		if most := int(f.most.Load()); most > workers {
+			t.Errorf("CrawlN with %d workers ran %d fetches at once", workers, most)
+		} else if workers > 1 && most < 2 {
+			t.Errorf("CrawlN with %d workers never ran fetches at once", workers)
+		}
+	}
+}
+
+func TestCrawlErrors(t *testing.T) {
+	errDown := errors.New("server down")
+	f := crawltest.NewFakeFetcher(map[string][]string{
+		"http://example.com/":  {"http://example.com/a", "http://example.com/gone", "http://example.com/b"},
+		"http://example.com/a": {"http://example.com/a/gone"},
+		"http://example.com/b": {"http://example.com/down"},
+	})
+	f.SetError("http://example.com/down", errDown)
+
+	pages, err := CrawlErrors(context.Background(), "http://example.com/", 3, f)
+	var got []string
+	for _, p := range pages {
+		got = append(got, p.URL)
+	}
+	slices.Sort(got)
+	if want := []string{"http://example.com/", "http://example.com/a", "http://example.com/b"}; !slices.Equal(got, want) {
+		t.Errorf("CrawlErrors pages: %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		url, parent string
+		err         error
+	}{
+		{"http://example.com/gone", "http://example.com/", crawltest.ErrNotFound},
+		{"http://example.com/a/gone", "http://example.com/a", crawltest.ErrNotFound},
+		{"http://example.com/down", "http://example.com/b", errDown},
+	}
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	if len(errs) != len(tests) {
+		t.Fatalf("CrawlErrors error: %v, want %d failures", err, len(tests))
+	}
+	for _, tt := range tests {
+		i := slices.IndexFunc(errs, func(err error) bool {
+			var fe *FetchError
+			return errors.As(err, &fe) && fe.URL == tt.url
+		})
+		if i < 0 {
+			t.Errorf("no *FetchError for %s in %v", tt.url, err)
+			continue
+		}
+		fe := errs[i].(*FetchError)
+		if fe.Parent != tt.parent || !errors.Is(fe, tt.err) {
+			t.Errorf("%s failed with parent %q, err %v; want parent %q, err %v",
+				tt.url, fe.Parent, fe.Err, tt.parent, tt.err)
+		}
+	}
+}
+
+func TestCrawlNStopReading(t *testing.T) {
+	for _, size := range []int{0, 1, 100} {
+		t.Run(fmt.Sprint("buffer ", size), func(t *testing.T) {
+			checkGoroutines(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			f := crawltest.NewFakeFetcher(syntheticGraph(200, 5))
+			c := CrawlN(ctx, "http://example.com/p0", 10, 4, f, WithBufferSize(size))
+
+			// only the first page is wanted
+			if r := <-c; r.URL != "http://example.com/p0" {
+				t.Errorf("first result %s, want the seed", r.URL)
+			}
+			cancel()
+
+			// the channel is closed, and nothing is left running
+			timeout := time.After(5 * time.Second)
+			for done := false; !done; {
+				select {
+				case _, ok := <-c:
+					done = !ok
+				case <-timeout:
+					t.Fatal("results channel still open 5s after the cancel")
+				}
+			}
+		})
+	}
+}
+
+// panickingFetcher panics fetching url.
+type panickingFetcher struct {
+	Fetcher
+	url string
+}
+
+func (f panickingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	if url == f.url {
+		panic("fetcher bug")
+	}
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func TestLegacyCrawlsLeaveNothingRunning(t *testing.T) {
+	const seed = "https://golang.org/"
+	tests := []struct {
+		name  string
+		crawl func(f Fetcher) []CrawlResult
+	}{
+		{"CrawlInto", func(f Fetcher) []CrawlResult {
+			c := make(chan CrawlResult)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go CrawlInto(context.Background(), seed, 4, f, c, &wg)
+			go func() {
+				wg.Wait()
+				close(c)
+			}()
+			var pages []CrawlResult
+			for r := range c {
+				pages = append(pages, r)
+			}
+			return pages
+		}},
+		{"CrawlN", func(f Fetcher) []CrawlResult {
+			var pages []CrawlResult
+			for r := range CrawlN(context.Background(), seed, 4, 2, f) {
+				pages = append(pages, r)
+			}
+			return pages
+		}},
+		{"CrawlErrors", func(f Fetcher) []CrawlResult {
+			pages, _ := CrawlErrors(context.Background(), seed, 4, f)
+			return pages
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGoroutines(t)
+			if pages := tt.crawl(crawltest.NewFakeFetcher(rawDataGraph())); len(pages) != len(rawData) {
+				t.Errorf("crawled %d pages, want all %d", len(pages), len(rawData))
+			}
+			// nor when the fetcher panics on a page
+			f := panickingFetcher{crawltest.NewFakeFetcher(rawDataGraph()), "https://golang.org/pkg/fmt/"}
+			if pages := tt.crawl(f); len(pages) != len(rawData)-1 {
+				t.Errorf("crawled %d pages with a panicking fetch, want %d", len(pages), len(rawData)-1)
+			}
+		})
+	}
+}
+
+func TestCrawlIntoCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		opts []CrawlOption
+	}{
+		{"seed", 1, []CrawlOption{WithSequential()}},
+		{"mid-crawl", 10, []CrawlOption{WithSequential()}},
+		{"breadth-first", 10, []CrawlOption{WithSequential(), WithBreadthFirst()}},
+		{"concurrent", 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			f := &cancellingFetcher{
+				Fetcher: crawltest.NewFakeFetcher(syntheticGraph(200, 5)),
+				n:       tt.n,
+				cancel:  cancel,
+			}
+
+			c := make(chan CrawlResult)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go CrawlInto(ctx, "http://example.com/p0", 10, f, c, &wg, tt.opts...)
+			go func() {
+				wg.Wait()
+				close(c)
+			}()
+
+			timeout := time.After(5 * time.Second)
+			for done := false; !done; {
+				select {
+				case _, ok := <-c:
+					done = !ok
+				case <-timeout:
+					t.Fatal("crawl still running 5s after its context was cancelled")
+				}
+			}
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			// in a concurrent crawl a worker may have checked ctx just
+			// before the cancel, so only a sequential one must stop dead
+			if tt.opts != nil && f.late != 0 {
+				t.Errorf("%d fetches started after the cancel", f.late)
+			}
+			if f.fetches >= 200 {
+				t.Errorf("fetched %d pages, the whole site, despite the cancel", f.fetches)
+			}
+		})
+	}
+}
+
+func TestCrawlErrorsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := &cancellingFetcher{
+		Fetcher: crawltest.NewFakeFetcher(syntheticGraph(200, 5)),
+		n:       10,
+		cancel:  cancel,
+	}
+
+	pages, err := CrawlErrors(ctx, "http://example.com/p0", 10, f)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CrawlErrors error %v, want context.Canceled", err)
+	}
+	// the pages fetched before the cancel are kept
+	if len(pages) == 0 || len(pages) >= 200 {
+		t.Errorf("CrawlErrors returned %d pages, want some but not the whole site", len(pages))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// ConcurrencyLimits bounds how a crawl's fetches are spread across
+// hosts. Zero fields mean no limit.
+type ConcurrencyLimits struct {
+	PerHost    int // most fetches from any one host at once
+	TotalHosts int // most hosts being fetched from at once
+}
+
+// hostLimiter enforces a ConcurrencyLimits. It is safe for
+// concurrent use.
+type hostLimiter struct {
+	limits ConcurrencyLimits
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active map[string]int // fetches in progress per host
+}
+
+// newHostLimiter returns a limiter for limits, or nil if they don't
+// limit anything.
+func newHostLimiter(limits ConcurrencyLimits) *hostLimiter {
+	if limits.PerHost <= 0 && limits.TotalHosts <= 0 {
+		return nil
+	}
+	l := &hostLimiter{limits: limits, active: make(map[string]int)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// full reports whether another fetch from host would break the
+// limits. It must be called with l.mu held.
+func (l *hostLimiter) full(host string) bool {
+	n := l.active[host]
+	if l.limits.PerHost > 0 && n >= l.limits.PerHost {
+		return true
+	}
+	return n == 0 && l.limits.TotalHosts > 0 && len(l.active) >= l.limits.TotalHosts
+}
+
+// acquire waits until a fetch from host is allowed and claims it,
+// returning ctx.Err() if ctx is cancelled first. A nil limiter allows
+// everything. Each successful acquire must be followed by a release.
+func (l *hostLimiter) acquire(ctx context.Context, host string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	// wake the waiters on cancellation so they can give up
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.full(host) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	l.active[host]++
+	return nil
+}
+
+// release gives back a fetch from host claimed by acquire.
+func (l *hostLimiter) release(host string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.active[host]--; l.active[host] == 0 {
+		delete(l.active, host)
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// hostPages counts the pages fetched from each host, for
+// PerHostPageLimit and HostPageLimits. It is safe for concurrent use.
+type hostPages struct {
+	limit  int            // PerHostPageLimit
+	limits map[string]int // HostPageLimits
+
+	mu    sync.Mutex
+	pages map[string]int // fetches started or completed per host
+}
+
+// limitFor returns how many pages may be fetched from host, or 0 for
+// no limit.
+func (h *hostPages) limitFor(host string) int {
+	if n, ok := h.limits[host]; ok {
+		return n
+	}
+	return h.limit
+}
+
+// reserve claims one of the pages host may have fetched from it,
+// reporting false if they have all been used.
+func (h *hostPages) reserve(host string) bool {
+	n := h.limitFor(host)
+	if n <= 0 {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pages[host] >= n {
+		return false
+	}
+	if h.pages == nil {
+		h.pages = make(map[string]int)
+	}
+	h.pages[host]++
+	return true
+}
+
+// release gives back a page from host claimed by reserve.
+func (h *hostPages) release(host string) {
+	if h.limitFor(host) <= 0 {
+		return
+	}
+	h.mu.Lock()
+	h.pages[host]--
+	h.mu.Unlock()
+}
+
+// counts returns a copy of the pages counted per host.
+func (h *hostPages) counts() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return maps.Clone(h.pages)
+}
+
+// restore sets the pages counted per host to counts.
+func (h *hostPages) restore(counts map[string]int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pages = maps.Clone(counts)
+}
+
+// cancelledHosts holds the hosts given to Crawler.CancelHost. It is
+// safe for concurrent use.
+type cancelledHosts struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+// add cancels host.
+func (c *cancelledHosts) add(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hosts == nil {
+		c.hosts = make(map[string]bool)
+	}
+	c.hosts[host] = true
+}
+
+// covers reports whether the host of rawurl has been cancelled.
+func (c *cancelledHosts) covers(rawurl string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.hosts) > 0 && c.hosts[hostOf(rawurl)]
+}
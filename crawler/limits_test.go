@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// peakConcurrency returns the most calls in progress at once, on any
+// one host and across hosts, and the most hosts with calls in
+// progress.
+func peakConcurrency(calls []FetchCall) (perHost, hosts int) {
+	for _, c := range calls {
+		active := make(map[string]int)
+		for _, d := range calls {
+			if !d.Start.After(c.Start) && d.End.After(c.Start) {
+				u, _ := url.Parse(d.URL)
+				active[u.Host]++
+			}
+		}
+		for _, n := range active {
+			perHost = max(perHost, n)
+		}
+		hosts = max(hosts, len(active))
+	}
+	return perHost, hosts
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	const seed = "http://a.example/"
+	graph := map[string][]string{seed: nil}
+	for _, host := range []string{"a", "b", "c"} {
+		for i := range 8 {
+			u := fmt.Sprintf("http://%s.example/p%d", host, i)
+			graph[seed] = append(graph[seed], u)
+			graph[u] = nil
+		}
+	}
+	tests := []struct {
+		name   string
+		limits ConcurrencyLimits
+	}{
+		{"per host", ConcurrencyLimits{PerHost: 2}},
+		{"total hosts", ConcurrencyLimits{TotalHosts: 1}},
+		{"both", ConcurrencyLimits{PerHost: 2, TotalHosts: 2}},
+	}
+	for _, tt := range tests {
+		fake := crawltest.NewFakeFetcher(graph)
+		for u := range graph {
+			fake.SetDelay(u, 5*time.Millisecond)
+		}
+		f := NewRecordingFetcher(fake)
+		pages, err := CrawlErrors(context.Background(), seed, 1, f, WithConcurrency(tt.limits))
+		if err != nil || len(pages) != len(graph) {
+			t.Errorf("%s: crawled %d pages, %v; want %d", tt.name, len(pages), err, len(graph))
+		}
+
+		perHost, hosts := peakConcurrency(f.Calls())
+		if tt.limits.PerHost > 0 && perHost > tt.limits.PerHost {
+			t.Errorf("%s: %d fetches from one host at once, want at most %d", tt.name, perHost, tt.limits.PerHost)
+		}
+		if tt.limits.TotalHosts > 0 && hosts > tt.limits.TotalHosts {
+			t.Errorf("%s: %d hosts fetched from at once, want at most %d", tt.name, hosts, tt.limits.TotalHosts)
+		}
+	}
+}
+
+func TestPerHostPageLimit(t *testing.T) {
+	// 20 pages on each of two hosts, each linking to every other
+	var pages []string
+	for _, host := range []string{"a", "b"} {
+		for i := range 20 {
+			pages = append(pages, fmt.Sprintf("http://%s.example/p%d", host, i))
+		}
+	}
+	graph := make(map[string][]string)
+	for _, u := range pages {
+		graph[u] = pages
+	}
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+		want map[string]int // pages fetched by host
+	}{
+		{"no limit", CrawlConfig{}, map[string]int{"a.example": 20, "b.example": 20}},
+		{"per host", CrawlConfig{PerHostPageLimit: 5}, map[string]int{"a.example": 5, "b.example": 5}},
+		{"per host, sequential", CrawlConfig{PerHostPageLimit: 5, Sequential: true}, map[string]int{"a.example": 5, "b.example": 5}},
+		{"by weight", CrawlConfig{PerHostPageLimit: 5, HostPageLimits: map[string]int{"b.example": 12}},
+			map[string]int{"a.example": 5, "b.example": 12}},
+		{"one host unlimited", CrawlConfig{PerHostPageLimit: 5, HostPageLimits: map[string]int{"a.example": 0}},
+			map[string]int{"a.example": 20, "b.example": 5}},
+		// the host limits leave the seed's host less than MaxPages
+		{"and MaxPages", CrawlConfig{PerHostPageLimit: 8, MaxPages: 12}, map[string]int{"a.example": 8, "b.example": 4}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth, cfg.MaxWorkers = f, Unlimited, 16
+		if cfg.MaxPages > 0 {
+			// so that b.example can't get ahead of a.example
+			cfg.Sequential = true
+		}
+		results, err := Crawl(context.Background(), "http://a.example/p0", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]int)
+		for r := range results {
+			if r.Err != nil {
+				t.Errorf("%s: %v", tt.name, r.Err)
+			}
+			got[hostOf(r.URL)]++
+		}
+		fetched := make(map[string]int)
+		for u := range graph {
+			fetched[hostOf(u)] += f.Fetches(u)
+		}
+		if !maps.Equal(got, tt.want) || !maps.Equal(fetched, tt.want) {
+			t.Errorf("%s: crawled %v, fetched %v; want %v", tt.name, got, fetched, tt.want)
+		}
+	}
+}
+
+// hostCancellingFetcher cancels host on cr when it starts the at'th fetch
+// from it, counting the fetches from host and those started after.
+type hostCancellingFetcher struct {
+	Fetcher
+	host string
+	at   int32
+	cr   *Crawler
+
+	fetches   atomic.Int32
+	cancelled atomic.Bool
+	late      atomic.Int32
+}
+
+func (f *hostCancellingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	if hostOf(url) == f.host {
+		if f.cancelled.Load() {
+			f.late.Add(1)
+		}
+		if f.fetches.Add(1) == f.at {
+			f.cr.CancelHost(strings.ToUpper(f.host))
+			f.cancelled.Store(true)
+		}
+	}
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func TestCancelHost(t *testing.T) {
+	// the seed links to 8 pages on each of three hosts, each page to
+	// the next on its host
+	const seed = "http://a.example/"
+	graph := map[string][]string{seed: nil}
+	for _, host := range []string{"a", "b", "c"} {
+		for i := range 8 {
+			u := fmt.Sprintf("http://%s.example/p%d", host, i)
+			graph[seed] = append(graph[seed], u)
+			graph[u] = []string{fmt.Sprintf("http://%s.example/p%d", host, (i+1)%8)}
+		}
+	}
+	tests := []struct {
+		name    string
+		at      int32 // or before the crawl starts, if 0
+		workers int
+	}{
+		{"before the start", 0, 4},
+		{"sequential", 3, 1},
+		{"concurrent", 3, 4},
+	}
+	for _, tt := range tests {
+		fake := crawltest.NewFakeFetcher(graph)
+		for u := range graph {
+			fake.SetDelay(u, time.Millisecond)
+		}
+		f := &hostCancellingFetcher{Fetcher: fake, host: "b.example", at: tt.at}
+		cr, err := NewCrawler([]string{seed}, CrawlConfig{Fetcher: f, Depth: Unlimited, MaxWorkers: tt.workers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.cr = cr
+		if tt.at == 0 {
+			cr.CancelHost("b.example")
+			f.cancelled.Store(true)
+		}
+		results, err := cr.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for r := range results {
+			if r.Err != nil && hostOf(r.URL) != "b.example" {
+				t.Errorf("%s: %v", tt.name, r.Err)
+			}
+		}
+
+		// fetches in progress when it was cancelled may still start,
+		// one for each other worker at most
+		allowed := int32(tt.workers - 1)
+		if tt.at == 0 {
+			allowed = 0
+		}
+		if n, late := f.fetches.Load(), f.late.Load(); n < tt.at || late > allowed {
+			t.Errorf("%s: %d fetches from b.example, %d after it was cancelled; want %d, then at most %d",
+				tt.name, n, late, tt.at, allowed)
+		}
+		// the others carry on
+		for u := range graph {
+			if hostOf(u) != "b.example" && fake.Fetches(u) != 1 {
+				t.Errorf("%s: %s fetched %d times, want once", tt.name, u, fake.Fetches(u))
+			}
+		}
+	}
+}
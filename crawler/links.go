@@ -0,0 +1,462 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"html"
+	"mime"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// htmlTag is a start or end tag found by scanTags.
+type htmlTag struct {
+	name  string            // lower case
+	attrs map[string]string // lower case keys, unescaped values
+	end   bool
+	text  string // raw contents of a start tag in rawTextTags
+}
+
+// rawTextTags are elements whose contents are not markup and must
+// be skipped when looking for tags.
+var rawTextTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+// scanTags calls fn for every tag in the HTML document body, in
+// document order. It is forgiving of malformed markup: anything that
+// doesn't look like a tag is treated as text, and a document that is
+// cut off part way through a tag simply ends there.
+func scanTags(body string, fn func(htmlTag)) {
+	for i := 0; i < len(body); {
+		lt := strings.IndexByte(body[i:], '<')
+		if lt < 0 {
+			return
+		}
+		i += lt + 1
+		rest := body[i:]
+
+		switch {
+		case strings.HasPrefix(rest, "!--"):
+			end := strings.Index(rest[3:], "-->")
+			if end < 0 {
+				return
+			}
+			i += 3 + end + 3
+			continue
+		case strings.HasPrefix(rest, "!"), strings.HasPrefix(rest, "?"):
+			// doctype or processing instruction
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				return
+			}
+			i += end + 1
+			continue
+		}
+
+		tag, n, ok := parseTag(rest)
+		if !ok {
+			continue
+		}
+		i += n
+		if tag.end || !rawTextTags[tag.name] {
+			fn(tag)
+			continue
+		}
+
+		end := indexFold(body[i:], "</"+tag.name)
+		if end < 0 {
+			tag.text = body[i:]
+			fn(tag)
+			return
+		}
+		tag.text = body[i : i+end]
+		fn(tag)
+		i += end
+	}
+}
+
+// parseTag parses the tag at the start of s, which follows a '<'.
+// It returns the tag and the number of bytes consumed, including
+// the closing '>'.
+func parseTag(s string) (htmlTag, int, bool) {
+	var tag htmlTag
+	i := 0
+	if i < len(s) && s[i] == '/' {
+		tag.end = true
+		i++
+	}
+	start := i
+	for i < len(s) && isNameByte(s[i]) {
+		i++
+	}
+	if i == start {
+		return tag, 0, false
+	}
+	tag.name = strings.ToLower(s[start:i])
+
+	for {
+		for i < len(s) && (isSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		if i >= len(s) {
+			return tag, 0, false
+		}
+		if s[i] == '>' {
+			return tag, i + 1, true
+		}
+
+		// attribute name
+		start = i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		name := strings.ToLower(s[start:i])
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+
+		var value string
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				q := s[i]
+				end := strings.IndexByte(s[i+1:], q)
+				if end < 0 {
+					return tag, 0, false
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start = i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+
+		if tag.attrs == nil {
+			tag.attrs = make(map[string]string)
+		}
+		// the first occurrence of an attribute wins, as in browsers
+		if _, dup := tag.attrs[name]; !dup {
+			tag.attrs[name] = html.UnescapeString(value)
+		}
+	}
+}
+
+func isNameByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '-' || b == ':'
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+// indexFold is like strings.Index but ignores ASCII case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		j := strings.IndexByte(s[i:], substr[0])
+		if j < 0 || i+j+len(substr) > len(s) {
+			return -1
+		}
+		i += j
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// skippedSchemes are link schemes that never lead to a crawlable page.
+var skippedSchemes = map[string]bool{
+	"mailto":     true,
+	"javascript": true,
+}
+
+// extractLinks returns the targets of the <a href> tags in the HTML
+// document body, resolved against base as ResolveURL does, so that
+// protocol-relative links take the scheme of base. Fragment-only
+// links and mailto: or javascript: links are skipped, as are hrefs
+// that don't parse. The only error is for a base that isn't a valid
+// url; malformed HTML yields whatever links could be found.
+func extractLinks(base, body string) ([]string, error) {
+	links, _, err := scanLinks(base, body, false, false)
+	return links, err
+}
+
+// pageMeta is what scanLinks finds out about a page besides its links.
+// Each field is "" if the page doesn't say.
+type pageMeta struct {
+	canonical   string // from <link rel="canonical">, resolved
+	title       string // from <title>, with white space collapsed
+	description string // from <meta name="description">
+
+	sitemaps []string // from every <link rel="sitemap">, resolved
+	assets   []string // resources the page uses, resolved; see scanLinks
+	nofollow []string // links only found in <a rel="nofollow">, resolved
+
+	// the contents of every <script type="application/ld+json">
+	// that is valid JSON
+	structured [][]byte
+}
+
+// fill sets the fields of p that m reports on.
+func (m pageMeta) fill(p *Page) {
+	p.Canonical, p.Title, p.Description = m.canonical, m.title, m.description
+	p.Sitemaps, p.Nofollow = m.sitemaps, m.nofollow
+}
+
+// scanLinks is extractLinks, also returning the page's pageMeta, with
+// metaRefresh set including the target of a
+// <meta http-equiv="refresh"> among the links, and with getForms set
+// the urls its GET forms submit to, as for FollowGetForms. The first
+// of each tag found is used, but for sitemaps, of which there may be
+// several. The assets are the sources of <img>, <script>, <source>,
+// <video>, <audio> and <iframe> tags, including srcset candidates,
+// the stylesheets and icons of <link> tags, and the url()s in
+// <style> tags and style attributes. JSON-LD scripts that aren't
+// valid JSON are left out of the structured data. A link is nofollow
+// if every anchor to it has rel="nofollow".
+func scanLinks(base, body string, metaRefresh, getForms bool) (links []string, meta pageMeta, err error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil, meta, err
+	}
+	resolve := func(href string) (string, bool) {
+		href = strings.TrimSpace(href)
+		if href == "" || strings.HasPrefix(href, "#") {
+			return "", false
+		}
+		u, err := b.Parse(href)
+		if err != nil || skippedSchemes[strings.ToLower(u.Scheme)] {
+			return "", false
+		}
+		return u.String(), true
+	}
+
+	asset := func(href string) {
+		// data: urls are inline, with nothing to fetch
+		if u, ok := resolve(href); ok && !strings.HasPrefix(u, "data:") {
+			meta.assets = append(meta.assets, u)
+		}
+	}
+
+	followed := make(map[string]bool) // by an anchor without nofollow
+	var nofollow []string
+
+	var form *getForm // the GET form the tags are in, if getForms
+	submit := func() {
+		if u, ok := resolve(form.action); ok {
+			links = append(links, form.url(u))
+		}
+		form = nil
+	}
+
+	scanTags(body, func(tag htmlTag) {
+		if getForms && form != nil {
+			if tag.end && tag.name == "form" {
+				submit()
+				return
+			}
+			if !tag.end {
+				form.field(tag)
+			}
+		}
+		if tag.end {
+			return
+		}
+		for _, u := range cssURLs(tag.attrs["style"]) {
+			asset(u)
+		}
+		switch tag.name {
+		case "img", "script", "source", "video", "audio", "iframe":
+			asset(tag.attrs["src"])
+			for _, u := range srcsetURLs(tag.attrs["srcset"]) {
+				asset(u)
+			}
+			if tag.name == "script" && isJSONLD(tag.attrs["type"]) {
+				if b := []byte(strings.TrimSpace(tag.text)); json.Valid(b) {
+					meta.structured = append(meta.structured, b)
+				}
+			}
+		case "style":
+			for _, u := range cssURLs(tag.text) {
+				asset(u)
+			}
+		case "a":
+			if u, ok := resolve(tag.attrs["href"]); ok {
+				links = append(links, u)
+				if hasToken(tag.attrs["rel"], "nofollow") {
+					nofollow = append(nofollow, u)
+				} else {
+					followed[u] = true
+				}
+			}
+		case "form":
+			method := strings.TrimSpace(tag.attrs["method"])
+			if getForms && form == nil && (method == "" || strings.EqualFold(method, "get")) {
+				// with no action a form submits to its own page
+				form = &getForm{action: cmp.Or(strings.TrimSpace(tag.attrs["action"]), base)}
+			}
+		case "link":
+			if meta.canonical == "" && hasToken(tag.attrs["rel"], "canonical") {
+				meta.canonical, _ = resolve(tag.attrs["href"])
+			}
+			if hasToken(tag.attrs["rel"], "sitemap") {
+				if u, ok := resolve(tag.attrs["href"]); ok {
+					meta.sitemaps = append(meta.sitemaps, u)
+				}
+			}
+			rel := tag.attrs["rel"]
+			if hasToken(rel, "stylesheet") || hasToken(rel, "icon") {
+				asset(tag.attrs["href"])
+			}
+		case "title":
+			if meta.title == "" {
+				meta.title = strings.Join(strings.Fields(html.UnescapeString(tag.text)), " ")
+			}
+		case "meta":
+			if meta.description == "" && strings.EqualFold(tag.attrs["name"], "description") {
+				meta.description = strings.TrimSpace(tag.attrs["content"])
+			}
+			if metaRefresh && strings.EqualFold(tag.attrs["http-equiv"], "refresh") {
+				if u, ok := resolve(refreshURL(tag.attrs["content"])); ok {
+					links = append(links, u)
+				}
+			}
+		}
+	})
+	if form != nil {
+		submit() // the form was never closed
+	}
+	for _, u := range nofollow {
+		if !followed[u] && !slices.Contains(meta.nofollow, u) {
+			meta.nofollow = append(meta.nofollow, u)
+		}
+	}
+	return links, meta, nil
+}
+
+// getForm is a GET form being read by scanLinks.
+type getForm struct {
+	action string   // unresolved
+	query  []string // name=value pairs of the fields, escaped, in order
+}
+
+// field adds the default value of the field tag, if it is one that a
+// form submitted untouched sends.
+func (f *getForm) field(tag htmlTag) {
+	name, ok := tag.attrs["name"]
+	if !ok || name == "" {
+		return
+	}
+	value := tag.attrs["value"]
+	switch tag.name {
+	case "input":
+		switch strings.ToLower(tag.attrs["type"]) {
+		case "submit", "image", "reset", "button", "file":
+			return
+		case "checkbox", "radio":
+			if _, checked := tag.attrs["checked"]; !checked {
+				return
+			}
+			if _, ok := tag.attrs["value"]; !ok {
+				value = "on"
+			}
+		}
+	case "textarea":
+		value = html.UnescapeString(tag.text)
+	default:
+		return
+	}
+	f.query = append(f.query, url.QueryEscape(name)+"="+url.QueryEscape(value))
+}
+
+// url returns the url the form submits to, given its resolved action.
+func (f *getForm) url(action string) string {
+	u, err := url.Parse(action)
+	if err != nil {
+		return action
+	}
+	// the fields replace any query of the action
+	u.RawQuery, u.Fragment = strings.Join(f.query, "&"), ""
+	return u.String()
+}
+
+// cssURLRegexp matches a url() in CSS, with the url in its first
+// submatch if in double quotes, its second if in single quotes and
+// its third if unquoted.
+var cssURLRegexp = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+
+// cssURLs returns the urls of the url()s in the CSS css, unresolved.
+func cssURLs(css string) []string {
+	if !strings.Contains(css, "(") {
+		return nil
+	}
+	var urls []string
+	for _, m := range cssURLRegexp.FindAllStringSubmatch(css, -1) {
+		if u := m[1] + m[2] + m[3]; u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// srcsetURLs returns the urls of the candidates in a srcset, such as
+// "small.jpg 480w, large.jpg 1080w", unresolved.
+func srcsetURLs(srcset string) []string {
+	var urls []string
+	for _, c := range strings.Split(srcset, ",") {
+		if f := strings.Fields(c); len(f) > 0 {
+			urls = append(urls, f[0])
+		}
+	}
+	return urls
+}
+
+// isJSONLD reports whether the type attribute of a <script> is that
+// of JSON-LD structured data.
+func isJSONLD(typ string) bool {
+	mediatype, _, err := mime.ParseMediaType(typ)
+	return err == nil && mediatype == "application/ld+json"
+}
+
+// hasToken reports whether the space-separated list s, such as a rel
+// attribute, holds token, ignoring case.
+func hasToken(s, token string) bool {
+	for _, t := range strings.Fields(s) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshURL returns the url in the content of a meta refresh, such as
+// "5; url='/next'", or "" if it only gives a delay.
+func refreshURL(content string) string {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return ""
+	}
+	s := strings.TrimSpace(content[i+1:])
+	if len(s) >= 3 && strings.EqualFold(s[:3], "url") {
+		if rest := strings.TrimSpace(s[3:]); strings.HasPrefix(rest, "=") {
+			s = strings.TrimSpace(rest[1:])
+		}
+	}
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+	return s
+}
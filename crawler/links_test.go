@@ -0,0 +1,268 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	const base = "http://example.com/dir/page"
+	tests := []struct {
+		name, body string
+		want       []string
+	}{
+		{"plain", `<a href="/a">a</a>`, []string{"http://example.com/a"}},
+		{"attribute order", `<a class="x" id=y href="/a" title='t'>a</a>`, []string{"http://example.com/a"}},
+		{"unquoted", `<a href=/a>a</a>`, []string{"http://example.com/a"}},
+		{"single quotes", `<a href='b'>b</a>`, []string{"http://example.com/dir/b"}},
+		{"upper case", `<A HREF="/a">a</A>`, []string{"http://example.com/a"}},
+		{"protocol-relative", `<a href="//cdn.example.com/x">`, []string{"http://cdn.example.com/x"}},
+		{"dot segments", `<a href="./a"><a href="../b"><a href="../../../c">`,
+			[]string{"http://example.com/dir/a", "http://example.com/b", "http://example.com/c"}},
+		{"nested tags", `<div><p><a href="/a"><span><img src="i.png"></span></a></p></div>`, []string{"http://example.com/a"}},
+		{"nested links", `<ul><li><a href="/a">a</a><ul><li><a href="/b">b</a></li></ul></li></ul>`,
+			[]string{"http://example.com/a", "http://example.com/b"}},
+		{"href in text", `<p>href="/not"</p><a title="href=/no" href="/a">`, []string{"http://example.com/a"}},
+		{"comment", `<!-- <a href="/hidden"> --><a href="/a">`, []string{"http://example.com/a"}},
+		{"no href", `<a name="top">top</a><abbr href="/no">`, nil},
+		{"skipped", `<a href="#top"><a href="mailto:a@example.com"><a href="javascript:void(0)">`, nil},
+		{"unclosed", `<a href="/a">a<a href="/b`, []string{"http://example.com/a"}},
+	}
+	for _, tt := range tests {
+		got, err := extractLinks(base, tt.body)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: extractLinks(%q) = %q, want %q", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestScanLinksRefreshCanonical(t *testing.T) {
+	const base = "http://example.com/dir/page"
+	tests := []struct {
+		name, body string
+		links      []string // with metaRefresh
+		canonical  string
+	}{
+		{"refresh", `<meta http-equiv="refresh" content="0;url=/next">`, []string{"http://example.com/next"}, ""},
+		{"refresh quoted", `<META HTTP-EQUIV="Refresh" CONTENT="5; URL='next'">`, []string{"http://example.com/dir/next"}, ""},
+		{"refresh comma", `<meta http-equiv="refresh" content="3, url=http://example.org/">`, []string{"http://example.org/"}, ""},
+		{"refresh delay only", `<meta http-equiv="refresh" content="30">`, nil, ""},
+		{"canonical", `<link rel="canonical" href="/dir/page?lang=en">`, nil, "http://example.com/dir/page?lang=en"},
+		{"first canonical", `<link rel="alternate canonical" href="/a"><link rel="canonical" href="/b">`, nil, "http://example.com/a"},
+		{"both", `<head><link rel=canonical href="http://example.com/new"><meta http-equiv=refresh content="0; url=/new"></head><a href="/a">`,
+			[]string{"http://example.com/new", "http://example.com/a"}, "http://example.com/new"},
+		{"neither", `<link rel="stylesheet" href="/s.css"><meta name="refresh" content="0;url=/no">`, nil, ""},
+	}
+	for _, tt := range tests {
+		links, meta, err := scanLinks(base, tt.body, true, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(links, tt.links) || meta.canonical != tt.canonical {
+			t.Errorf("%s: scanLinks(%q) = %q, canonical %q; want %q, %q", tt.name, tt.body, links, meta.canonical, tt.links, tt.canonical)
+		}
+		// refresh targets are only links if asked for
+		links, _, _ = scanLinks(base, tt.body, false, false)
+		if want, _ := extractLinks(base, tt.body); !slices.Equal(links, want) {
+			t.Errorf("%s: scanLinks(%q) without metaRefresh = %q, want %q", tt.name, tt.body, links, want)
+		}
+	}
+}
+
+func TestScanLinksAssets(t *testing.T) {
+	const base = "http://example.com/dir/page"
+	tests := []struct {
+		name, body string
+		want       []string
+	}{
+		{"img", `<img src="logo.png" alt="">`, []string{"http://example.com/dir/logo.png"}},
+		{"css url", `<style>body { background: url("/bg.jpg") } .a { background: URL( 'a.png' ) }</style>`,
+			[]string{"http://example.com/bg.jpg", "http://example.com/dir/a.png"}},
+		{"style attribute", `<div style="background-image: url(/hero.webp)">`, []string{"http://example.com/hero.webp"}},
+		{"srcset", `<img src="s.jpg" srcset="s.jpg 480w, /l.jpg 1080w">`,
+			[]string{"http://example.com/dir/s.jpg", "http://example.com/dir/s.jpg", "http://example.com/l.jpg"}},
+		{"scripts and media", `<script src="/app.js"></script><video src="v.mp4"><source src="v.webm"></video><iframe src="/f">`,
+			[]string{"http://example.com/app.js", "http://example.com/dir/v.mp4", "http://example.com/dir/v.webm", "http://example.com/f"}},
+		{"link tags", `<link rel="stylesheet" href="/s.css"><link rel="icon" href="/favicon.ico"><link rel="next" href="/2">`,
+			[]string{"http://example.com/s.css", "http://example.com/favicon.ico"}},
+		{"skipped", `<img src="data:image/png;base64,AAAA"><img src=""><style>a { color: red }</style>`, nil},
+		{"anchors aren't assets", `<a href="/a"><img src="/i.png"></a>`, []string{"http://example.com/i.png"}},
+	}
+	for _, tt := range tests {
+		links, meta, err := scanLinks(base, tt.body, false, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(meta.assets, tt.want) {
+			t.Errorf("%s: scanLinks(%q) assets %q, want %q", tt.name, tt.body, meta.assets, tt.want)
+		}
+		for _, l := range links {
+			if slices.Contains(meta.assets, l) {
+				t.Errorf("%s: %s is both a link and an asset", tt.name, l)
+			}
+		}
+	}
+}
+
+func TestScanLinksTitleDescription(t *testing.T) {
+	tests := []struct {
+		name, body         string
+		title, description string
+	}{
+		{"both", `<html><head><title>Home</title><meta name="description" content="The home page"></head></html>`,
+			"Home", "The home page"},
+		{"neither", `<html><body><a href="/a">a</a></body></html>`, "", ""},
+		{"title only", `<title>Only</title>`, "Only", ""},
+		{"description only", `<META NAME="Description" CONTENT="  spaced  ">`, "", "spaced"},
+		{"white space and entities", "<title>\n  Fish &amp;\tchips \n</title>", "Fish & chips", ""},
+		{"first of each", `<title>One</title><title>Two</title><meta name=description content=a><meta name=description content=b>`,
+			"One", "a"},
+		{"other meta", `<meta name="keywords" content="k"><meta property="og:description" content="og">`, "", ""},
+		{"unclosed title", `<title>Home`, "Home", ""},
+	}
+	for _, tt := range tests {
+		_, meta, err := scanLinks("http://example.com/", tt.body, false, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if meta.title != tt.title || meta.description != tt.description {
+			t.Errorf("%s: scanLinks(%q) title %q, description %q; want %q, %q",
+				tt.name, tt.body, meta.title, meta.description, tt.title, tt.description)
+		}
+	}
+}
+
+func TestScanLinksGetForms(t *testing.T) {
+	const base = "http://example.com/dir/page"
+	tests := []struct {
+		name, body string
+		want       []string // links, with forms
+	}{
+		{"get", `<form method="get" action="/search"><input name="q" value="go"><input type="hidden" name="lang" value="en"></form>`,
+			[]string{"http://example.com/search?q=go&lang=en"}},
+		{"the default method, relative", `<form action="find"><input name="q"></form>`, []string{"http://example.com/dir/find?q="}},
+		{"no action", `<form><input name="p" value="2"></form>`, []string{"http://example.com/dir/page?p=2"}},
+		{"post", `<form method="POST" action="/submit"><input name="q" value="go"></form>`, nil},
+		{"GET in capitals, escaped", `<form method="GET" action="/s?old=1#top"><input name="a b" value="x&y"></form>`,
+			[]string{"http://example.com/s?a+b=x%26y"}},
+		{"fields a submission sends", `<form action="/s">
+<input type="checkbox" name="c1" checked><input type="checkbox" name="c2" value="no">
+<input type="radio" name="r" value="1"><input type="radio" name="r" value="2" checked>
+<input type="submit" name="go" value="Go"><input name="" value="nameless">
+<textarea name="t">a &amp; b</textarea><select name="s"><option value="o" selected></select>
+</form>`, []string{"http://example.com/s?c1=on&r=2&t=a+%26+b"}},
+		{"with links", `<a href="/a">a</a><form action="/s"><input name="q"></form><a href="/b">b</a>`,
+			[]string{"http://example.com/a", "http://example.com/s?q=", "http://example.com/b"}},
+	}
+	for _, tt := range tests {
+		links, _, err := scanLinks(base, tt.body, false, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(links, tt.want) {
+			t.Errorf("%s: scanLinks(%q) = %q, want %q", tt.name, tt.body, links, tt.want)
+		}
+		// none without getForms
+		links, _, _ = scanLinks(base, tt.body, false, false)
+		for _, l := range links {
+			if !strings.HasPrefix(l, "http://example.com/a") && !strings.HasPrefix(l, "http://example.com/b") {
+				t.Errorf("%s: %s found with forms off", tt.name, l)
+			}
+		}
+	}
+}
+
+func TestScanLinksNofollow(t *testing.T) {
+	const base = "http://example.com/dir/"
+	tests := []struct {
+		name, body string
+		nofollow   []string
+	}{
+		{"none", `<a href="/a">a</a> <a rel="noopener" href="/b">b</a>`, nil},
+		{"nofollow", `<a href="/a">a</a> <a rel="nofollow" href="/b">b</a>`, []string{"http://example.com/b"}},
+		{"among other tokens, in capitals", `<a rel="noopener NOFOLLOW" href="c">c</a>`, []string{"http://example.com/dir/c"}},
+		{"followed elsewhere", `<a rel="nofollow" href="/a">a</a> <a href="/a">a again</a>`, nil},
+		{"twice", `<a rel="nofollow" href="/b">b</a> <a rel="nofollow" href="/b">b again</a>`, []string{"http://example.com/b"}},
+		{"not a token", `<a rel="nofollowing" href="/b">b</a>`, nil},
+	}
+	for _, tt := range tests {
+		links, meta, err := scanLinks(base, tt.body, false, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(meta.nofollow, tt.nofollow) {
+			t.Errorf("%s: nofollow %q, want %q", tt.name, meta.nofollow, tt.nofollow)
+		}
+		// nofollow links are links all the same
+		for _, u := range meta.nofollow {
+			if !slices.Contains(links, u) {
+				t.Errorf("%s: nofollow %s not among the links %q", tt.name, u, links)
+			}
+		}
+	}
+}
+
+func TestScanLinksStructuredData(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       []string
+	}{
+		{"valid and invalid", `<script type="application/ld+json">{"@type": "Article", "name": "a"}</script>
+<script type="application/ld+json">{"@type": "Article", "name": </script>`,
+			[]string{`{"@type": "Article", "name": "a"}`}},
+		{"none", `<script>var x = {"a": 1}</script><script type="application/json">{"a": 1}</script>`, nil},
+		{"type parameters and case, trimmed", "<SCRIPT TYPE=\"Application/LD+JSON; charset=utf-8\">\n [1, 2]\n</SCRIPT>",
+			[]string{"[1, 2]"}},
+		{"several", `<script type="application/ld+json">{"a": 1}</script><p>text</p><script type="application/ld+json">[{"b": 2}]</script>`,
+			[]string{`{"a": 1}`, `[{"b": 2}]`}},
+		{"empty", `<script type="application/ld+json"></script>`, nil},
+	}
+	for _, tt := range tests {
+		_, meta, err := scanLinks("http://example.com/", tt.body, false, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, b := range meta.structured {
+			got = append(got, string(b))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: scanLinks(%q) structured data %q, want %q", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+// FuzzExtractLinks checks that the links extractLinks finds on a
+// page at an absolute url are all absolute urls.
+func FuzzExtractLinks(f *testing.F) {
+	f.Add("http://example.com/dir/page", `<a href="../x">x</a> <a href="//cdn.example.com/y">`)
+	f.Add("https://example.com/", `<a href="mailto:a@b"><a href="#top"><a href=" /z ">`)
+	f.Add("http://example.com/", `<a href="http://[::1">`)
+	f.Fuzz(func(t *testing.T, base, body string) {
+		if b, err := url.Parse(base); err != nil || !b.IsAbs() {
+			return
+		}
+		links, err := extractLinks(base, body)
+		if err != nil {
+			t.Fatalf("extractLinks(%q, %q): %v", base, body, err)
+		}
+		for _, l := range links {
+			u, err := url.Parse(l)
+			if err != nil || !u.IsAbs() {
+				t.Fatalf("extractLinks(%q, %q) gave %q, which is not an absolute url", base, body, l)
+			}
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// CrawlMetrics receives a report of every fetch a crawl makes, for
+// feeding a monitoring system such as Prometheus, for which the
+// crawlprom package has one. Each fetch started ends with exactly one
+// call of FetchSkipped or FetchDone. Pages taken from the BodyCache
+// aren't fetched, and aren't reported. The methods may be called from
+// many goroutines at once.
+type CrawlMetrics interface {
+	// FetchStarted is called as a fetch of a page on host starts,
+	// once the host limits let it.
+	FetchStarted(host string)
+
+	// FetchSkipped is called when the Fetcher skipped the page,
+	// as a RobotsFetcher does the pages robots.txt disallows.
+	FetchSkipped(host string)
+
+	// FetchDone is called when a fetch has finished, with the HTTP
+	// status of the response, or 0 if none is known, how long the
+	// fetch took, and its error, nil if the page was fetched.
+	FetchDone(host string, status int, elapsed time.Duration, err error)
+}
+
+// reportFetch reports a fetch of a page on host that took elapsed and
+// returned page and err to m.
+func reportFetch(m CrawlMetrics, host string, page *Page, elapsed time.Duration, err error) {
+	if isSkip(err) {
+		m.FetchSkipped(host)
+		return
+	}
+	status := 0
+	var se *StatusError
+	if errors.As(err, &se) {
+		status = se.StatusCode
+	} else if page != nil {
+		status = page.StatusCode
+	}
+	m.FetchDone(host, status, elapsed, err)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/colindr/gotests/crawler/crawlprom"
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		seed    string
+		graph   map[string][]string
+		errs    map[string]error
+		workers int
+		want    string // the counters and gauge
+	}{
+		// the fake's not found has no HTTP status
+		{"rawData", "https://golang.org/", rawDataGraph(), nil, 4, `
+crawler_pages_fetched_total 4
+crawler_fetch_errors_total{host="golang.org",status="0"} 1
+crawler_fetches_in_flight 0
+`},
+		{"concurrent", "http://example.com/p0", syntheticGraph(100, 4), map[string]error{
+			"http://example.com/p7":  &StatusError{URL: "http://example.com/p7", StatusCode: 503},
+			"http://example.com/p8":  &StatusError{URL: "http://example.com/p8", StatusCode: 503},
+			"http://example.com/p42": &StatusError{URL: "http://example.com/p42", StatusCode: 404},
+		}, 16, `
+crawler_pages_fetched_total 97
+crawler_fetch_errors_total{host="example.com",status="404"} 1
+crawler_fetch_errors_total{host="example.com",status="503"} 2
+crawler_fetches_in_flight 0
+`},
+	}
+	for _, tt := range tests {
+		reg := prometheus.NewPedanticRegistry()
+		m, err := crawlprom.New(reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := crawltest.NewFakeFetcher(tt.graph)
+		for u, err := range tt.errs {
+			f.SetError(u, err)
+		}
+		results, err := Crawl(context.Background(), tt.seed, CrawlConfig{
+			Fetcher: f, Depth: Unlimited, MaxWorkers: tt.workers, Metrics: m,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range results {
+		}
+
+		want := `
+# HELP crawler_pages_fetched_total Pages fetched successfully.
+# TYPE crawler_pages_fetched_total counter
+# HELP crawler_fetch_errors_total Fetches that failed, by host and HTTP status, 0 if none.
+# TYPE crawler_fetch_errors_total counter
+# HELP crawler_fetches_in_flight Fetches in progress.
+# TYPE crawler_fetches_in_flight gauge
+` + tt.want
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(want),
+			"crawler_pages_fetched_total", "crawler_fetch_errors_total", "crawler_fetches_in_flight"); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if n := testutil.CollectAndCount(reg, "crawler_fetch_duration_seconds"); n != 1 {
+			t.Errorf("%s: %d fetch duration histograms, want 1", tt.name, n)
+		}
+		if problems, err := testutil.GatherAndLint(reg); err != nil || len(problems) > 0 {
+			t.Errorf("%s: lint: %v, %v", tt.name, problems, err)
+		}
+	}
+}
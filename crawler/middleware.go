@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// FetcherMiddleware wraps a Fetcher in another, such as a
+// RetryFetcher, that adds to what it does.
+type FetcherMiddleware func(Fetcher) Fetcher
+
+// Chain returns base wrapped in each of middlewares in turn: the
+// first wraps base, the second wraps that, and so on, so the last is
+// outermost and sees each fetch first. For example
+//
+//	Chain(NewHTTPFetcher(nil), RateLimit(time.Second), Retry(3, time.Second), Timeout(10*time.Second))
+//
+// gives each url ten seconds in all, across every retry, and spaces
+// out each try, retries included, by a second per host.
+func Chain(base Fetcher, middlewares ...FetcherMiddleware) Fetcher {
+	f := base
+	for _, m := range middlewares {
+		f = m(f)
+	}
+	return f
+}
+
+// RateLimit returns a FetcherMiddleware that wraps a fetcher with
+// NewRateLimitFetcher.
+func RateLimit(interval time.Duration) FetcherMiddleware {
+	return func(f Fetcher) Fetcher {
+		return NewRateLimitFetcher(f, interval)
+	}
+}
+
+// AdaptiveRateLimit returns a FetcherMiddleware that wraps a fetcher
+// with NewAdaptiveRateLimitFetcher.
+func AdaptiveRateLimit(min, max time.Duration) FetcherMiddleware {
+	return func(f Fetcher) Fetcher {
+		return NewAdaptiveRateLimitFetcher(f, min, max)
+	}
+}
+
+// Retry returns a FetcherMiddleware that wraps a fetcher with
+// NewRetryFetcher.
+func Retry(attempts int, base time.Duration) FetcherMiddleware {
+	return func(f Fetcher) Fetcher {
+		return NewRetryFetcher(f, attempts, base)
+	}
+}
+
+// Timeout returns a FetcherMiddleware that wraps a fetcher with
+// NewTimeoutFetcher.
+func Timeout(timeout time.Duration) FetcherMiddleware {
+	return func(f Fetcher) Fetcher {
+		return NewTimeoutFetcher(f, timeout)
+	}
+}
+
+// CacheDir returns a FetcherMiddleware that wraps a fetcher with
+// NewCacheDirFetcher.
+func CacheDir(dir string) FetcherMiddleware {
+	return func(f Fetcher) Fetcher {
+		return NewCacheDirFetcher(f, dir)
+	}
+}
+
+// Robots returns a FetcherMiddleware that wraps a fetcher with
+// NewRobotsFetcher.
+func Robots(client *http.Client, userAgent string) FetcherMiddleware {
+	return func(f Fetcher) Fetcher {
+		return NewRobotsFetcher(f, client, userAgent)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// logFetcher logs each fetch as it enters and leaves.
+type logFetcher struct {
+	Fetcher
+	name string
+	log  *[]string
+}
+
+func (f logFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	*f.log = append(*f.log, f.name+" enter")
+	defer func() { *f.log = append(*f.log, f.name+" exit") }()
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func logging(name string, log *[]string) FetcherMiddleware {
+	return func(f Fetcher) Fetcher { return logFetcher{f, name, log} }
+}
+
+func TestChain(t *testing.T) {
+	const u = "http://example.com/"
+	var log []string
+	base := logging("base", &log)(crawltest.NewFakeFetcher(map[string][]string{u: nil}))
+
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{nil, []string{"base enter", "base exit"}},
+		{[]string{"a"}, []string{"a enter", "base enter", "base exit", "a exit"}},
+		// the last is outermost
+		{[]string{"a", "b", "c"}, []string{
+			"c enter", "b enter", "a enter", "base enter",
+			"base exit", "a exit", "b exit", "c exit",
+		}},
+	}
+	for _, tt := range tests {
+		var middlewares []FetcherMiddleware
+		for _, name := range tt.names {
+			middlewares = append(middlewares, logging(name, &log))
+		}
+		log = nil
+		if _, _, err := Chain(base, middlewares...).Fetch(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(log, tt.want) {
+			t.Errorf("Chain of %q logged %q, want %q", tt.names, log, tt.want)
+		}
+	}
+}
+
+func TestChainWrappers(t *testing.T) {
+	fake := crawltest.NewFakeFetcher(nil)
+	f := Chain(fake, RateLimit(time.Second), Retry(3, time.Second), Timeout(10*time.Second))
+
+	var types []string
+	for f != nil {
+		types = append(types, fmt.Sprintf("%T", f))
+		w, ok := f.(wrapper)
+		if !ok {
+			break
+		}
+		f = w.wrapped()
+	}
+	want := []string{"*main.TimeoutFetcher", "*main.RetryFetcher", "*main.RateLimitFetcher", "*crawltest.FakeFetcher"}
+	if !slices.Equal(types, want) {
+		t.Errorf("Chain built %q, want %q", types, want)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// TaggedResult is a result of CrawlMulti, along with the seed whose
+// crawl it belongs to.
+type TaggedResult struct {
+	Seed   string
+	Result CrawlResult
+}
+
+// CrawlMulti crawls from each of seeds separately and at the same
+// time, as if each had been passed to Crawl with cfg, and merges
+// their results onto one channel. Unlike CrawlSeeds, the crawls don't
+// share anything but what cfg does: each has its own MaxPages, and
+// its own visited urls unless cfg.Visited is set, in which case a url
+// reached from one seed is not fetched again from another.
+//
+// The stats of each crawl are returned by seed, and are filled in
+// when the channel is closed; cfg.Stats is not used. The channel
+// is closed once every crawl has finished or ctx is cancelled. An
+// error is returned, and nothing crawled, if any crawl would fail to
+// start.
+func CrawlMulti(ctx context.Context, seeds []string, cfg CrawlConfig) (<-chan TaggedResult, map[string]*CrawlStats, error) {
+	stats := make(map[string]*CrawlStats, len(seeds))
+	var crawlers []*Crawler
+	for _, seed := range seeds {
+		if _, ok := stats[seed]; ok {
+			continue
+		}
+		stats[seed] = new(CrawlStats)
+		cfg := cfg
+		cfg.Stats = stats[seed]
+		cr, err := NewCrawler([]string{seed}, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		crawlers = append(crawlers, cr)
+	}
+	if len(crawlers) == 0 {
+		return nil, nil, errors.New("crawl: no seed urls")
+	}
+
+	out := make(chan TaggedResult)
+	var wg sync.WaitGroup
+	wg.Add(len(crawlers))
+	for _, cr := range crawlers {
+		c, _ := cr.Start(ctx) // cr is new, so Start can't fail
+		go func(seed string) {
+			defer wg.Done()
+			for r := range c {
+				select {
+				case out <- TaggedResult{seed, r}:
+				case <-ctx.Done():
+					// the crawl is stopping too; drain it
+				}
+			}
+		}(cr.seeds[0])
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, stats, nil
+}
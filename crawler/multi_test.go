@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestCrawlMulti(t *testing.T) {
+	graph := map[string][]string{
+		"http://a.example/":  {"http://a.example/1", "http://a.example/2"},
+		"http://a.example/1": {"http://a.example/2"},
+		"http://a.example/2": nil,
+		"http://b.example/":  {"http://b.example/gone"},
+	}
+	seeds := []string{"http://a.example/", "http://b.example/"}
+	results, stats, err := CrawlMulti(context.Background(), seeds, CrawlConfig{
+		Fetcher: crawltest.NewFakeFetcher(graph),
+		Depth:   Unlimited,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged := make(map[string]int)
+	for tr := range results {
+		if !strings.HasPrefix(tr.Result.URL, tr.Seed) {
+			t.Errorf("%s tagged with seed %s", tr.Result.URL, tr.Seed)
+		}
+		tagged[tr.Seed]++
+	}
+
+	tests := []struct {
+		seed                     string
+		results, fetched, failed int
+	}{
+		{"http://a.example/", 3, 3, 0},
+		{"http://b.example/", 2, 1, 1},
+	}
+	for _, tt := range tests {
+		s := stats[tt.seed]
+		if tagged[tt.seed] != tt.results || s == nil || s.PagesFetched != tt.fetched || s.PagesFailed != tt.failed {
+			t.Errorf("%s: %d results, stats %+v; want %d results, %d fetched and %d failed",
+				tt.seed, tagged[tt.seed], s, tt.results, tt.fetched, tt.failed)
+		}
+	}
+}
+
+func TestCrawlMultiSharedVisited(t *testing.T) {
+	graph := rawDataGraph()
+	for _, shared := range []bool{false, true} {
+		f := crawltest.NewFakeFetcher(graph)
+		cfg := CrawlConfig{Fetcher: f, Depth: Unlimited}
+		if shared {
+			cfg.Visited = new(MemoryVisitedSet)
+		}
+		results, _, err := CrawlMulti(context.Background(), []string{"https://golang.org/", "https://golang.org/pkg/"}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range results {
+		}
+		// each crawl reaches every page, unless they share them
+		want := 8
+		if shared {
+			want = 4
+		}
+		if n := totalFetches(f, graph); n != want {
+			t.Errorf("shared %v: %d fetches, want %d", shared, n, want)
+		}
+	}
+}
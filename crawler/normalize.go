@@ -0,0 +1,281 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// DefaultPorts maps lower case schemes to the port they use when none
+// is given, which NormalizeURL drops. Schemes may be added for a
+// crawl that follows others, but not while it runs.
+var DefaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+// NormalizeURL returns a canonical form of the absolute url raw, so
+// that urls naming the same page compare equal. It lowercases the
+// scheme and host, drops the port where it is the scheme's default,
+// as listed in DefaultPorts, and drops the fragment, resolves "." and
+// ".." path segments, removes any trailing slash from the path and
+// makes ports and percent-encoding consistent.
+//
+// The result is meant for comparing urls, not for fetching them:
+// servers are free to treat "/pkg" and "/pkg/" differently.
+func NormalizeURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if !u.IsAbs() || u.Hostname() == "" {
+		return "", fmt.Errorf("normalize %q: not an absolute url", raw)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if p := strings.TrimLeft(port, "0"); p != port {
+		port = cmp.Or(p, "0") // the same port as written
+	}
+	if port == DefaultPorts[u.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		// an IPv6 literal
+		if _, err := netip.ParseAddr(host); err != nil {
+			return "", fmt.Errorf("normalize %q: invalid host %q", raw, host)
+		}
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	// Hostname unescaped the host, and an IPv6 zone, which url.URL
+	// escapes again
+	u.Host = strings.TrimPrefix((&url.URL{Host: host}).String(), "//")
+
+	p := normalizePercent(u.EscapedPath())
+	if p != "" {
+		p = path.Clean(p)
+	}
+	if p == "" || p == "." {
+		p = "/"
+	}
+
+	// build the result by hand, since u.String would re-escape p
+	var b strings.Builder
+	b.Grow(len(raw))
+	b.WriteString(u.Scheme)
+	b.WriteString("://")
+	if u.User != nil {
+		b.WriteString(u.User.String())
+		b.WriteByte('@')
+	}
+	b.WriteString(u.Host)
+	b.WriteString(p)
+	if u.RawQuery != "" {
+		b.WriteByte('?')
+		b.WriteString(normalizePercent(u.RawQuery))
+	}
+	return b.String(), nil
+}
+
+// ResolveURL returns ref, a link found on the page at the absolute url
+// base, as the absolute url it leads to, as a browser would: a
+// protocol-relative ref such as //cdn.example.com/x takes the scheme
+// of base, a ref starting with / replaces its path, and other
+// relative refs, such as x, ./x or ../x, are resolved against its
+// directory, ".." never going above the root. An absolute ref is
+// returned as it is. The result is not normalized.
+func ResolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	if !b.IsAbs() {
+		return "", fmt.Errorf("resolve %q: base %q is not an absolute url", ref, base)
+	}
+	u, err := b.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// resolveLinks returns urls, the links found on the page at base, with
+// any that are relative resolved by ResolveURL, for fetchers that
+// don't resolve links themselves. Links that can't be resolved are
+// left as they are. urls itself is returned if every link has a
+// scheme already.
+func resolveLinks(base string, urls []string) []string {
+	var out []string
+	for i, u := range urls {
+		if schemeOf(u) != "" {
+			if out != nil {
+				out = append(out, u)
+			}
+			continue
+		}
+		if out == nil {
+			out = append(make([]string, 0, len(urls)), urls[:i]...)
+		}
+		if abs, err := ResolveURL(base, u); err == nil {
+			u = abs
+		}
+		out = append(out, u)
+	}
+	if out == nil {
+		return urls
+	}
+	return out
+}
+
+// normalizePercent rewrites the percent-escapes in s so that equal
+// strings are encoded identically: escapes of unreserved characters
+// (RFC 3986 section 2.3) are decoded and all others use upper case
+// hex digits.
+func normalizePercent(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// NormalizedKey is the default DedupKey of a crawl: url's
+// NormalizeURL form, or url itself if it can't be normalized.
+func NormalizedKey(url string) string {
+	if n, err := NormalizeURL(url); err == nil {
+		return n
+	}
+	return url
+}
+
+// PathKey is a DedupKey that tells urls apart by their normalized
+// scheme, host and path alone, so that urls differing only in their
+// query strings are crawled once. It is the key IgnoreQueryInDedup
+// selects.
+func PathKey(url string) string {
+	key, _, _ := strings.Cut(NormalizedKey(url), "?")
+	return key
+}
+
+// IndexFiles are the file names CollapseIndexFiles takes to be a
+// directory's own page, as a web server or FileFetcher serves it.
+var IndexFiles = []string{"index.html", "index.htm", "default.html"}
+
+// withoutIndexFile returns the dedup key key with any of IndexFiles
+// dropped from the end of its path, and then any trailing slash, so
+// that /dir/index.html, /dir/ and /dir share a key. It works on keys
+// that aren't normalized, such as file urls.
+func withoutIndexFile(key string) string {
+	base, rest := key, ""
+	if i := strings.IndexAny(key, "?#"); i >= 0 {
+		base, rest = key[:i], key[i:]
+	}
+	host := strings.Index(base, "://") + len("://")
+	if i := strings.LastIndexByte(base, '/'); i >= host && slices.Contains(IndexFiles, base[i+1:]) {
+		base = base[:i+1]
+	}
+	return strings.TrimSuffix(base, "/") + rest
+}
+
+// repeatedSegments returns the most times any one segment of the
+// path of rawurl appears in a row, so 3 for /a/b/b/b/c, or 0 if rawurl
+// doesn't parse or has no path.
+func repeatedSegments(rawurl string) int {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0
+	}
+	most, run, last := 0, 0, ""
+	for _, seg := range strings.Split(strings.Trim(u.EscapedPath(), "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		if seg == last {
+			run++
+		} else {
+			run, last = 1, seg
+		}
+		most = max(most, run)
+	}
+	return most
+}
+
+// pathSegments returns how many segments the path of rawurl has, so 3
+// for /a/b/c/, or 0 if rawurl doesn't parse. Empty segments, as in
+// /a//b, don't count.
+func pathSegments(rawurl string) int {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, seg := range strings.Split(u.EscapedPath(), "/") {
+		if seg != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// keyedURL is a url along with its key in a VisitedSet.
+type keyedURL struct {
+	url, key string
+}
+
+// uniqueURLs returns urls with their keys as given by keyOf, without
+// the ones that have the same key as one before them.
+func uniqueURLs(urls []string, keyOf func(string) string) []keyedURL {
+	seen := make(map[string]bool, len(urls))
+	out := make([]keyedURL, 0, len(urls))
+	for _, u := range urls {
+		if key := keyOf(u); !seen[key] {
+			seen[key] = true
+			out = append(out, keyedURL{u, key})
+		}
+	}
+	return out
+}
@@ -0,0 +1,656 @@
+package main
+
+import (
+	"log/slog"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CrawlOption sets a field of the CrawlConfig used by CrawlInto,
+// CrawlN and CrawlErrors.
+type CrawlOption func(*CrawlConfig)
+
+// WithSameHost restricts a crawl to urls on the seed url's host.
+func WithSameHost() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.SameHostOnly = true
+	}
+}
+
+// WithPathPrefix restricts a crawl to urls within the path of a seed.
+// See CrawlConfig.PathPrefixOnly.
+func WithPathPrefix() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.PathPrefixOnly = true
+	}
+}
+
+// WithAllowedHosts restricts a crawl to urls on one of hosts. When
+// combined with WithSameHost the seed url's host is allowed too.
+func WithAllowedHosts(hosts ...string) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.AllowedHosts = append(cfg.AllowedHosts, hosts...)
+	}
+}
+
+// WithConcurrency spreads a crawl's fetches across hosts within
+// limits. See CrawlConfig.Concurrency.
+func WithConcurrency(limits ConcurrencyLimits) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Concurrency = limits
+	}
+}
+
+// WithMaxPages stops a crawl from starting new fetches once n pages
+// have been fetched. See CrawlConfig.MaxPages.
+func WithMaxPages(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxPages = n
+	}
+}
+
+// WithStableMaxPages has the page limits of a crawl pick the same
+// pages each time. See CrawlConfig.StableMaxPages.
+func WithStableMaxPages() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.StableMaxPages = true
+	}
+}
+
+// WithPerHostPageLimit stops a crawl from starting new fetches from a
+// host once n of its pages have been fetched. See
+// CrawlConfig.PerHostPageLimit.
+func WithPerHostPageLimit(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.PerHostPageLimit = n
+	}
+}
+
+// WithHostPageLimits sets how many pages may be fetched from each of
+// the hosts of limits. See CrawlConfig.HostPageLimits.
+func WithHostPageLimits(limits map[string]int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.HostPageLimits = limits
+	}
+}
+
+// WithMaxBytes stops a crawl from starting new fetches once n bytes
+// of bodies have been downloaded. See CrawlConfig.MaxBytes.
+func WithMaxBytes(n int64) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxBytes = n
+	}
+}
+
+// WithMaxQueueLength bounds how many urls may wait to be fetched.
+// See CrawlConfig.MaxQueueLength.
+func WithMaxQueueLength(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxQueueLength = n
+	}
+}
+
+// WithMaxConsecutiveErrors stops a crawl once n fetches in a row have
+// failed. See CrawlConfig.MaxConsecutiveErrors.
+func WithMaxConsecutiveErrors(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxConsecutiveErrors = n
+	}
+}
+
+// WithAbortOnError stops a crawl at the first fetch that fails. See
+// CrawlConfig.AbortOnError.
+func WithAbortOnError() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.AbortOnError = true
+	}
+}
+
+// WithStats has the crawl fill in *s when it finishes. s must not be
+// read until then: after the results channel is closed, or after the
+// WaitGroup passed to CrawlInto is done.
+func WithStats(s *CrawlStats) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Stats = s
+	}
+}
+
+// WithBufferSize gives the results channel returned by CrawlN room
+// for n results, so that fetching can run ahead of a slow consumer.
+func WithBufferSize(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.BufferSize = n
+	}
+}
+
+// WithBreadthFirst makes a crawl fetch every page at one depth before
+// any page linked from them.
+func WithBreadthFirst() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.BreadthFirst = true
+	}
+}
+
+// WithSequential makes a crawl run on one worker in a repeatable
+// order. See CrawlConfig.Sequential.
+func WithSequential() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Sequential = true
+	}
+}
+
+// WithShouldFollow has a crawl consult fn before following each url.
+// See CrawlConfig.ShouldFollow.
+func WithShouldFollow(fn func(url string, depth int) bool) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.ShouldFollow = fn
+	}
+}
+
+// WithOnLinkDiscovered has a crawl call fn for every link it finds.
+// See CrawlConfig.OnLinkDiscovered.
+func WithOnLinkDiscovered(fn func(from, to string, depth int) bool) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.OnLinkDiscovered = fn
+	}
+}
+
+// WithOnFrontier has a crawl pass the links it finds to fn rather
+// than following them. See CrawlConfig.OnFrontier.
+func WithOnFrontier(fn func(url string, depth int)) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.OnFrontier = fn
+	}
+}
+
+// WithURLRewriter has a crawl rewrite each url with fn before
+// following it. See CrawlConfig.URLRewriter.
+func WithURLRewriter(fn func(url string) string) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.URLRewriter = fn
+	}
+}
+
+// WithIgnoreQueryInDedup has a crawl treat urls differing only in
+// their query strings as one. See CrawlConfig.IgnoreQueryInDedup.
+func WithIgnoreQueryInDedup() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.IgnoreQueryInDedup = true
+	}
+}
+
+// WithCollapseIndexFiles has a crawl treat a url ending in an index
+// file, such as /dir/index.html, as its directory. See
+// CrawlConfig.CollapseIndexFiles.
+func WithCollapseIndexFiles() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.CollapseIndexFiles = true
+	}
+}
+
+// WithDedupKey has a crawl tell urls apart by the keys fn gives them.
+// See CrawlConfig.DedupKey.
+func WithDedupKey(fn func(url string) string) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.DedupKey = fn
+	}
+}
+
+// WithSoftNotFoundDetector has a crawl use detect to tell "not
+// found" pages served with a success status. See
+// CrawlConfig.SoftNotFoundDetector.
+func WithSoftNotFoundDetector(detect func(body string) bool) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.SoftNotFoundDetector = detect
+	}
+}
+
+// WithFollowSitemaps has a crawl follow the urls listed in the
+// sitemaps it finds. See CrawlConfig.FollowSitemaps.
+func WithFollowSitemaps() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.FollowSitemaps = true
+	}
+}
+
+// WithRespectNofollow has a crawl not follow the links pages mark
+// rel="nofollow". See CrawlConfig.RespectNofollow.
+func WithRespectNofollow() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.RespectNofollow = true
+	}
+}
+
+// WithIncludePatterns restricts a crawl to urls matching one of
+// patterns. See CrawlConfig.IncludePatterns.
+func WithIncludePatterns(patterns ...*regexp.Regexp) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.IncludePatterns = append(cfg.IncludePatterns, patterns...)
+	}
+}
+
+// WithExcludePatterns keeps a crawl away from urls matching any of
+// patterns. See CrawlConfig.ExcludePatterns.
+func WithExcludePatterns(patterns ...*regexp.Regexp) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.ExcludePatterns = append(cfg.ExcludePatterns, patterns...)
+	}
+}
+
+// WithMaxLinksPerPage limits a crawl to following n links from each
+// page. See CrawlConfig.MaxLinksPerPage.
+func WithMaxLinksPerPage(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxLinksPerPage = n
+	}
+}
+
+// WithMaxFanOut limits how many new links each page adds to the
+// queue to n. See CrawlConfig.MaxFanOut.
+func WithMaxFanOut(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxFanOut = n
+	}
+}
+
+// WithDeferFanOut has a crawl queue the links held back by MaxFanOut
+// once it is otherwise finished. See CrawlConfig.DeferFanOut.
+func WithDeferFanOut() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.DeferFanOut = true
+	}
+}
+
+// WithMaxURLLength keeps a crawl from following links longer than n
+// bytes. See CrawlConfig.MaxURLLength.
+func WithMaxURLLength(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxURLLength = n
+	}
+}
+
+// WithMaxRepeatedSegments keeps a crawl from following links whose
+// paths repeat a segment more than n times in a row. See
+// CrawlConfig.MaxRepeatedSegments.
+func WithMaxRepeatedSegments(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxRepeatedSegments = n
+	}
+}
+
+// WithMaxPathSegments keeps a crawl from following links whose paths
+// have more than n segments. See CrawlConfig.MaxPathSegments.
+func WithMaxPathSegments(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxPathSegments = n
+	}
+}
+
+// WithMaxDuration stops a crawl once it has run for d. See
+// CrawlConfig.MaxDuration.
+func WithMaxDuration(d time.Duration) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.MaxDuration = d
+	}
+}
+
+// WithIdleTimeout stops a crawl once no fetch has succeeded for d.
+// See CrawlConfig.IdleTimeout.
+func WithIdleTimeout(d time.Duration) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.IdleTimeout = d
+	}
+}
+
+// WithDryRun makes a crawl leave the bodies of its results empty,
+// keeping only their links. See CrawlConfig.DryRun.
+func WithDryRun() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.DryRun = true
+	}
+}
+
+// WithCountOnly makes a crawl send no results, only filling in its
+// stats.
+func WithCountOnly() CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.CountOnly = true
+	}
+}
+
+// WithSink has a crawl write its results to s rather than send them
+// on its results channel. See CrawlConfig.Sink.
+func WithSink(s ResultSink) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Sink = s
+	}
+}
+
+// WithOnFetch has a crawl call fn after each fetch. See
+// CrawlConfig.OnFetch.
+func WithOnFetch(fn func(url string, depth int, err error)) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.OnFetch = fn
+	}
+}
+
+// WithOnDuplicateLink has a crawl call fn for each link to a page
+// already visited. See CrawlConfig.OnDuplicateLink.
+func WithOnDuplicateLink(fn func(e LinkEvent)) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.OnDuplicateLink = fn
+	}
+}
+
+// WithBodyCache has a crawl take pages from c rather than fetch them
+// when it can. See CrawlConfig.BodyCache.
+func WithBodyCache(c *BodyCache) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.BodyCache = c
+	}
+}
+
+// WithSeen has a crawl skip urls, as if it had already crawled them.
+// See CrawlConfig.AlreadySeen.
+func WithSeen(urls ...string) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.AlreadySeen = append(cfg.AlreadySeen, urls...)
+	}
+}
+
+// WithLogger has a crawl log to l. See CrawlConfig.Logger.
+func WithLogger(l *slog.Logger) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Logger = l
+	}
+}
+
+// WithSchemes has a crawl follow only links with these url schemes.
+// See CrawlConfig.AllowedSchemes.
+func WithSchemes(schemes ...string) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.AllowedSchemes = append(cfg.AllowedSchemes, schemes...)
+	}
+}
+
+// WithRelativeDepth has a crawl follow links n levels below any url
+// matching its IncludePatterns, however deep. See
+// CrawlConfig.RelativeDepth.
+func WithRelativeDepth(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.RelativeDepth = n
+	}
+}
+
+// WithDelayByDepth has a crawl wait delay(depth) before fetching each
+// page found depth links from a seed. See CrawlConfig.DelayByDepth.
+func WithDelayByDepth(delay func(depth int) time.Duration) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.DelayByDepth = delay
+	}
+}
+
+// WithFollowExtensions has a crawl follow only links to urls with
+// these extensions, or none. See CrawlConfig.FollowExtensions.
+func WithFollowExtensions(exts ...string) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.FollowExtensions = append(cfg.FollowExtensions, exts...)
+	}
+}
+
+// WithSkipExtensions keeps a crawl from following links to urls with
+// these extensions. See CrawlConfig.SkipExtensions.
+func WithSkipExtensions(exts ...string) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.SkipExtensions = append(cfg.SkipExtensions, exts...)
+	}
+}
+
+// WithPriority has a crawl fetch the queued urls that priority scores
+// highest first. See CrawlConfig.Priority.
+func WithPriority(priority func(url string, depth int) int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Priority = priority
+	}
+}
+
+// WithMetrics has a crawl report its fetches to m. See
+// CrawlConfig.Metrics.
+func WithMetrics(m CrawlMetrics) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Metrics = m
+	}
+}
+
+// WithExternalLinkDepth has a crawl go n levels off its site. See
+// CrawlConfig.ExternalLinkDepth.
+func WithExternalLinkDepth(n int) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.ExternalLinkDepth = n
+	}
+}
+
+// WithVisited has a crawl record the urls it admits in v, skipping
+// those already there. See CrawlConfig.Visited.
+func WithVisited(v VisitedSet) CrawlOption {
+	return func(cfg *CrawlConfig) {
+		cfg.Visited = v
+	}
+}
+
+// crawlScope decides which urls a crawl may visit.
+type crawlScope struct {
+	schemes  map[string]bool // lower case; checked by allowsScheme
+	hosts    map[string]bool // nil allows every host
+	prefixes []string        // see hostPath; nil allows every path
+
+	// lower case, with the dot; checked by allowsExt
+	followExts, skipExts map[string]bool
+
+	include, exclude []*regexp.Regexp
+}
+
+func newCrawlScope(seeds []string, cfg CrawlConfig) crawlScope {
+	s := crawlScope{
+		schemes: make(map[string]bool),
+		include: cfg.IncludePatterns,
+		exclude: cfg.ExcludePatterns,
+	}
+	if cfg.RelativeDepth > 0 {
+		s.include = nil // they extend the depth instead
+	}
+	s.followExts, s.skipExts = extSet(cfg.FollowExtensions), extSet(cfg.SkipExtensions)
+	schemes := cfg.AllowedSchemes
+	if schemes == nil {
+		schemes = DefaultSchemes
+		for _, seed := range seeds {
+			s.schemes[schemeOf(seed)] = true
+		}
+	}
+	for _, scheme := range schemes {
+		s.schemes[strings.ToLower(scheme)] = true
+	}
+	if cfg.PathPrefixOnly {
+		for _, seed := range seeds {
+			s.prefixes = append(s.prefixes, hostPath(seed))
+		}
+	}
+	if !cfg.SameHostOnly && len(cfg.AllowedHosts) == 0 {
+		return s
+	}
+	s.hosts = make(map[string]bool)
+	if cfg.SameHostOnly {
+		// a file url's host is "", which keeps a crawl from file
+		// seeds to the local files
+		for _, seed := range seeds {
+			s.hosts[hostOf(seed)] = true
+		}
+	}
+	for _, h := range cfg.AllowedHosts {
+		s.hosts[strings.ToLower(h)] = true
+	}
+	return s
+}
+
+// inSite reports whether rawurl is on the hosts and within the paths
+// that the scope restricts it to, if any.
+func (s crawlScope) inSite(rawurl string) bool {
+	if s.hosts != nil && !s.hosts[hostOf(rawurl)] {
+		return false
+	}
+	return s.prefixes == nil || slices.ContainsFunc(s.prefixes, within(hostPath(rawurl)))
+}
+
+// allows reports whether the scope's patterns allow rawurl. Whether
+// it is in the site is up to inSite.
+func (s crawlScope) allows(rawurl string) bool {
+	if slices.ContainsFunc(s.exclude, matches(rawurl)) {
+		return false
+	}
+	return len(s.include) == 0 || slices.ContainsFunc(s.include, matches(rawurl))
+}
+
+// allowsScheme reports whether links with the scheme of rawurl may be
+// followed. Unlike allows it doesn't apply to the seeds.
+func (s crawlScope) allowsScheme(rawurl string) bool {
+	return s.schemes[schemeOf(rawurl)]
+}
+
+// allowsExt reports whether links to rawurl may be followed given the
+// extension of its path. Like allowsScheme it doesn't apply to the
+// seeds.
+func (s crawlScope) allowsExt(rawurl string) bool {
+	if s.followExts == nil && s.skipExts == nil {
+		return true
+	}
+	ext := extOf(rawurl)
+	if ext == "" {
+		return true
+	}
+	return !s.skipExts[ext] && (s.followExts == nil || s.followExts[ext])
+}
+
+// extSet returns the set of extensions exts, lower case and starting
+// with a dot, or nil if there are none.
+func extSet(exts []string) map[string]bool {
+	if len(exts) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(exts))
+	for _, ext := range exts {
+		set["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+	}
+	return set
+}
+
+// extOf returns the lower case extension, with the dot, of the last
+// segment of the path of rawurl, or "" if it has none or rawurl
+// doesn't parse.
+func extOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(path.Ext(u.Path))
+}
+
+// matches returns a function reporting whether a pattern matches s.
+func matches(s string) func(*regexp.Regexp) bool {
+	return func(re *regexp.Regexp) bool {
+		return re.MatchString(s)
+	}
+}
+
+// within returns a function reporting whether hostPath p is within
+// a prefix: the same as it, or continuing after a slash.
+func within(p string) func(prefix string) bool {
+	return func(prefix string) bool {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+}
+
+// hostPath returns the host name and path of rawurl, normalized and
+// without a trailing slash, for telling whether one url is under
+// the path of another.
+func hostPath(rawurl string) string {
+	u, err := url.Parse(NormalizedKey(rawurl))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname()) + strings.TrimSuffix(u.EscapedPath(), "/")
+}
+
+// schemeOf returns the lower case scheme of rawurl, or "" if it has
+// none. It doesn't parse the rest of the url, so that it is cheap
+// enough to call for every link.
+func schemeOf(rawurl string) string {
+	for i := 0; i < len(rawurl); i++ {
+		switch b := rawurl[i]; {
+		case 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z':
+		case '0' <= b && b <= '9' || b == '+' || b == '-' || b == '.':
+			if i == 0 {
+				return ""
+			}
+		case b == ':' && i > 0:
+			return strings.ToLower(rawurl[:i])
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// hostOf returns the lower case host name of rawurl, without any
+// port, or "" if rawurl can't be parsed.
+func hostOf(rawurl string) string {
+	if host, ok := plainHost(rawurl); ok {
+		return host
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// plainHost is hostOf for a url of the plain form most links take,
+// scheme://host[:port] and then a path without escapes, found without
+// parsing the url, which hostOf does for every link. It reports false
+// for any other url.
+func plainHost(rawurl string) (string, bool) {
+	scheme := schemeOf(rawurl)
+	rest, ok := strings.CutPrefix(rawurl[len(scheme):], "://")
+	if scheme == "" || !ok {
+		return "", false
+	}
+	for i := 0; i < len(rawurl); i++ {
+		if b := rawurl[i]; b < ' ' || b == 0x7f || b == '%' {
+			return "", false // for url.Parse to reject or unescape
+		}
+	}
+	host := rest
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host = rest[:i]
+	}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		for _, b := range []byte(host[i+1:]) {
+			if b < '0' || b > '9' {
+				return "", false
+			}
+		}
+		host = host[:i]
+	}
+	for i := 0; i < len(host); i++ {
+		switch b := host[i]; {
+		case 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9':
+		case b == '-' || b == '.' || b == '_' || b == '~':
+		default:
+			return "", false
+		}
+	}
+	return strings.ToLower(host), true
+}
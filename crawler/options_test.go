@@ -0,0 +1,686 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// offSiteGraph is golang.org, with links to example.com and its
+// www host.
+var offSiteGraph = map[string][]string{
+	"https://golang.org/":      {"https://golang.org/pkg/", "https://example.com/", "https://www.golang.org/x"},
+	"https://golang.org/pkg/":  {"https://golang.org/", "https://example.com/a"},
+	"https://example.com/":     {"https://golang.org/cmd/", "https://example.com/a"},
+	"https://example.com/a":    nil,
+	"https://golang.org/cmd/":  nil,
+	"https://www.golang.org/x": nil,
+}
+
+func TestHostRestrictions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []CrawlOption
+		want []string
+	}{
+		{"unrestricted", nil, []string{
+			"https://example.com/", "https://example.com/a", "https://golang.org/",
+			"https://golang.org/cmd/", "https://golang.org/pkg/", "https://www.golang.org/x",
+		}},
+		{"same host", []CrawlOption{WithSameHost()}, []string{
+			"https://golang.org/", "https://golang.org/pkg/",
+		}},
+		{"allowed hosts", []CrawlOption{WithAllowedHosts("golang.org", "www.golang.org")}, []string{
+			"https://golang.org/", "https://golang.org/pkg/", "https://www.golang.org/x",
+		}},
+		{"same host and allowed", []CrawlOption{WithSameHost(), WithAllowedHosts("EXAMPLE.com")}, []string{
+			"https://example.com/", "https://example.com/a", "https://golang.org/",
+			"https://golang.org/cmd/", "https://golang.org/pkg/",
+		}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(offSiteGraph)
+		pages, err := CrawlErrors(context.Background(), "https://golang.org/", 4, f, tt.opts...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, p := range pages {
+			got = append(got, p.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		for u := range offSiteGraph {
+			if !slices.Contains(tt.want, u) && f.Fetches(u) != 0 {
+				t.Errorf("%s: fetched %s", tt.name, u)
+			}
+		}
+	}
+}
+
+func TestExternalLinkDepth(t *testing.T) {
+	graph := maps.Clone(offSiteGraph)
+	graph["https://golang.org/pkg/"] = append(slices.Clone(graph["https://golang.org/pkg/"]), "https://example.org/gone")
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+		want []string // fetched, the dead link as well
+	}{
+		{"on the site", CrawlConfig{SameHostOnly: true}, []string{
+			"https://golang.org/", "https://golang.org/pkg/",
+		}},
+		// example.com/ is checked, but its links to golang.org/cmd/ and
+		// example.com/a aren't followed; pkg/ links to example.com/a too
+		{"one level off", CrawlConfig{SameHostOnly: true, ExternalLinkDepth: 1}, []string{
+			"https://example.com/", "https://example.com/a", "https://example.org/gone",
+			"https://golang.org/", "https://golang.org/pkg/", "https://www.golang.org/x",
+		}},
+		{"two levels off", CrawlConfig{SameHostOnly: true, ExternalLinkDepth: 2}, []string{
+			"https://example.com/", "https://example.com/a", "https://example.org/gone",
+			"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/", "https://www.golang.org/x",
+		}},
+		{"allowed hosts, one level off", CrawlConfig{AllowedHosts: []string{"golang.org", "www.golang.org"}, ExternalLinkDepth: 1}, []string{
+			"https://example.com/", "https://example.com/a", "https://example.org/gone",
+			"https://golang.org/", "https://golang.org/pkg/", "https://www.golang.org/x",
+		}},
+		// no deeper than Depth
+		{"within Depth", CrawlConfig{SameHostOnly: true, ExternalLinkDepth: 1, Depth: 1}, []string{
+			"https://example.com/", "https://golang.org/", "https://golang.org/pkg/", "https://www.golang.org/x",
+		}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth = f, cmp.Or(cfg.Depth, 4)
+		results, err := Crawl(context.Background(), "https://golang.org/", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, failed []string
+		for r := range results {
+			got = append(got, r.URL)
+			if r.Err != nil {
+				failed = append(failed, r.URL)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		// the dead link off the site is found
+		var wantFailed []string
+		if slices.Contains(tt.want, "https://example.org/gone") {
+			wantFailed = []string{"https://example.org/gone"}
+		}
+		if !slices.Equal(failed, wantFailed) {
+			t.Errorf("%s: %q failed, want %q", tt.name, failed, wantFailed)
+		}
+		for u := range graph {
+			if n, want := f.Fetches(u), slices.Contains(tt.want, u); n > 1 || (n == 1) != want {
+				t.Errorf("%s: fetched %s %d times", tt.name, u, n)
+			}
+		}
+	}
+}
+
+func TestPathPrefixOnly(t *testing.T) {
+	graph := rawDataGraph()
+	graph["https://golang.org/pkg/"] = append(graph["https://golang.org/pkg/"], "https://golang.org/pkgsite/")
+	graph["https://golang.org/pkgsite/"] = nil
+	tests := []struct {
+		seed string
+		want []string
+	}{
+		{"https://golang.org/pkg/", []string{
+			"https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+		{"https://golang.org/pkg/fmt/", []string{"https://golang.org/pkg/fmt/"}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		pages, err := CrawlErrors(context.Background(), tt.seed, 4, f, WithPathPrefix())
+		if err != nil {
+			t.Errorf("%s: %v", tt.seed, err)
+		}
+		var got []string
+		for _, p := range pages {
+			got = append(got, p.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.seed, got, tt.want)
+		}
+		for _, u := range []string{"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkgsite/"} {
+			if n := f.Fetches(u); n != 0 {
+				t.Errorf("%s: followed the link to %s", tt.seed, u)
+			}
+		}
+	}
+}
+
+func TestShouldFollow(t *testing.T) {
+	tests := []struct {
+		name   string
+		follow func(url string, depth int) bool
+		want   []string
+	}{
+		{"no /cmd/", func(url string, _ int) bool { return !strings.Contains(url, "/cmd/") }, []string{
+			"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+		{"shallow", func(_ string, depth int) bool { return depth >= 3 }, []string{
+			"https://golang.org/", "https://golang.org/cmd/", "https://golang.org/pkg/",
+		}},
+		{"seed only", func(url string, _ int) bool { return url == "https://golang.org/" }, []string{
+			"https://golang.org/",
+		}},
+	}
+	for _, tt := range tests {
+		var stats CrawlStats
+		results := crawlRawData(t, CrawlConfig{ShouldFollow: tt.follow, Stats: &stats})
+		var got []string
+		for _, r := range results {
+			got = append(got, r.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		// rejected urls aren't counted as skipped or failed either
+		if n := stats.PagesFetched + stats.PagesFailed; n != len(tt.want) {
+			t.Errorf("%s: %d pages fetched or failed, want %d", tt.name, n, len(tt.want))
+		}
+	}
+}
+
+func TestOnLinkDiscovered(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root:       {root + "a", root + "a", root + "b", root},
+		root + "a": {root, root + "b", root + "c"},
+		root + "b": {root + "c"},
+		root + "c": {root + "a"},
+	}
+	tests := []struct {
+		name    string
+		enqueue func(from, to string) bool
+		want    []string
+	}{
+		{"all", func(string, string) bool { return true }, []string{root, root + "a", root + "b", root + "c"}},
+		{"none", func(string, string) bool { return false }, []string{root}},
+		// a is still found by way of b and c
+		{"not from the seed to a", func(from, to string) bool { return from != root || to != root+"a" }, []string{
+			root, root + "a", root + "b", root + "c",
+		}},
+		{"not to b", func(_, to string) bool { return to != root+"b" }, []string{root, root + "a", root + "c"}},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		calls := make(map[string][]string)
+		f := crawltest.NewFakeFetcher(graph)
+		results, err := CrawlErrors(context.Background(), root, Unlimited, f, WithOnLinkDiscovered(func(from, to string, _ int) bool {
+			mu.Lock()
+			calls[from] = append(calls[from], to)
+			mu.Unlock()
+			return tt.enqueue(from, to)
+		}))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.URL)
+			// once per raw link, in order and repeats included
+			if !slices.Equal(calls[r.URL], graph[r.URL]) {
+				t.Errorf("%s: called for %s's links %q, want %q", tt.name, r.URL, calls[r.URL], graph[r.URL])
+			}
+			if r.ParentURL != "" && !tt.enqueue(r.ParentURL, r.URL) {
+				t.Errorf("%s: %s was enqueued from %s", tt.name, r.URL, r.ParentURL)
+			}
+		}
+		if len(calls) != len(results) {
+			t.Errorf("%s: called for links on %d pages, %d were fetched", tt.name, len(calls), len(results))
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		for _, u := range tt.want {
+			if n := f.Fetches(u); n != 1 {
+				t.Errorf("%s: fetched %s %d times, want once", tt.name, u, n)
+			}
+		}
+	}
+}
+
+func TestURLPatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"exclude /cmd/", nil, []string{`/cmd/`}, []string{
+			"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+		{"include", []string{`^https://golang\.org/$`, `/pkg/`}, nil, []string{
+			"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+		{"exclude wins", []string{`.`}, []string{`/cmd/`, `/fmt/$`}, []string{
+			"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/os/",
+		}},
+		{"seed not included", []string{`/pkg/`}, nil, nil},
+	}
+	for _, tt := range tests {
+		var include, exclude []*regexp.Regexp
+		for _, p := range tt.include {
+			include = append(include, regexp.MustCompile(p))
+		}
+		for _, p := range tt.exclude {
+			exclude = append(exclude, regexp.MustCompile(p))
+		}
+		f := crawltest.NewFakeFetcher(rawDataGraph())
+		pages, _ := CrawlErrors(context.Background(), "https://golang.org/", 4, f,
+			WithIncludePatterns(include...), WithExcludePatterns(exclude...))
+		var got []string
+		for _, p := range pages {
+			got = append(got, p.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		if n := f.Fetches("https://golang.org/cmd/"); n != 0 && tt.exclude != nil {
+			t.Errorf("%s: golang.org/cmd/ fetched %d times", tt.name, n)
+		}
+	}
+}
+
+func TestAlreadySeen(t *testing.T) {
+	tests := []struct {
+		name string
+		seen []string
+		want []string
+	}{
+		{"none", nil, []string{
+			"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/",
+		}},
+		{"a leaf", []string{"https://golang.org/pkg/fmt/"}, []string{
+			"https://golang.org/", "https://golang.org/pkg/", "https://golang.org/pkg/os/",
+		}},
+		// so too the pages only it links to
+		{"a child", []string{"https://golang.org/pkg/"}, []string{"https://golang.org/"}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(rawDataGraph())
+		pages, _ := CrawlErrors(context.Background(), "https://golang.org/", 4, f, WithSeen(tt.seen...))
+		var got []string
+		for _, p := range pages {
+			got = append(got, p.URL)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		for _, u := range tt.seen {
+			if n := f.Fetches(u); n != 0 {
+				t.Errorf("%s: fetched %s %d times", tt.name, u, n)
+			}
+		}
+	}
+}
+
+func TestIgnoreQueryInDedup(t *testing.T) {
+	const root = "http://example.com/"
+	variants := []string{root + "page?a=1", root + "page?b=2", root + "page?a=1&c=3"}
+	graph := map[string][]string{root: variants}
+	for _, v := range variants {
+		graph[v] = nil
+	}
+	tests := []struct {
+		name string
+		opts []CrawlOption
+		want int // fetches of the variants
+	}{
+		{"off", nil, 3},
+		{"on", []CrawlOption{WithIgnoreQueryInDedup()}, 1},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		if _, err := CrawlErrors(context.Background(), root, 1, f, tt.opts...); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		n := 0
+		for _, v := range variants {
+			n += f.Fetches(v)
+		}
+		if n != tt.want {
+			t.Errorf("%s: %d fetches of the page, want %d", tt.name, n, tt.want)
+		}
+	}
+}
+
+func TestCollapseIndexFiles(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root:                      {root + "dir/index.html", root + "dir/", root + "index.htm", root + "dir/page.html"},
+		root + "dir/index.html":   {root + "dir/default.html"},
+		root + "dir/":             nil,
+		root + "dir/default.html": nil,
+		root + "index.htm":        nil,
+		root + "dir/page.html":    nil,
+	}
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+		want []string // fetched
+	}{
+		{"off", CrawlConfig{}, []string{
+			root, root + "dir/", root + "dir/default.html", root + "dir/index.html", root + "dir/page.html", root + "index.htm",
+		}},
+		// under the first url found
+		{"on", CrawlConfig{CollapseIndexFiles: true}, []string{root, root + "dir/index.html", root + "dir/page.html"}},
+		{"ignoring the query too", CrawlConfig{CollapseIndexFiles: true, IgnoreQueryInDedup: true},
+			[]string{root, root + "dir/index.html", root + "dir/page.html"}},
+		// a DedupKey of its own wins
+		{"DedupKey", CrawlConfig{CollapseIndexFiles: true, DedupKey: NormalizedKey}, []string{
+			root, root + "dir/", root + "dir/default.html", root + "dir/index.html", root + "dir/page.html", root + "index.htm",
+		}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth, cfg.Sequential = f, Unlimited, true
+		results, err := Crawl(context.Background(), root, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range results {
+		}
+		var got []string
+		for u := range graph {
+			if n := f.Fetches(u); n > 0 {
+				got = append(got, u)
+			}
+			if n := f.Fetches(u); n > 1 {
+				t.Errorf("%s: %s fetched %d times", tt.name, u, n)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: fetched %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRelativeDepth(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root:                  {root + "docs/", root + "other/"},
+		root + "docs/":        {root + "pkg/"},
+		root + "pkg/":         {root + "pkg/a/"},
+		root + "pkg/a/":       {root + "pkg/a/b/"},
+		root + "pkg/a/b/":     {root + "pkg/a/b/c/"},
+		root + "pkg/a/b/c/":   {root + "pkg/a/b/c/d/"},
+		root + "pkg/a/b/c/d/": nil,
+		root + "other/":       {root + "other/x/"},
+		root + "other/x/":     {root + "other/x/y/"},
+		root + "other/x/y/":   nil,
+	}
+	// within Depth 2 of the seed
+	near := []string{root, root + "docs/", root + "other/", root + "other/x/", root + "pkg/"}
+	tests := []struct {
+		name     string
+		relative int
+		pattern  string
+		want     []string // besides near
+	}{
+		{"off", 0, "", nil},
+		{"section root", 2, `/pkg/$`, []string{root + "pkg/a/", root + "pkg/a/b/"}},
+		{"section root, 1", 1, `/pkg/$`, []string{root + "pkg/a/"}},
+		// each page of the section starts the count again
+		{"whole section", 2, `/pkg/`, []string{root + "pkg/a/", root + "pkg/a/b/", root + "pkg/a/b/c/", root + "pkg/a/b/c/d/"}},
+		{"no match", 2, `/nowhere/`, nil},
+	}
+	for _, tt := range tests {
+		opts := []CrawlOption{WithRelativeDepth(tt.relative)}
+		if tt.pattern != "" {
+			opts = append(opts, WithIncludePatterns(regexp.MustCompile(tt.pattern)))
+		}
+		f := crawltest.NewFakeFetcher(graph)
+		pages, err := CrawlErrors(context.Background(), root, 2, f, opts...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, p := range pages {
+			got = append(got, p.URL)
+		}
+		slices.Sort(got)
+		want := slices.Sorted(slices.Values(append(slices.Clone(near), tt.want...)))
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestAllowedSchemes(t *testing.T) {
+	const root = "http://example.com/"
+	links := []string{
+		root + "a", "HTTPS://example.com/b", "ftp://example.com/file", "tel:+15555550100", "data:text/plain,hi",
+		"mailto:a@example.com", "MAILTO:b@example.com", "javascript:void(0)", "file:///etc/passwd",
+	}
+	graph := map[string][]string{root: links}
+	for _, u := range links {
+		graph[u] = nil
+	}
+	tests := []struct {
+		name    string
+		schemes []string
+		want    []string // links followed
+		skipped map[string]int
+	}{
+		{"default", nil, []string{root + "a", "HTTPS://example.com/b"},
+			map[string]int{"ftp": 1, "tel": 1, "data": 1, "mailto": 2, "javascript": 1, "file": 1}},
+		{"ftp too, in any case", []string{"http", "HTTPS", "FTP"}, []string{root + "a", "HTTPS://example.com/b", "ftp://example.com/file"},
+			map[string]int{"tel": 1, "data": 1, "mailto": 2, "javascript": 1, "file": 1}},
+		// the seed's scheme isn't added to a list given
+		{"https only", []string{"https"}, []string{"HTTPS://example.com/b"},
+			map[string]int{"http": 1, "ftp": 1, "tel": 1, "data": 1, "mailto": 2, "javascript": 1, "file": 1}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		var stats CrawlStats
+		cfg := CrawlConfig{Fetcher: f, Depth: 1, AllowedSchemes: tt.schemes, Stats: &stats}
+		results, err := Crawl(context.Background(), root, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range results {
+		}
+		for _, u := range links {
+			if want := slices.Contains(tt.want, u); (f.Fetches(u) == 1) != want {
+				t.Errorf("%s: %s fetched %d times, want followed %v", tt.name, u, f.Fetches(u), want)
+			}
+		}
+		if !reflect.DeepEqual(stats.SkippedSchemes, tt.skipped) {
+			t.Errorf("%s: skipped schemes %v, want %v", tt.name, stats.SkippedSchemes, tt.skipped)
+		}
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	const root = "http://example.com/"
+	links := []string{
+		root + "doc.html", root + "report.PDF", root + "photo.jpg", root + "index.php?x=1.pdf",
+		root + "dir/", root + "archive.zip", root + "v1.2/notes",
+	}
+	graph := map[string][]string{root: links}
+	for _, u := range links {
+		graph[u] = nil
+	}
+	tests := []struct {
+		name         string
+		follow, skip []string
+		want         []string // links followed
+	}{
+		{"none", nil, nil, links},
+		// the query's extension doesn't count, nor a directory's
+		{"skip pdf", nil, []string{".pdf"}, []string{
+			root + "doc.html", root + "photo.jpg", root + "index.php?x=1.pdf", root + "dir/", root + "archive.zip", root + "v1.2/notes",
+		}},
+		{"skip many, without dots", nil, []string{"pdf", "JPG", "zip"}, []string{
+			root + "doc.html", root + "index.php?x=1.pdf", root + "dir/", root + "v1.2/notes",
+		}},
+		{"follow html and php", []string{".html", ".php"}, nil, []string{
+			root + "doc.html", root + "index.php?x=1.pdf", root + "dir/", root + "v1.2/notes",
+		}},
+		// skipping wins
+		{"follow and skip", []string{".html", ".pdf"}, []string{".pdf"}, []string{
+			root + "doc.html", root + "dir/", root + "v1.2/notes",
+		}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		pages, err := CrawlErrors(context.Background(), root, 1, f,
+			WithFollowExtensions(tt.follow...), WithSkipExtensions(tt.skip...))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if len(pages) != len(tt.want)+1 {
+			t.Errorf("%s: crawled %d pages, want %d", tt.name, len(pages), len(tt.want)+1)
+		}
+		for _, u := range links {
+			if want := slices.Contains(tt.want, u); (f.Fetches(u) == 1) != want {
+				t.Errorf("%s: %s fetched %d times, want followed %v", tt.name, u, f.Fetches(u), want)
+			}
+		}
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	const root = "http://example.com/"
+	links := []string{root + "page?a=1", root + "page?b=2", root + "page", root + "PAGE?a=1", root + "other?a=1"}
+	graph := map[string][]string{root: links}
+	for _, u := range links {
+		graph[u] = nil
+	}
+	lowerPath := func(url string) string { return strings.ToLower(PathKey(url)) }
+	tests := []struct {
+		name string
+		opts []CrawlOption
+		want int // pages crawled besides the seed
+	}{
+		{"default", nil, 5},
+		{"NormalizedKey", []CrawlOption{WithDedupKey(NormalizedKey)}, 5},
+		{"PathKey", []CrawlOption{WithDedupKey(PathKey)}, 3},
+		{"lower case path", []CrawlOption{WithDedupKey(lowerPath)}, 2},
+		// a DedupKey wins over IgnoreQueryInDedup
+		{"NormalizedKey, IgnoreQueryInDedup", []CrawlOption{WithDedupKey(NormalizedKey), WithIgnoreQueryInDedup()}, 5},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		pages, err := CrawlErrors(context.Background(), root, 1, f, tt.opts...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if len(pages) != tt.want+1 {
+			t.Errorf("%s: crawled %d pages, want %d", tt.name, len(pages), tt.want+1)
+		}
+		// under the urls found, never their keys
+		for _, p := range pages {
+			if _, ok := graph[p.URL]; !ok {
+				t.Errorf("%s: crawled %s, which isn't linked to", tt.name, p.URL)
+			}
+		}
+		if n := totalFetches(f, graph); n != tt.want+1 {
+			t.Errorf("%s: made %d fetches, want %d", tt.name, n, tt.want+1)
+		}
+	}
+}
+
+// stripTracking is a URLRewriter that drops utm_* query parameters.
+func stripTracking(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	q := u.Query()
+	for k := range q {
+		if strings.HasPrefix(k, "utm_") {
+			q.Del(k)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func TestURLRewriter(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root: {
+			root + "page?utm_source=news&utm_medium=email",
+			root + "page?utm_source=feed",
+			root + "item?id=1&utm_campaign=x",
+		},
+		root + "page":      {root + "page?utm_content=footer"},
+		root + "item?id=1": nil,
+	}
+	f := crawltest.NewFakeFetcher(graph)
+	// the seed is rewritten too
+	pages, err := CrawlErrors(context.Background(), root+"?utm_source=ad", 4, f, WithURLRewriter(stripTracking))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for u := range graph {
+		if n := f.Fetches(u); n != 1 {
+			t.Errorf("%s fetched %d times, want once", u, n)
+		}
+	}
+	if len(pages) != len(graph) {
+		t.Errorf("crawled %d pages, want %d", len(pages), len(graph))
+	}
+	for _, p := range pages {
+		for _, l := range p.Links {
+			if strings.Contains(l, "utm_") {
+				t.Errorf("%s links to %s, not rewritten", p.URL, l)
+			}
+		}
+	}
+}
+
+// FuzzHostOf checks that the shortcut hostOf takes for plain urls
+// finds the same host as parsing them does.
+func FuzzHostOf(f *testing.F) {
+	for _, s := range []string{
+		"http://example.com/a",
+		"HTTPS://Example.COM:8443?q#f",
+		"http://user@host/",
+		"http://[::1]:80/",
+		"http://a:b/",
+		"http:///x",
+		"http://a/%zz",
+		"mailto:a@b",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, rawurl string) {
+		want := ""
+		if u, err := url.Parse(rawurl); err == nil {
+			want = strings.ToLower(u.Hostname())
+		}
+		if got := hostOf(rawurl); got != want {
+			t.Errorf("hostOf(%q) = %q, want %q", rawurl, got, want)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Page is a fetched page, along with what the fetcher learned about
+// the response that served it.
+type Page struct {
+	Body       string
+	URLs       []string // links found on the page
+	StatusCode int      // HTTP status code, or 0 if not known
+
+	// ContentType is the Content-Type of the response, if known.
+	ContentType string
+
+	// RedirectChain lists the urls requested, starting with the url
+	// asked for and ending with the one that served the page, if
+	// the fetch was redirected. It is nil otherwise.
+	RedirectChain []string
+
+	// Canonical is the url the page names as its canonical one, in
+	// a <link rel="canonical">, if the fetcher reports that.
+	Canonical string
+
+	// Title and Description are the page's <title> and its
+	// <meta name="description">, if the fetcher reports them.
+	Title, Description string
+
+	// Assets lists the urls of the resources the page uses, such as
+	// its images, scripts and stylesheets, if the fetcher reports
+	// them. They are kept apart from URLs as they are not pages to
+	// crawl from.
+	Assets []string
+
+	// StructuredData holds the JSON-LD blocks the page embeds, each
+	// valid JSON, if the fetcher reports them.
+	StructuredData [][]byte
+
+	// Sitemaps lists the XML sitemaps the page names, in
+	// <link rel="sitemap"> tags, if the fetcher reports them.
+	Sitemaps []string
+
+	// BodyHash is the SHA-256 of the body, in hex, and Blank is set
+	// if the body is empty or only white space, for a page reported
+	// without its body, as an HTTPFetcher reports one that hasn't
+	// changed since it last fetched it. The crawl works both out
+	// from the Body if neither is set.
+	BodyHash string
+	Blank    bool
+
+	// Nofollow lists the links in URLs that the page marks
+	// rel="nofollow" wherever it links to them, if the fetcher
+	// reports them.
+	Nofollow []string
+
+	// TTFB is the time from the start of the fetch to the first
+	// byte of the response that served the page, or 0 if not known.
+	TTFB time.Duration
+
+	// Timing breaks the fetch down into its phases, if the fetcher
+	// reports that.
+	Timing *Timing
+}
+
+// Timing is how long the phases of fetching a page over HTTP took,
+// for the response that served the page. A phase that didn't happen
+// takes zero, as the DNS lookup, connect and TLS handshake do when a
+// connection is reused.
+type Timing struct {
+	DNSLookup    time.Duration // resolving the host name
+	Connect      time.Duration // dialing the server
+	TLSHandshake time.Duration
+	TTFB         time.Duration // from sending the request to the first byte of the response
+}
+
+// PageFetcher is a Fetcher that can report more about a page than
+// its body and links. The crawler uses FetchPage instead of Fetch
+// when a fetcher has it, and wrapping fetchers pass it through.
+type PageFetcher interface {
+	Fetcher
+
+	// FetchPage is like Fetch but returns a Page. It may return a
+	// page along with an error, for example the body of an HTTP
+	// error response with a *StatusError.
+	FetchPage(ctx context.Context, url string) (*Page, error)
+}
+
+// fetchPage fetches url with f, using FetchPage if f has it.
+func fetchPage(ctx context.Context, f Fetcher, url string) (*Page, error) {
+	if pf, ok := f.(PageFetcher); ok {
+		return pf.FetchPage(ctx, url)
+	}
+	body, urls, err := f.Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Body: body, URLs: urls}, nil
+}
+
+// bodyHash returns the BodyHash of p and whether its body is blank,
+// working them out from its Body unless the fetcher gave them.
+func (p *Page) bodyHash() (string, bool) {
+	if p.BodyHash != "" || p.Blank {
+		return p.BodyHash, p.Blank
+	}
+	if p.Body == "" {
+		return "", true
+	}
+	sum := sha256.Sum256([]byte(p.Body))
+	return hex.EncodeToString(sum[:]), strings.TrimSpace(p.Body) == ""
+}
+
+// parentKey is the context key of the url of the page that linked to
+// the one being fetched.
+type parentKey struct{}
+
+// ContextWithParent returns a copy of ctx recording parent as the url
+// of the page that linked to the one to be fetched with it. A crawl
+// does this for each fetch but the seeds', so that fetchers can tell
+// where a link came from, as an HTTPFetcher with SendReferer does.
+func ContextWithParent(ctx context.Context, parent string) context.Context {
+	return context.WithValue(ctx, parentKey{}, parent)
+}
+
+// ParentFromContext returns the parent url recorded in ctx by
+// ContextWithParent, reporting false if there is none.
+func ParentFromContext(ctx context.Context) (string, bool) {
+	parent, ok := ctx.Value(parentKey{}).(string)
+	return parent, ok
+}
+
+// idleCloser is implemented by fetchers that keep connections open
+// for reuse, and by http.Client and http.Transport.
+type idleCloser interface {
+	CloseIdleConnections()
+}
+
+// wrapper is implemented by the fetchers of this package that wrap
+// another.
+type wrapper interface {
+	wrapped() Fetcher
+}
+
+// closeIdleConnections closes the idle connections of f, or of the
+// first fetcher it wraps that has any, for Crawler.Close.
+func closeIdleConnections(f Fetcher) {
+	for f != nil {
+		if c, ok := f.(idleCloser); ok {
+			c.CloseIdleConnections()
+			return
+		}
+		w, ok := f.(wrapper)
+		if !ok {
+			return
+		}
+		f = w.wrapped()
+	}
+}
+
+// fromPage turns the results of FetchPage into those of Fetch.
+func fromPage(p *Page, err error) (string, []string, error) {
+	if err != nil {
+		return "", nil, err
+	}
+	return p.Body, p.URLs, nil
+}
+
+// StatusError is returned, as *StatusError, when a server answers
+// with an HTTP status other than success. For 404 Not Found and
+// 410 Gone it wraps ErrNotFound.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v: unexpected status %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *StatusError) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone {
+		return ErrNotFound
+	}
+	return nil
+}
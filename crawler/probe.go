@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ProbeResult is what Probe learned about a seed.
+type ProbeResult struct {
+	URL string
+
+	// Reachable reports whether anything answered for the url, even
+	// to say that it doesn't exist. It is false if the fetch failed
+	// without an answer, say because the host couldn't be looked up
+	// or the connection was refused.
+	Reachable bool
+
+	StatusCode  int    // HTTP status of the response, or 0 if not known
+	ContentType string // Content-Type of the response, if known
+	Links       int    // how many links the page has
+}
+
+// Probe fetches seed with fetcher, and nothing else, to check that a
+// crawl from it can start before starting one, so that a broken seed
+// gets a clear message rather than a run of empty results. It returns
+// an error if seed is not an absolute url or the fetch failed, along
+// with what it learned: a seed that answers 404, say, is Reachable,
+// with a StatusCode, but still an error. The crawl fetches the seed
+// again; Probe doesn't pass the page on.
+func Probe(ctx context.Context, seed string, fetcher Fetcher) (ProbeResult, error) {
+	res := ProbeResult{URL: seed}
+	if u, err := url.Parse(seed); err != nil {
+		return res, fmt.Errorf("crawl: %w", err)
+	} else if !u.IsAbs() {
+		return res, fmt.Errorf("crawl: seed %q is not an absolute url", seed)
+	}
+
+	page, err := fetchPage(ctx, fetcher, seed)
+	if page != nil {
+		res.Reachable = true
+		res.StatusCode, res.ContentType = page.StatusCode, page.ContentType
+		res.Links = len(page.URLs)
+	}
+	var serr *StatusError
+	if errors.As(err, &serr) {
+		res.Reachable = true
+		res.StatusCode = serr.StatusCode
+	} else if errors.Is(err, ErrNotFound) {
+		res.Reachable = true
+	}
+	return res, err
+}
+
+// FetchOne fetches rawurl with fetcher, and nothing else, returning its
+// result as a crawl to depth 0 from it would, for scripts that only
+// want one page and its links without running a crawl. Its links are
+// made absolute but not filtered or followed. An error is returned if
+// rawurl is not an absolute url, and the fetcher's error is returned as
+// it is if the fetch failed, along with what is known of the page.
+func FetchOne(ctx context.Context, rawurl string, fetcher Fetcher) (CrawlResult, error) {
+	res := CrawlResult{URL: rawurl, Path: []string{rawurl}}
+	if u, err := url.Parse(rawurl); err != nil {
+		return res, fmt.Errorf("crawl: %w", err)
+	} else if !u.IsAbs() {
+		return res, fmt.Errorf("crawl: %q is not an absolute url", rawurl)
+	}
+
+	start := time.Now()
+	page, err := fetchPage(ctx, fetcher, rawurl)
+	res.FetchedAt = time.Now()
+	res.FetchDuration = res.FetchedAt.Sub(start)
+	if page == nil {
+		return res, err
+	}
+	res.Body, res.StatusCode, res.ContentType = page.Body, page.StatusCode, page.ContentType
+	res.RedirectChain, res.TTFB, res.Timing = page.RedirectChain, page.TTFB, page.Timing
+	res.Canonical = page.Canonical
+	res.Title, res.Description = page.Title, page.Description
+	res.StructuredData = page.StructuredData
+	if err != nil {
+		return res, err
+	}
+
+	base := rawurl
+	if n := len(page.RedirectChain); n > 0 {
+		base = page.RedirectChain[n-1]
+	}
+	res.Links = resolveLinks(base, page.URLs)
+	res.Assets = resolveLinks(base, page.Assets)
+	res.DepthLimited = len(res.Links) > 0
+	var blank bool
+	res.BodyHash, blank = page.bodyHash()
+	res.Empty = len(page.URLs) == 0 && blank
+	return res, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestProbe(t *testing.T) {
+	fake := crawltest.NewFakeFetcher(rawDataGraph())
+	fake.SetError("https://golang.org/down/", errors.New("connection refused"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<a href="/a">a</a> <a href="/b">b</a>`)
+		case "/broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		seed    string
+		f       Fetcher
+		want    ProbeResult // but for the URL
+		wantErr bool
+	}{
+		{"reachable", "https://golang.org/", fake, ProbeResult{Reachable: true, Links: 2}, false},
+		// the rawData fetcher's ErrNotFound, which a FakeFetcher's isn't
+		{"not found", "https://golang.org/cmd/", myFetcher{}, ProbeResult{Reachable: true}, true},
+		{"unreachable", "https://golang.org/down/", fake, ProbeResult{}, true},
+		{"relative seed", "/pkg/", fake, ProbeResult{}, true},
+		{"http", srv.URL + "/", NewHTTPFetcher(srv.Client()),
+			ProbeResult{Reachable: true, StatusCode: 200, ContentType: "text/html; charset=utf-8", Links: 2}, false},
+		{"http 404", srv.URL + "/missing", NewHTTPFetcher(srv.Client()),
+			ProbeResult{Reachable: true, StatusCode: 404, ContentType: "text/plain; charset=utf-8"}, true},
+		{"http 500", srv.URL + "/broken", NewHTTPFetcher(srv.Client()),
+			ProbeResult{Reachable: true, StatusCode: 500, ContentType: "text/plain; charset=utf-8"}, true},
+		{"connection refused", closed.URL + "/", NewHTTPFetcher(closed.Client()), ProbeResult{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Probe(context.Background(), tt.seed, tt.f)
+		tt.want.URL = tt.seed
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: Probe = %+v, %v; want %+v, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+	// only the seed
+	if n := totalFetches(fake, rawDataGraph()); n != 1 {
+		t.Errorf("%d fetches of rawData pages, want only the seed's", n)
+	}
+}
+
+func TestFetchOne(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	fake := crawltest.NewFakeFetcher(rawDataGraph())
+	fake.SetError("https://golang.org/down/", errRefused)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<a href="/a">a</a> <a href="b">b</a>`)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		f       Fetcher
+		links   []string
+		wantErr error
+	}{
+		{"seed", "https://golang.org/", fake, []string{"https://golang.org/pkg/", "https://golang.org/cmd/"}, nil},
+		{"leaf", "https://golang.org/pkg/os/", fake, []string{"https://golang.org/", "https://golang.org/pkg/"}, nil},
+		{"fetch error", "https://golang.org/down/", fake, nil, errRefused},
+		{"not found", "https://golang.org/cmd/", myFetcher{}, nil, ErrNotFound},
+		{"relative links", srv.URL + "/dir/", NewHTTPFetcher(srv.Client()), []string{srv.URL + "/a", srv.URL + "/dir/b"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := FetchOne(context.Background(), tt.url, tt.f)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if got.URL != tt.url || !slices.Equal(got.Links, tt.links) {
+			t.Errorf("%s: FetchOne = %s with links %q, want %s with %q", tt.name, got.URL, got.Links, tt.url, tt.links)
+		}
+	}
+	// nothing but the pages asked for
+	if n := totalFetches(fake, rawDataGraph()); n != 2 {
+		t.Errorf("%d fetches of rawData pages, want 2", n)
+	}
+
+	// the fetcher's error itself, not wrapped
+	if _, err := FetchOne(context.Background(), "https://golang.org/down/", fake); err != errRefused {
+		t.Errorf("FetchOne of a failing page: error %v, want the fetcher's", err)
+	}
+
+	if _, err := FetchOne(context.Background(), "/pkg/", fake); err == nil {
+		t.Error("FetchOne of a relative url: no error")
+	}
+}
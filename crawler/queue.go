@@ -0,0 +1,450 @@
+package main
+
+import (
+	"container/heap"
+	"slices"
+	"sync"
+)
+
+// crawlTask is a url waiting to be crawled at the given depth.
+type crawlTask struct {
+	url    string
+	parent string // page that linked to url
+	depth  int    // remaining depth, or Unlimited
+	level  int    // links followed from the seed to reach url
+	route  *route // how url was reached
+	asset  bool   // one of its parent's Assets, not to be crawled from
+
+	reserved bool // its page was reserved by the queue's reserve function
+
+	priority int // its score, in a queue with a priority function
+	seq      int // when it was queued, for ties in such a queue
+}
+
+// route is a list of the urls followed from a seed to a task's url,
+// last first. Tasks share the start of their routes with their
+// parents', so keeping the route of every task costs little.
+type route struct {
+	url  string
+	prev *route // nil for a seed
+}
+
+// urls returns the urls of the route, starting with the seed.
+func (r *route) urls() []string {
+	var out []string
+	for ; r != nil; r = r.prev {
+		out = append(out, r.url)
+	}
+	slices.Reverse(out)
+	return out
+}
+
+// taskQueue is a work queue shared by a pool of crawl workers. It
+// keeps track of queued and in-progress tasks so that workers know
+// when the crawl has run out of work.
+//
+// A queue made with a positive max holds about that many tasks
+// besides the seeds: pushing to a full queue waits for room. The one
+// exception is when every task in progress is waiting to push, as
+// nothing would ever make room. Then the push goes ahead, but to the
+// front of the queue, so that the crawl goes deeper before it goes
+// wider and the queue outgrows max by no more than the links along
+// one path from the seed. That doesn't hold for a byLevel or sorted
+// queue, whose order is fixed.
+//
+// A queue made with byLevel set hands out tasks one depth level at a
+// time: no task of a level is popped until every task of the level
+// before it is done.
+//
+// A queue made with sorted set hands out the task with the smallest
+// url first, rather than the oldest.
+//
+// A queue made with a priority function hands out the task it scores
+// highest first, keeping the tasks in a heap; ties go to the oldest,
+// or to the smallest url if the queue is sorted too. Like a sorted
+// queue's, its order is fixed.
+//
+// A queue with a reserve function calls it with each task it is
+// about to hand out, and holds on to a task it refuses until no task
+// is in progress, in case one gives back what it reserved; a task
+// still refused then is dropped. Which tasks are handed out then
+// depends only on the queue's order, not on how the work is shared
+// out, as long as it is byLevel and sorted; see StableMaxPages.
+//
+// While the queue is paused tasks can be pushed and finished, but
+// none are popped.
+type taskQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	tasks    []crawlTask
+	pending  int // queued plus in-progress tasks
+	inflight int // in-progress tasks
+	paused   bool
+	stopped  bool // set by stop: the queue can't be paused again
+	held     bool // set by hold: counted as a pending task
+
+	max     int        // most tasks queued, or 0 for no limit
+	room    *sync.Cond // signalled when a task leaves the queue
+	waiting int        // pushes waiting for room
+
+	sorted  bool
+	byLevel bool
+	level   int         // level of the tasks in tasks, if byLevel
+	next    []crawlTask // tasks of the level after, if byLevel
+
+	priority func(url string, depth int) int // nil for none
+	seq      int                             // tasks queued so far, if priority
+
+	reserve func(t crawlTask) bool // nil for none; called with mu held
+}
+
+func newTaskQueue(byLevel, sorted bool, max int, priority func(url string, depth int) int) *taskQueue {
+	q := &taskQueue{byLevel: byLevel, sorted: sorted, max: max, priority: priority}
+	q.cond = sync.NewCond(&q.mu)
+	q.room = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds t to the queue, first waiting for room if the queue is
+// full and t is not a seed. It must be called from the worker running
+// t's parent, for the queue to tell when waiting would stall.
+func (q *taskQueue) push(t crawlTask) {
+	q.score(&t)
+	q.mu.Lock()
+	full := func() bool {
+		return q.max > 0 && t.level > 0 && !q.stopped && len(q.tasks)+len(q.next) >= q.max
+	}
+	for full() && q.waiting+1 < q.inflight {
+		q.waiting++
+		q.room.Wait()
+		q.waiting--
+	}
+	q.add(t, full())
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// restore queues tasks taken from a queue by freeze, in the same
+// order, without waiting for room.
+func (q *taskQueue) restore(tasks []crawlTask) {
+	for i := range tasks {
+		q.score(&tasks[i])
+	}
+	q.mu.Lock()
+	for _, t := range tasks {
+		q.add(t, false)
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// score sets the priority of t, if the queue has a priority
+// function. It is called without q.mu held, as the function is the
+// caller's.
+func (q *taskQueue) score(t *crawlTask) {
+	if q.priority != nil {
+		t.priority = q.priority(t.url, t.depth)
+	}
+}
+
+// add queues t, at the front of the queue if front is set and t is
+// of the current level. It must be called with q.mu held.
+func (q *taskQueue) add(t crawlTask, front bool) {
+	if q.byLevel && q.pending == 0 {
+		q.level = t.level
+	}
+	if q.priority != nil {
+		t.seq = q.seq
+		q.seq++
+	}
+	if q.byLevel && t.level != q.level {
+		q.next = append(q.next, t)
+	} else if q.priority != nil {
+		heap.Push(q.heap(), t)
+	} else if front {
+		q.tasks = slices.Insert(q.tasks, 0, t)
+	} else {
+		q.tasks = append(q.tasks, t)
+	}
+	q.pending++
+}
+
+// hold keeps the queue from running out of work until release is
+// called or the queue is stopped, as if a task were pending, so that
+// the workers wait for tasks given by pushHeld rather than finish.
+func (q *taskQueue) hold() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.held = true
+	q.pending++
+}
+
+// release undoes hold. It must be called with q.mu held.
+func (q *taskQueue) release() {
+	if q.held {
+		q.held = false
+		q.pending--
+	}
+}
+
+// endHold undoes hold, once the tasks it waited for are queued. It
+// does nothing if the queue is not held.
+func (q *taskQueue) endHold() {
+	q.mu.Lock()
+	q.release()
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pushHeld is push for a task given from outside the crawl: it adds t
+// without waiting for room, and only while the queue is held,
+// reporting false if it wasn't.
+func (q *taskQueue) pushHeld(t crawlTask) bool {
+	q.score(&t)
+	q.mu.Lock()
+	held := q.held
+	if held {
+		q.add(t, false)
+	}
+	q.mu.Unlock()
+	q.cond.Signal()
+	return held
+}
+
+// pop blocks until a task is available and returns it. It returns
+// false once the queue is empty and no task is in progress, meaning
+// the crawl is finished. Every task returned by pop must be followed
+// by a call to done.
+func (q *taskQueue) pop() (crawlTask, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if len(q.tasks) > 0 && !q.paused && q.reserve != nil {
+			i := q.nextTask()
+			if t := &q.tasks[i]; !t.reserved && !q.reserve(*t) {
+				if q.inflight > 0 && !q.stopped {
+					q.cond.Wait()
+				} else {
+					q.dropTask(i)
+				}
+				continue
+			}
+			q.tasks[i].reserved = true
+		}
+		if len(q.tasks) > 0 && !q.paused && q.priority != nil {
+			q.inflight++
+			if q.waiting > 0 {
+				q.room.Signal()
+			}
+			return heap.Pop(q.heap()).(crawlTask), true
+		}
+		if len(q.tasks) > 0 && !q.paused {
+			if q.sorted {
+				// bring the smallest to the front
+				i := q.minTask()
+				q.tasks[0], q.tasks[i] = q.tasks[i], q.tasks[0]
+			}
+			t := q.tasks[0]
+			q.tasks = q.tasks[1:]
+			q.inflight++
+			if q.waiting > 0 {
+				q.room.Signal()
+			}
+			return t, true
+		}
+		if q.pending == 0 {
+			return crawlTask{}, false
+		}
+		if q.byLevel && q.inflight == 0 && len(q.tasks) == 0 {
+			// the level is finished; move on to the next
+			q.tasks, q.next = q.next, nil
+			q.level++
+			if q.priority != nil {
+				heap.Init(q.heap())
+			}
+			continue
+		}
+		q.cond.Wait()
+	}
+}
+
+// heap returns q.tasks as a heap.Interface ordered as a queue with a
+// priority function hands them out. It must be used with q.mu held.
+func (q *taskQueue) heap() *taskHeap {
+	return &taskHeap{&q.tasks, q.sorted}
+}
+
+// taskHeap is a heap of tasks, the highest priority first.
+type taskHeap struct {
+	tasks  *[]crawlTask
+	sorted bool // break ties by url rather than age
+}
+
+func (h *taskHeap) Len() int { return len(*h.tasks) }
+
+func (h *taskHeap) Less(i, j int) bool {
+	a, b := (*h.tasks)[i], (*h.tasks)[j]
+	switch {
+	case a.priority != b.priority:
+		return a.priority > b.priority
+	case h.sorted && a.url != b.url:
+		return a.url < b.url
+	}
+	return a.seq < b.seq
+}
+
+func (h *taskHeap) Swap(i, j int) {
+	(*h.tasks)[i], (*h.tasks)[j] = (*h.tasks)[j], (*h.tasks)[i]
+}
+
+func (h *taskHeap) Push(x any) {
+	*h.tasks = append(*h.tasks, x.(crawlTask))
+}
+
+func (h *taskHeap) Pop() any {
+	old := *h.tasks
+	t := old[len(old)-1]
+	*h.tasks = old[:len(old)-1]
+	return t
+}
+
+// nextTask returns the index of the task in q.tasks, which must not
+// be empty, that pop hands out next. It must be called with q.mu
+// held.
+func (q *taskQueue) nextTask() int {
+	if q.sorted && q.priority == nil {
+		return q.minTask()
+	}
+	return 0 // the front of the queue, or the top of the heap
+}
+
+// dropTask removes the task at index i of q.tasks, as if it had been
+// popped and done. It must be called with q.mu held.
+func (q *taskQueue) dropTask(i int) {
+	if q.priority != nil {
+		heap.Remove(q.heap(), i)
+	} else {
+		q.tasks = slices.Delete(q.tasks, i, i+1)
+	}
+	q.pending--
+	if q.waiting > 0 {
+		q.room.Signal()
+	}
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+// minTask returns the index of the task with the smallest url in
+// q.tasks, which must not be empty. It must be called with q.mu held.
+func (q *taskQueue) minTask() int {
+	min := 0
+	for i, t := range q.tasks {
+		if t.url < q.tasks[min].url {
+			min = i
+		}
+	}
+	return min
+}
+
+// setPaused pauses or resumes handing out tasks.
+func (q *taskQueue) setPaused(paused bool) {
+	q.mu.Lock()
+	q.paused = paused && !q.stopped
+	q.mu.Unlock()
+	if !paused {
+		q.cond.Broadcast()
+	}
+}
+
+// isPaused reports whether the queue is paused.
+func (q *taskQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// stop is called when the crawl is cancelled. It resumes handing out
+// tasks for good, ignoring any later attempt to pause the queue, and
+// lets pushes go ahead without waiting for room, so that the workers
+// can wind the crawl down.
+func (q *taskQueue) stop() {
+	q.mu.Lock()
+	q.paused, q.stopped = false, true
+	q.release()
+	q.mu.Unlock()
+	q.cond.Broadcast()
+	q.room.Broadcast()
+}
+
+// freeze pauses the queue and waits until no task is in progress,
+// then calls fn with the queued tasks, in the order they would be
+// popped were the queue not sorted or prioritized. The queue is left
+// paused or not as it was. freeze returns false without calling fn if
+// the queue is stopped first.
+func (q *taskQueue) freeze(fn func(tasks []crawlTask)) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	wasPaused := q.paused
+	q.paused = !q.stopped
+	for q.inflight > 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	q.paused = wasPaused && !q.stopped
+	if q.stopped {
+		return false
+	}
+	fn(append(slices.Clone(q.tasks), q.next...))
+	q.cond.Broadcast()
+	return true
+}
+
+// drop removes the queued tasks for which fn reports true, as if
+// they had been popped and done, and returns how many there were.
+func (q *taskQueue) drop(fn func(t crawlTask) bool) int {
+	q.mu.Lock()
+	n := len(q.tasks) + len(q.next)
+	q.tasks = slices.DeleteFunc(q.tasks, fn)
+	q.next = slices.DeleteFunc(q.next, fn)
+	n -= len(q.tasks) + len(q.next)
+	q.pending -= n
+	if q.priority != nil {
+		heap.Init(q.heap())
+	}
+	q.mu.Unlock()
+	if n > 0 {
+		// the queue may be finished, or have room now
+		q.cond.Broadcast()
+		q.room.Broadcast()
+	}
+	return n
+}
+
+// inspect calls fn with the numbers of queued and in-progress tasks,
+// holding the queue's lock so that no task is pushed, popped or
+// finished until fn returns.
+func (q *taskQueue) inspect(fn func(queued, inflight int)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fn(len(q.tasks)+len(q.next), q.inflight)
+}
+
+// done marks a task returned by pop as finished.
+func (q *taskQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	q.inflight--
+	wake := q.pending == 0 || q.inflight == 0 && (q.byLevel || q.paused || q.reserve != nil)
+	if q.waiting > 0 {
+		// one fewer task in progress may leave all the rest
+		// waiting to push
+		q.room.Broadcast()
+	}
+	q.mu.Unlock()
+	if wake {
+		// wake every idle worker so they can exit, or
+		// start on the next level, and any freeze waiting for
+		// the workers to finish
+		q.cond.Broadcast()
+	}
+}
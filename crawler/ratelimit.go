@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitFetcher is a Fetcher that spaces out requests to each
+// host. It wraps another Fetcher and starts fetches of urls on the
+// same host at least interval apart; urls on different hosts don't
+// wait for each other. It is safe for concurrent use.
+type RateLimitFetcher struct {
+	fetcher  Fetcher
+	interval time.Duration
+	bucket   TokenBucket // see SetTokenBucket
+
+	// extra random delay added to interval; see SetJitter
+	minJitter, maxJitter time.Duration
+	mu                   sync.Mutex // guards rand
+	rand                 *rand.Rand
+
+	hostDelay func(ctx context.Context, url string) time.Duration // see SetHostDelay
+}
+
+// NewRateLimitFetcher returns a RateLimitFetcher that wraps fetcher
+// and waits interval between fetches from the same host.
+func NewRateLimitFetcher(fetcher Fetcher, interval time.Duration) *RateLimitFetcher {
+	return &RateLimitFetcher{
+		fetcher:  fetcher,
+		interval: interval,
+		bucket:   new(MemoryTokenBucket),
+	}
+}
+
+// TokenBucket hands out the times at which a RateLimitFetcher may
+// fetch from each host. The fetcher's own, a MemoryTokenBucket, only
+// spaces out its own fetches; one backed by a store shared by several
+// processes, such as Redis, would space out the fetches of a whole
+// fleet of crawlers, so that together they don't hammer a site.
+// Implementations must be safe for concurrent use.
+type TokenBucket interface {
+	// Take books the next free turn to fetch from host, leaving
+	// interval before the turn after it, and returns how long
+	// there is to wait for it. Turns are booked one at a time, in
+	// the order asked for. An error, such as from a store that
+	// can't be reached, fails the fetch.
+	Take(ctx context.Context, host string, interval time.Duration) (wait time.Duration, err error)
+}
+
+// MemoryTokenBucket is a TokenBucket held in memory.
+// The zero value is ready to use.
+type MemoryTokenBucket struct {
+	mu   sync.Mutex
+	next map[string]time.Time // earliest start of the next fetch per host
+}
+
+// Take implements TokenBucket.
+func (b *MemoryTokenBucket) Take(ctx context.Context, host string, interval time.Duration) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.next == nil {
+		b.next = make(map[string]time.Time)
+	}
+	now := time.Now()
+	start := b.next[host]
+	if start.Before(now) {
+		start = now
+	}
+	b.next[host] = start.Add(interval)
+	return start.Sub(now), nil
+}
+
+// SetTokenBucket has the fetcher take its turns to fetch from b,
+// rather than from a MemoryTokenBucket of its own. SetTokenBucket
+// must be called before the first fetch.
+func (f *RateLimitFetcher) SetTokenBucket(b TokenBucket) {
+	f.bucket = b
+}
+
+// SetJitter adds a random delay of between min and max to the
+// interval before each fetch of a host, so that the fetches are less
+// regular. The delays are drawn from r, or from the default source if
+// r is nil; passing a seeded r makes them repeatable. SetJitter must
+// be called before the first fetch.
+func (f *RateLimitFetcher) SetJitter(min, max time.Duration, r *rand.Rand) {
+	f.minJitter, f.maxJitter, f.rand = min, max, r
+}
+
+// SetHostDelay has the fetcher consult delay before each fetch for
+// the interval a host asks for, such as RobotsFetcher.CrawlDelay
+// gives. The longer of that and the fetcher's own interval is used.
+// SetHostDelay must be called before the first fetch.
+func (f *RateLimitFetcher) SetHostDelay(delay func(ctx context.Context, url string) time.Duration) {
+	f.hostDelay = delay
+}
+
+// jitter returns a random delay in [minJitter, maxJitter]. It must
+// be called with f.mu held, as f.rand is not safe for concurrent use.
+func (f *RateLimitFetcher) jitter() time.Duration {
+	n := int64(f.maxJitter - f.minJitter)
+	if n <= 0 {
+		return f.minJitter
+	}
+	if f.rand != nil {
+		return f.minJitter + time.Duration(f.rand.Int63n(n+1))
+	}
+	return f.minJitter + time.Duration(rand.Int63n(n+1))
+}
+
+func (f *RateLimitFetcher) wrapped() Fetcher {
+	return f.fetcher
+}
+
+// Fetch implements Fetcher. It waits for url's host to be free
+// before fetching, returning ctx.Err() if ctx is cancelled first.
+func (f *RateLimitFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, url))
+}
+
+// FetchPage implements PageFetcher, waiting like Fetch.
+func (f *RateLimitFetcher) FetchPage(ctx context.Context, url string) (*Page, error) {
+	interval := f.interval
+	if f.hostDelay != nil {
+		interval = max(interval, f.hostDelay(ctx, url))
+	}
+	f.mu.Lock()
+	interval += f.jitter()
+	f.mu.Unlock()
+	wait, err := f.bucket.Take(ctx, hostOf(url), interval)
+	if err != nil {
+		return nil, err
+	}
+	if err := sleepCtx(ctx, wait); err != nil {
+		return nil, err
+	}
+	return fetchPage(ctx, f.fetcher, url)
+}
+
+// sleepCtx pauses for d, returning early with ctx.Err() if ctx is
+// cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AdaptiveRateLimitFetcher is a Fetcher that spaces out requests to
+// each host like a RateLimitFetcher, but adapts the interval to how
+// the host is coping: when its responses slow down it backs off,
+// and when they speed up again it closes in, as TCP does with AIMD.
+// A response is slow if it took over twice as long as the fastest
+// seen from the host, or the fetch failed; each slow one doubles the
+// host's interval, up to max, and each other takes a tenth of the
+// range between min and max off it, down to min. It is safe for
+// concurrent use.
+type AdaptiveRateLimitFetcher struct {
+	fetcher  Fetcher
+	min, max time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*adaptiveHost
+}
+
+// adaptiveHost is what an AdaptiveRateLimitFetcher knows of a host.
+type adaptiveHost struct {
+	next     time.Time     // earliest start of the next fetch
+	interval time.Duration // between the starts of its fetches
+	fastest  time.Duration // quickest fetch so far, or 0 if none
+}
+
+// NewAdaptiveRateLimitFetcher returns an AdaptiveRateLimitFetcher that
+// wraps fetcher and waits between min and max between fetches from
+// the same host, starting at min.
+func NewAdaptiveRateLimitFetcher(fetcher Fetcher, min, max time.Duration) *AdaptiveRateLimitFetcher {
+	return &AdaptiveRateLimitFetcher{
+		fetcher: fetcher,
+		min:     min,
+		max:     max,
+		hosts:   make(map[string]*adaptiveHost),
+	}
+}
+
+// Interval returns the interval the fetcher now leaves between
+// fetches from host.
+func (f *AdaptiveRateLimitFetcher) Interval(host string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if h, ok := f.hosts[strings.ToLower(host)]; ok {
+		return h.interval
+	}
+	return f.min
+}
+
+func (f *AdaptiveRateLimitFetcher) wrapped() Fetcher {
+	return f.fetcher
+}
+
+// Fetch implements Fetcher. It waits for url's host to be free
+// before fetching, returning ctx.Err() if ctx is cancelled first.
+func (f *AdaptiveRateLimitFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, url))
+}
+
+// FetchPage implements PageFetcher, waiting like Fetch.
+func (f *AdaptiveRateLimitFetcher) FetchPage(ctx context.Context, url string) (*Page, error) {
+	host := hostOf(url)
+	if err := sleepCtx(ctx, f.reserve(host)); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	page, err := fetchPage(ctx, f.fetcher, url)
+	if !isSkip(err) && ctx.Err() == nil {
+		f.adapt(host, time.Since(start), err != nil)
+	}
+	return page, err
+}
+
+// reserve books the next free slot for host and returns how long to
+// wait for it.
+func (f *AdaptiveRateLimitFetcher) reserve(host string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, ok := f.hosts[host]
+	if !ok {
+		h = &adaptiveHost{interval: f.min}
+		f.hosts[host] = h
+	}
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(h.interval)
+	return start.Sub(now)
+}
+
+// adapt changes host's interval after a fetch from it that took
+// elapsed, and failed if failed is set.
+func (f *AdaptiveRateLimitFetcher) adapt(host string, elapsed time.Duration, failed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := f.hosts[host]
+	if !failed && (h.fastest == 0 || elapsed < h.fastest) {
+		h.fastest = elapsed
+	}
+	if failed || elapsed > 2*h.fastest {
+		// doubling nothing gets nowhere, so go to at least elapsed
+		h.interval = min(max(2*h.interval, elapsed), f.max)
+	} else {
+		h.interval = max(h.interval-(f.max-f.min)/10, f.min)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// timingFetcher records when each of its fetches starts, by host.
+type timingFetcher struct {
+	Fetcher
+
+	mu     sync.Mutex
+	starts map[string][]time.Time
+}
+
+func (f *timingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.mu.Lock()
+	if f.starts == nil {
+		f.starts = make(map[string][]time.Time)
+	}
+	host := hostOf(url)
+	f.starts[host] = append(f.starts[host], time.Now())
+	f.mu.Unlock()
+	return f.Fetcher.Fetch(ctx, url)
+}
+
+func TestRateLimitFetcher(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	graph := map[string][]string{
+		"http://a.example/1": nil, "http://a.example/2": nil, "http://a.example/3": nil,
+		"http://b.example/1": nil, "http://b.example/2": nil, "http://b.example/3": nil,
+	}
+	tf := &timingFetcher{Fetcher: crawltest.NewFakeFetcher(graph)}
+	f := NewRateLimitFetcher(tf, interval)
+
+	begin := time.Now()
+	var wg sync.WaitGroup
+	for u := range graph {
+		wg.Go(func() {
+			if _, _, err := f.Fetch(context.Background(), u); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	for host, starts := range tf.starts {
+		if len(starts) != 3 {
+			t.Errorf("%s: %d fetches, want 3", host, len(starts))
+			continue
+		}
+		slices.SortFunc(starts, time.Time.Compare)
+		if d := starts[0].Sub(begin); d >= interval {
+			t.Errorf("%s: first fetch waited %v", host, d)
+		}
+		// each fetch waits for its turn, interval after the last
+		// one's, however late the last one got going
+		for i := 1; i < len(starts); i++ {
+			if d := starts[i].Sub(begin); d < time.Duration(i)*interval {
+				t.Errorf("%s: fetch %d started %v in, want at least %v", host, i, d, time.Duration(i)*interval)
+			}
+		}
+	}
+	// the hosts wait in parallel, not for each other
+	if d := time.Since(begin); d >= 4*interval {
+		t.Errorf("6 fetches from 2 hosts took %v, want under %v", d, 4*interval)
+	}
+}
+
+func TestRateLimitFetcherJitter(t *testing.T) {
+	const min, max = 5 * time.Millisecond, 15 * time.Millisecond
+	jitters := func(seed int64) []time.Duration {
+		f := NewRateLimitFetcher(nil, time.Second)
+		f.SetJitter(min, max, rand.New(rand.NewSource(seed)))
+		var d []time.Duration
+		for range 100 {
+			d = append(d, f.jitter())
+		}
+		return d
+	}
+	got := jitters(1)
+	for _, d := range got {
+		if d < min || d > max {
+			t.Fatalf("jitter %v outside [%v, %v]", d, min, max)
+		}
+	}
+	if !slices.Equal(got, jitters(1)) {
+		t.Error("the same seed gave different jitter")
+	}
+	if slices.Equal(got, jitters(2)) {
+		t.Error("different seeds gave the same jitter")
+	}
+	if slices.Min(got) == slices.Max(got) {
+		t.Errorf("jitter is always %v", got[0])
+	}
+
+	// the jitter adds to the interval between fetches
+	tf := &timingFetcher{Fetcher: crawltest.NewFakeFetcher(map[string][]string{"http://a.example/": nil})}
+	f := NewRateLimitFetcher(tf, 10*time.Millisecond)
+	f.SetJitter(min, max, rand.New(rand.NewSource(1)))
+	begin := time.Now()
+	for range 3 {
+		if _, _, err := f.Fetch(context.Background(), "http://a.example/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	starts := tf.starts["a.example"]
+	if d := starts[2].Sub(begin); d < 2*(10*time.Millisecond+min) {
+		t.Errorf("third fetch started %v in, want at least %v", d, 2*(10*time.Millisecond+min))
+	}
+}
+
+func TestRateLimitFetcherCancel(t *testing.T) {
+	f := NewRateLimitFetcher(crawltest.NewFakeFetcher(map[string][]string{"http://a.example/": nil}), time.Hour)
+	if _, _, err := f.Fetch(context.Background(), "http://a.example/"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := f.Fetch(ctx, "http://a.example/"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch waiting for its turn: %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// sharedBucket is a TokenBucket standing in for one in a shared
+// store, counting the turns each host is given.
+type sharedBucket struct {
+	MemoryTokenBucket
+	err error // fails every Take, if set
+
+	mu    sync.Mutex
+	takes map[string]int
+}
+
+func (b *sharedBucket) Take(ctx context.Context, host string, interval time.Duration) (time.Duration, error) {
+	b.mu.Lock()
+	if b.takes == nil {
+		b.takes = make(map[string]int)
+	}
+	b.takes[host]++
+	b.mu.Unlock()
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.MemoryTokenBucket.Take(ctx, host, interval)
+}
+
+func TestTokenBucket(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	errStore := errors.New("store unreachable")
+	graph := map[string][]string{
+		"http://a.example/1": nil, "http://a.example/2": nil, "http://a.example/3": nil, "http://a.example/4": nil,
+		"http://b.example/1": nil, "http://b.example/2": nil,
+	}
+	tests := []struct {
+		name     string
+		crawlers int // sharing the bucket, each fetching every url
+		err      error
+	}{
+		{"one crawler", 1, nil},
+		{"a fleet of three", 3, nil},
+		{"failing store", 2, errStore},
+	}
+	for _, tt := range tests {
+		bucket := &sharedBucket{err: tt.err}
+		tf := &timingFetcher{Fetcher: crawltest.NewFakeFetcher(graph)}
+		begin := time.Now()
+		var wg sync.WaitGroup
+		for range tt.crawlers {
+			f := NewRateLimitFetcher(tf, interval)
+			f.SetTokenBucket(bucket)
+			for u := range graph {
+				wg.Go(func() {
+					if _, _, err := f.Fetch(context.Background(), u); !errors.Is(err, tt.err) {
+						t.Errorf("%s: Fetch(%s): %v, want %v", tt.name, u, err, tt.err)
+					}
+				})
+			}
+		}
+		wg.Wait()
+
+		for host, want := range map[string]int{"a.example": 4, "b.example": 2} {
+			want *= tt.crawlers
+			if n := bucket.takes[host]; n != want {
+				t.Errorf("%s: %d turns taken for %s, want %d", tt.name, n, host, want)
+			}
+			if tt.err != nil {
+				if n := len(tf.starts[host]); n != 0 {
+					t.Errorf("%s: %d fetches from %s without a turn", tt.name, n, host)
+				}
+				continue
+			}
+			// the crawlers space out their fetches together
+			starts := tf.starts[host]
+			slices.SortFunc(starts, time.Time.Compare)
+			if len(starts) != want {
+				t.Errorf("%s: %d fetches from %s, want %d", tt.name, len(starts), host, want)
+				continue
+			}
+			if d := starts[want-1].Sub(begin); d < time.Duration(want-1)*interval {
+				t.Errorf("%s: the last of %d fetches from %s started %v in, want at least %v", tt.name, want, host, d, time.Duration(want-1)*interval)
+			}
+		}
+	}
+}
+
+func TestAdaptiveRateLimitFetcher(t *testing.T) {
+	const min, max = time.Millisecond, 400 * time.Millisecond
+	type step struct {
+		latency time.Duration
+		fail    bool
+		want    int // how the interval moves: -1 down, 0 stays, 1 up
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"steady", []step{{10 * time.Millisecond, false, 0}, {10 * time.Millisecond, false, 0}, {10 * time.Millisecond, false, 0}}},
+		{"slowing down", []step{
+			{10 * time.Millisecond, false, 0},
+			{30 * time.Millisecond, false, 1},
+			{70 * time.Millisecond, false, 1},
+			{150 * time.Millisecond, false, 1},
+		}},
+		{"failing", []step{{10 * time.Millisecond, false, 0}, {0, true, 1}, {0, true, 1}}},
+		{"recovering", []step{
+			{10 * time.Millisecond, false, 0},
+			{100 * time.Millisecond, false, 1},
+			{10 * time.Millisecond, false, -1},
+			{10 * time.Millisecond, false, -1},
+			{10 * time.Millisecond, false, -1},
+			{10 * time.Millisecond, false, 0},
+		}},
+	}
+	for _, tt := range tests {
+		graph := make(map[string][]string)
+		for i := range tt.steps {
+			graph[fmt.Sprintf("http://a.example/%d", i)] = nil
+		}
+		fake := crawltest.NewFakeFetcher(graph)
+		for i, s := range tt.steps {
+			u := fmt.Sprintf("http://a.example/%d", i)
+			fake.SetDelay(u, s.latency)
+			if s.fail {
+				fake.SetError(u, errors.New("server error"))
+			}
+		}
+		tf := &timingFetcher{Fetcher: fake}
+		f := NewAdaptiveRateLimitFetcher(tf, min, max)
+
+		begin := time.Now()
+		intervals := []time.Duration{f.Interval("a.example")} // before each step
+		for i, s := range tt.steps {
+			f.Fetch(context.Background(), fmt.Sprintf("http://a.example/%d", i))
+			last, got := intervals[i], f.Interval("a.example")
+			if move := cmp.Compare(got, last); move != s.want {
+				t.Errorf("%s: step %d, %v latency: interval went from %v to %v, want it to move %d", tt.name, i, s.latency, last, got, s.want)
+			}
+			if s.want > 0 && got < s.latency {
+				t.Errorf("%s: step %d: interval %v, under the %v latency", tt.name, i, got, s.latency)
+			}
+			if got < min || got > max {
+				t.Errorf("%s: step %d: interval %v outside [%v, %v]", tt.name, i, got, min, max)
+			}
+			intervals = append(intervals, got)
+		}
+
+		// each fetch is booked at least the interval of the time
+		// after the one before was booked, so by the sum of those
+		// before it
+		var booked time.Duration
+		for i, start := range tf.starts["a.example"] {
+			if d := start.Sub(begin); d < booked {
+				t.Errorf("%s: fetch %d started %v in, want at least %v", tt.name, i, d, booked)
+			}
+			booked += intervals[i]
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FetchCall is one call of a RecordingFetcher.
+type FetchCall struct {
+	URL        string
+	Start, End time.Time // when the call was made and returned
+	Body       string
+	URLs       []string
+	Err        error
+}
+
+// RecordingFetcher is a Fetcher that records every fetch it makes
+// through another Fetcher, for tests that check what a crawl
+// fetched, and in what order or overlap. It is safe for concurrent
+// use.
+type RecordingFetcher struct {
+	fetcher Fetcher
+
+	mu    sync.Mutex
+	calls []FetchCall
+}
+
+// NewRecordingFetcher returns a RecordingFetcher that wraps fetcher.
+func NewRecordingFetcher(fetcher Fetcher) *RecordingFetcher {
+	return &RecordingFetcher{fetcher: fetcher}
+}
+
+func (f *RecordingFetcher) wrapped() Fetcher {
+	return f.fetcher
+}
+
+// Fetch implements Fetcher.
+func (f *RecordingFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, url))
+}
+
+// FetchPage implements PageFetcher.
+func (f *RecordingFetcher) FetchPage(ctx context.Context, url string) (*Page, error) {
+	call := FetchCall{URL: url, Start: time.Now()}
+	page, err := fetchPage(ctx, f.fetcher, url)
+	call.End = time.Now()
+	if page != nil {
+		call.Body, call.URLs = page.Body, slices.Clone(page.URLs)
+	}
+	call.Err = err
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+	return page, err
+}
+
+// Calls returns the calls made so far, in the order they returned.
+func (f *RecordingFetcher) Calls() []FetchCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := slices.Clone(f.calls)
+	for i := range calls {
+		calls[i].URLs = slices.Clone(calls[i].URLs)
+	}
+	return calls
+}
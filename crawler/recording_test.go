@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestRecordingFetcher(t *testing.T) {
+	graph := syntheticGraph(20, 2)
+	f := NewRecordingFetcher(crawltest.NewFakeFetcher(graph))
+
+	var wg sync.WaitGroup
+	for u := range graph {
+		wg.Go(func() { f.Fetch(context.Background(), u) })
+	}
+	wg.Go(func() { f.Fetch(context.Background(), "http://example.com/missing") })
+	wg.Wait()
+
+	calls := f.Calls()
+	if len(calls) != len(graph)+1 {
+		t.Fatalf("recorded %d calls, want %d", len(calls), len(graph)+1)
+	}
+	for _, c := range calls {
+		if c.Start.After(c.End) {
+			t.Errorf("%s: started %v, after it returned at %v", c.URL, c.Start, c.End)
+		}
+		if links, ok := graph[c.URL]; ok {
+			if c.Err != nil || c.Body != crawltest.Body(c.URL) || !slices.Equal(c.URLs, links) {
+				t.Errorf("%s: recorded %q, %q, %v", c.URL, c.Body, c.URLs, c.Err)
+			}
+		} else if !errors.Is(c.Err, crawltest.ErrNotFound) || c.Body != "" || c.URLs != nil {
+			t.Errorf("%s: recorded %q, %q, %v; want the not found error", c.URL, c.Body, c.URLs, c.Err)
+		}
+	}
+
+	// the calls are the recorder's own
+	i := slices.IndexFunc(calls, func(c FetchCall) bool { return c.Err == nil })
+	calls[i].URLs[0] = "changed"
+	if f.Calls()[i].URLs[0] == "changed" {
+		t.Error("changing the result of Calls changed the record")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// reportTopLinked is how many of the most linked pages WriteReport
+// lists.
+const reportTopLinked = 10
+
+// WriteReport writes a summary of a crawl to w, for reading in a
+// terminal at the end of a link audit: how many pages were fetched and
+// failed, how long the crawl took and how much it downloaded, the dead
+// links, and the pages most linked to from other pages. The figures
+// come from stats and the lists from results, so that the same crawl
+// always gives the same report, but for its duration.
+func WriteReport(w io.Writer, stats CrawlStats, results []CrawlResult) {
+	fmt.Fprintf(w, "Pages fetched:  %d\n", stats.PagesFetched)
+	fmt.Fprintf(w, "Pages failed:   %d\n", stats.PagesFailed)
+	fmt.Fprintf(w, "Pages skipped:  %d\n", stats.PagesSkipped)
+	fmt.Fprintf(w, "Links found:    %d\n", stats.TotalLinksFound)
+	fmt.Fprintf(w, "Bytes fetched:  %d\n", stats.BytesFetched)
+	fmt.Fprintf(w, "Duration:       %v\n", stats.Elapsed.Round(time.Millisecond))
+
+	dead := DeadLinks(results)
+	fmt.Fprintf(w, "\nDead links: %d\n", len(dead))
+	for _, u := range dead {
+		fmt.Fprintf(w, "  %s\n", u)
+	}
+
+	inbound := InboundCounts(BuildGraph(results))
+	top := sortedKeys(inbound)
+	slices.SortStableFunc(top, func(a, b string) int {
+		return cmp.Compare(inbound[b], inbound[a])
+	})
+	if len(top) > reportTopLinked {
+		top = top[:reportTopLinked]
+	}
+	fmt.Fprintf(w, "\nMost linked pages:\n")
+	for _, u := range top {
+		fmt.Fprintf(w, "  %5d  %s\n", inbound[u], u)
+	}
+}
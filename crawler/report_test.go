@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestWriteReport(t *testing.T) {
+	var stats CrawlStats
+	results := crawlRawData(t, CrawlConfig{Stats: &stats})
+	stats.Elapsed = 1234 * time.Millisecond // for a stable report
+	var buf bytes.Buffer
+	WriteReport(&buf, stats, results)
+	checkGolden(t, "report.golden", buf.Bytes())
+
+	// a bigger crawl lists only the top of the most linked pages
+	f := crawltest.NewFakeFetcher(syntheticGraph(50, 4))
+	c, err := Crawl(context.Background(), "http://example.com/p0", CrawlConfig{Fetcher: f, Depth: Unlimited})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []CrawlResult
+	for r := range c {
+		all = append(all, r)
+	}
+	buf.Reset()
+	WriteReport(&buf, CrawlStats{}, all)
+	_, linked, _ := strings.Cut(buf.String(), "Most linked pages:\n")
+	if n := strings.Count(linked, "\n"); n != reportTopLinked {
+		t.Errorf("the report lists %d most linked pages, want %d:\n%s", n, reportTopLinked, linked)
+	}
+}
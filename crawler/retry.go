@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryFetcher is a Fetcher that retries failed fetches. It wraps
+// another Fetcher and, when a fetch fails, tries again up to a total
+// of attempts times, waiting base, 2*base, 4*base and so on between
+// tries. Skips such as *AlreadyFetchedError are final and are never
+// retried, and neither are HTTP client errors (4xx) or failures caused
+// by ctx ending.
+//
+// The wrapped Fetcher must allow a url to be fetched again after a
+// failed attempt.
+type RetryFetcher struct {
+	fetcher  Fetcher
+	attempts int
+	base     time.Duration
+
+	onRetry func(url string, attempt int, err error) // see SetOnRetry
+}
+
+// NewRetryFetcher returns a RetryFetcher that wraps fetcher, trying
+// each url up to attempts times with exponential backoff starting at
+// base.
+func NewRetryFetcher(fetcher Fetcher, attempts int, base time.Duration) *RetryFetcher {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &RetryFetcher{
+		fetcher:  fetcher,
+		attempts: attempts,
+		base:     base,
+	}
+}
+
+// SetOnRetry has the fetcher call onRetry each time a fetch fails and
+// is to be tried again, before waiting to, with the number of the
+// attempt that failed, starting at 1, and its error; for logging or
+// counting retries. Fetches that fail for good don't call it. It may
+// be called from many goroutines at once. SetOnRetry must be called
+// before the first fetch.
+func (f *RetryFetcher) SetOnRetry(onRetry func(url string, attempt int, err error)) {
+	f.onRetry = onRetry
+}
+
+func (f *RetryFetcher) wrapped() Fetcher {
+	return f.fetcher
+}
+
+// Fetch implements Fetcher.
+func (f *RetryFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, url))
+}
+
+// FetchPage implements PageFetcher.
+func (f *RetryFetcher) FetchPage(ctx context.Context, url string) (*Page, error) {
+	delay := f.base
+	for attempt := 1; ; attempt++ {
+		page, err := fetchPage(ctx, f.fetcher, url)
+		if err == nil || !retryable(err) || ctx.Err() != nil || attempt == f.attempts {
+			return page, err
+		}
+		if f.onRetry != nil {
+			f.onRetry(url, attempt, err)
+		}
+		if err := sleepCtx(ctx, delay); err != nil {
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// retryable reports whether a fetch that failed with err might
+// succeed if tried again.
+func retryable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) && se.StatusCode >= 400 && se.StatusCode < 500 {
+		return false
+	}
+	return !isSkip(err)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// flakyFetcher fails with err the first fails times it is called,
+// then fetches from the fake fetcher it wraps.
+type flakyFetcher struct {
+	*crawltest.FakeFetcher
+	fails, calls int
+	err          error
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	f.calls++
+	if f.calls <= f.fails {
+		return "", nil, f.err
+	}
+	return f.FakeFetcher.Fetch(ctx, url)
+}
+
+func TestRetryFetcher(t *testing.T) {
+	errFlaky := errors.New("connection reset")
+	errSkip := &AlreadyFetchedError{"http://example.com/"}
+	tests := []struct {
+		name      string
+		fails     int
+		err       error
+		wantCalls int
+		wantErr   error
+	}{
+		{"first try", 0, errFlaky, 1, nil},
+		{"third try", 2, errFlaky, 3, nil},
+		{"gives up", 3, errFlaky, 3, errFlaky},
+		{"client error", 3, &StatusError{URL: "http://example.com/", StatusCode: 404}, 1, ErrNotFound},
+		{"skip", 3, errSkip, 1, errSkip},
+	}
+	for _, tt := range tests {
+		flaky := &flakyFetcher{
+			FakeFetcher: crawltest.NewFakeFetcher(map[string][]string{"http://example.com/": nil}),
+			fails:       tt.fails,
+			err:         tt.err,
+		}
+		var retries int
+		f := NewRetryFetcher(flaky, 3, time.Millisecond)
+		f.SetOnRetry(func(string, int, error) { retries++ })
+
+		body, _, err := f.Fetch(context.Background(), "http://example.com/")
+		if tt.wantErr == nil && (err != nil || body != crawltest.Body("http://example.com/")) {
+			t.Errorf("%s: Fetch = %q, %v; want the page", tt.name, body, err)
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Fetch error %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if flaky.calls != tt.wantCalls || retries != tt.wantCalls-1 {
+			t.Errorf("%s: %d attempts, %d retries; want %d attempts", tt.name, flaky.calls, retries, tt.wantCalls)
+		}
+	}
+}
+
+// errorsFetcher fails its nth call with errs[n], if there is one,
+// then fetches from the fake fetcher it wraps, noting when each call
+// was made.
+type errorsFetcher struct {
+	*crawltest.FakeFetcher
+	errs  []error
+	calls []time.Time
+}
+
+func (f *errorsFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	n := len(f.calls)
+	f.calls = append(f.calls, time.Now())
+	if n < len(f.errs) {
+		return "", nil, f.errs[n]
+	}
+	return f.FakeFetcher.Fetch(ctx, url)
+}
+
+func TestOnRetry(t *testing.T) {
+	const u = "http://example.com/"
+	reset, refused, timeout := errors.New("connection reset"), errors.New("connection refused"), errors.New("timeout")
+	unavailable := &StatusError{URL: u, StatusCode: 503}
+	type retry struct {
+		attempt int
+		err     error
+	}
+	tests := []struct {
+		name     string
+		attempts int
+		errs     []error
+		want     []retry
+	}{
+		{"no failures", 4, nil, nil},
+		{"one failure", 4, []error{reset}, []retry{{1, reset}}},
+		{"each error in turn", 4, []error{reset, refused, unavailable}, []retry{{1, reset}, {2, refused}, {3, unavailable}}},
+		// the last attempt isn't retried, so doesn't call the hook
+		{"gives up", 3, []error{reset, refused, timeout}, []retry{{1, reset}, {2, refused}}},
+		{"not retryable", 4, []error{reset, &StatusError{URL: u, StatusCode: 404}}, []retry{{1, reset}}},
+	}
+	for _, tt := range tests {
+		ef := &errorsFetcher{FakeFetcher: crawltest.NewFakeFetcher(map[string][]string{u: nil}), errs: tt.errs}
+		const base = 5 * time.Millisecond
+		f := NewRetryFetcher(ef, tt.attempts, base)
+		var got []retry
+		var called []time.Time
+		f.SetOnRetry(func(url string, attempt int, err error) {
+			if url != u {
+				t.Errorf("%s: OnRetry(%q, ...), want %q", tt.name, url, u)
+			}
+			got = append(got, retry{attempt, err})
+			called = append(called, time.Now())
+		})
+		f.Fetch(context.Background(), u)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: OnRetry called with %v, want %v", tt.name, got, tt.want)
+		}
+		// each call comes before the backoff to the next attempt
+		delay := base
+		for i, at := range called {
+			if i+1 >= len(ef.calls) {
+				break
+			}
+			if wait := ef.calls[i+1].Sub(at); wait < delay {
+				t.Errorf("%s: attempt %d came %v after OnRetry, want at least %v", tt.name, i+2, wait, delay)
+			}
+			delay *= 2
+		}
+	}
+}
+
+func TestRetryFetcherCancel(t *testing.T) {
+	flaky := &flakyFetcher{FakeFetcher: crawltest.NewFakeFetcher(nil), fails: 3, err: errors.New("connection reset")}
+	f := NewRetryFetcher(flaky, 3, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := f.Fetch(ctx, "http://example.com/"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch cancelled during its backoff: %v, want context.DeadlineExceeded", err)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("%d attempts, want 1", flaky.calls)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DisallowedByRobotsError is returned, as *DisallowedByRobotsError,
+// when a url may not be fetched because of the robots.txt rules of
+// its host.
+type DisallowedByRobotsError struct {
+	url string
+}
+
+func (e DisallowedByRobotsError) Error() string {
+	return fmt.Sprintf("Disallowed by robots.txt %v", e.url)
+}
+
+func (e DisallowedByRobotsError) skip() {}
+
+// RobotsFetcher is a Fetcher that honors robots.txt. It wraps
+// another Fetcher and only passes on urls that the robots.txt of
+// their host allows for its user agent. Each host's robots.txt is
+// fetched once, on the first request to that host; if that request's
+// context is done before it has been read, the next request fetches
+// it again.
+type RobotsFetcher struct {
+	fetcher   Fetcher
+	client    *http.Client
+	userAgent string
+
+	mu    sync.Mutex
+	hosts map[string]*hostRobots
+}
+
+// hostRobots holds the rules for one host, loaded at most once.
+type hostRobots struct {
+	mu     sync.Mutex
+	loaded bool
+	rules  robotsRules
+}
+
+// NewRobotsFetcher returns a RobotsFetcher that wraps fetcher,
+// downloading robots.txt files with client and obeying the rules
+// that apply to userAgent. If client is nil, http.DefaultClient is
+// used.
+func NewRobotsFetcher(fetcher Fetcher, client *http.Client, userAgent string) *RobotsFetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RobotsFetcher{
+		fetcher:   fetcher,
+		client:    client,
+		userAgent: userAgent,
+		hosts:     make(map[string]*hostRobots),
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the client
+// robots.txt files are downloaded with, and of the wrapped fetcher.
+func (f *RobotsFetcher) CloseIdleConnections() {
+	f.client.CloseIdleConnections()
+	closeIdleConnections(f.fetcher)
+}
+
+// Fetch implements Fetcher.
+func (f *RobotsFetcher) Fetch(ctx context.Context, rawurl string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, rawurl))
+}
+
+// FetchPage implements PageFetcher.
+func (f *RobotsFetcher) FetchPage(ctx context.Context, rawurl string) (*Page, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := f.rulesFor(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if !rules.allowed(u.RequestURI()) {
+		return nil, &DisallowedByRobotsError{rawurl}
+	}
+	return fetchPage(ctx, f.fetcher, rawurl)
+}
+
+// CrawlDelay returns the Crawl-delay that the robots.txt of rawurl's
+// host asks of the fetcher's user agent, or zero if it doesn't give
+// one or can't be fetched before ctx is done. It fetches the
+// robots.txt if this is the first request to the host. Passed to a
+// RateLimitFetcher's SetHostDelay it makes the rate limiter honor
+// the delay.
+func (f *RobotsFetcher) CrawlDelay(ctx context.Context, rawurl string) time.Duration {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return 0
+	}
+	rules, _ := f.rulesFor(ctx, u)
+	return rules.delay
+}
+
+// rulesFor returns the robots.txt rules for the host of u, fetching
+// them if this is the first request to that host. It returns ctx's
+// error if ctx is done before they are loaded, and doesn't keep the
+// failure, so that a fetch with a live context tries again.
+func (f *RobotsFetcher) rulesFor(ctx context.Context, u *url.URL) (robotsRules, error) {
+	key := u.Scheme + "://" + u.Host
+	f.mu.Lock()
+	h, ok := f.hosts[key]
+	if !ok {
+		h = &hostRobots{}
+		f.hosts[key] = h
+	}
+	f.mu.Unlock()
+
+	// the other requests to the host wait for the first to load
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.loaded {
+		rules, err := f.load(ctx, key+"/robots.txt")
+		if err != nil {
+			return robotsRules{}, err
+		}
+		h.rules, h.loaded = rules, true
+	}
+	return h.rules, nil
+}
+
+// load downloads and parses the robots.txt at robotsURL. A robots.txt
+// that can't be fetched is treated as allowing everything, unless it
+// is because ctx is done, when ctx's error is returned.
+func (f *RobotsFetcher) load(ctx context.Context, robotsURL string) (robotsRules, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return robotsRules{}, nil
+	}
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return robotsRules{}, ctx.Err()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return robotsRules{}, nil
+	}
+	rules := parseRobots(resp.Body, f.userAgent)
+	if err := ctx.Err(); err != nil {
+		return robotsRules{}, err // the file may have been cut short
+	}
+	return rules, nil
+}
+
+// robotsRule is a single Allow or Disallow line.
+type robotsRule struct {
+	pattern string
+	allow   bool
+	re      *regexp.Regexp // for patterns using '*' or '$'
+}
+
+func newRobotsRule(pattern string, allow bool) robotsRule {
+	rule := robotsRule{pattern: pattern, allow: allow}
+	if strings.ContainsAny(pattern, "*$") {
+		anchored := strings.HasSuffix(pattern, "$")
+		p := strings.TrimSuffix(pattern, "$")
+		expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*")
+		if anchored {
+			expr += "$"
+		}
+		rule.re = regexp.MustCompile(expr)
+	}
+	return rule
+}
+
+// matches reports whether the rule applies to path. A '*' in the
+// pattern matches any sequence of characters and a trailing '$'
+// anchors it at the end of path; otherwise the pattern matches every
+// path it is a prefix of.
+func (r robotsRule) matches(path string) bool {
+	if r.re != nil {
+		return r.re.MatchString(path)
+	}
+	return strings.HasPrefix(path, r.pattern)
+}
+
+// robotsRules are the rules of a robots.txt group that apply to one
+// user agent. The zero value allows everything.
+type robotsRules struct {
+	rules []robotsRule
+	delay time.Duration // from Crawl-delay; zero if none
+}
+
+// allowed reports whether path may be fetched. As in the robots.txt
+// standard (RFC 9309), the longest matching rule wins, and Allow
+// wins a tie.
+func (r robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if !rule.matches(path) {
+			continue
+		}
+		n := len(rule.pattern)
+		if n > best || n == best && rule.allow {
+			best, allow = n, rule.allow
+		}
+	}
+	return allow
+}
+
+// parseRobots reads a robots.txt file and returns the rules of the
+// group that applies to userAgent. A group naming the agent is
+// preferred over the catch-all "*" group. As in RFC 9309, a group
+// names the agent if one of its user-agent lines gives a prefix of
+// the agent's product token, the part before any '/', ignoring case;
+// of several such groups those giving the longest prefix apply,
+// combined.
+func parseRobots(r io.Reader, userAgent string) robotsRules {
+	agent := productToken(userAgent)
+
+	var specific, wildcard robotsRules
+	best := 0  // length of the longest prefix of agent named so far
+	group := 0 // length of the prefix the current group names
+	var inSpecific, inWildcard bool
+	// a group is one or more user-agent lines followed by rules
+	lastWasAgent := false
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !lastWasAgent {
+				group, inWildcard = 0, false
+			}
+			lastWasAgent = true
+			v := productToken(value)
+			if v == "*" {
+				inWildcard = true
+			} else if v != "" && strings.HasPrefix(agent, v) && len(v) > group {
+				group = len(v)
+			}
+			if group > best {
+				// a longer match replaces the groups before
+				specific, best = robotsRules{}, group
+			}
+			inSpecific = group > 0 && group == best
+		case "allow", "disallow":
+			lastWasAgent = false
+			if value == "" {
+				// an empty Disallow allows everything
+				continue
+			}
+			rule := newRobotsRule(value, key == "allow")
+			if inSpecific {
+				specific.rules = append(specific.rules, rule)
+			}
+			if inWildcard {
+				wildcard.rules = append(wildcard.rules, rule)
+			}
+		case "crawl-delay":
+			lastWasAgent = false
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs < 0 {
+				continue
+			}
+			d := time.Duration(secs * float64(time.Second))
+			if inSpecific {
+				specific.delay = d
+			}
+			if inWildcard {
+				wildcard.delay = d
+			}
+		default:
+			lastWasAgent = false
+		}
+	}
+
+	if best > 0 {
+		return specific
+	}
+	return wildcard
+}
+
+// productToken returns the product token of a user agent, lower
+// case: the part before any '/' or white space.
+func productToken(userAgent string) string {
+	agent := strings.ToLower(strings.TrimSpace(userAgent))
+	if i := strings.IndexAny(agent, "/ \t"); i >= 0 {
+		agent = agent[:i]
+	}
+	return agent
+}
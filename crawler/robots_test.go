@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// newRobotsServer returns a server whose robots.txt is robots, and
+// which answers every other request with a page.
+func newRobotsServer(t *testing.T, robots string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, robots)
+			return
+		}
+		fmt.Fprint(w, "page")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRobotsFetcher(t *testing.T) {
+	srv := newRobotsServer(t, "User-agent: *\nDisallow: /private/\n")
+	fake := crawltest.NewFakeFetcher(map[string][]string{
+		srv.URL + "/":             {srv.URL + "/private/a", srv.URL + "/public/b"},
+		srv.URL + "/private/a":    nil,
+		srv.URL + "/public/b":     nil,
+		srv.URL + "/private/a/bc": nil,
+	})
+	f := NewRobotsFetcher(fake, srv.Client(), "testbot")
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"/", true},
+		{"/public/b", true},
+		{"/private/a", false},
+		{"/private/a/bc", false},
+	}
+	for _, tt := range tests {
+		_, _, err := f.Fetch(context.Background(), srv.URL+tt.url)
+		var de *DisallowedByRobotsError
+		if got := !errors.As(err, &de); got != tt.allowed {
+			t.Errorf("Fetch(%q): allowed %v, want %v (err %v)", tt.url, got, tt.allowed, err)
+		}
+		if !tt.allowed && fake.Fetches(srv.URL+tt.url) != 0 {
+			t.Errorf("Fetch(%q) fetched a disallowed page", tt.url)
+		}
+	}
+
+	// a crawl skips the disallowed page quietly
+	var stats CrawlStats
+	results, err := Crawl(context.Background(), srv.URL+"/", CrawlConfig{Fetcher: f, Depth: 1, Stats: &stats})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.URL, r.Err)
+		}
+		got = append(got, strings.TrimPrefix(r.URL, srv.URL))
+	}
+	if len(got) != 2 || stats.PagesSkipped != 1 {
+		t.Errorf("crawled %q with %d skipped, want / and /public/b with 1 skipped", got, stats.PagesSkipped)
+	}
+}
+
+func TestRobotsFetcherCancelledLoad(t *testing.T) {
+	srv := newRobotsServer(t, "User-agent: *\nDisallow: /private/\n")
+	f := NewRobotsFetcher(crawltest.NewFakeFetcher(nil), srv.Client(), "testbot")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := f.Fetch(ctx, srv.URL+"/private/a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Fetch with a cancelled context: %v, want context.Canceled", err)
+	}
+	if d := f.CrawlDelay(ctx, srv.URL+"/"); d != 0 {
+		t.Errorf("CrawlDelay with a cancelled context: %v, want 0", d)
+	}
+
+	// the failed load is not kept as "allow everything"
+	_, _, err := f.Fetch(context.Background(), srv.URL+"/private/a")
+	var de *DisallowedByRobotsError
+	if !errors.As(err, &de) {
+		t.Errorf("Fetch after a cancelled load: %v, want a *DisallowedByRobotsError", err)
+	}
+}
+
+func TestParseRobotsUserAgent(t *testing.T) {
+	const robots = `
+User-agent: *
+Disallow: /all/
+
+User-agent: TestBot
+Disallow: /test/
+Crawl-delay: 2
+
+User-agent: testbot-news
+User-agent: other
+Disallow: /news/
+
+User-agent: testbot-news/2.0
+Disallow: /news2/
+`
+	tests := []struct {
+		agent    string
+		disallow []string
+		delay    time.Duration
+	}{
+		{"testbot", []string{"/test/"}, 2 * time.Second},
+		{"TESTBOT", []string{"/test/"}, 2 * time.Second},
+		{"TestBot/1.0 (+http://example.com/bot)", []string{"/test/"}, 2 * time.Second},
+		{"testbot-images", []string{"/test/"}, 2 * time.Second},
+		{"TestBot-News", []string{"/news/", "/news2/"}, 0},
+		{"testbo", []string{"/all/"}, 0},
+		{"crawler", []string{"/all/"}, 0},
+		{"", []string{"/all/"}, 0},
+	}
+	for _, tt := range tests {
+		rules := parseRobots(strings.NewReader(robots), tt.agent)
+		var got []string
+		for _, r := range rules.rules {
+			got = append(got, r.pattern)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.disallow, " ") || rules.delay != tt.delay {
+			t.Errorf("parseRobots for %q: disallow %q, delay %v; want %q, %v",
+				tt.agent, got, rules.delay, tt.disallow, tt.delay)
+		}
+	}
+}
+
+func TestRobotsCrawlDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		robots   string
+		interval time.Duration
+		want     time.Duration
+	}{
+		{"robots delay", "User-agent: *\nCrawl-delay: 1\n", 10 * time.Millisecond, time.Second},
+		{"own interval longer", "User-agent: *\nCrawl-delay: 0.01\n", 100 * time.Millisecond, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		srv := newRobotsServer(t, tt.robots)
+		tf := &timingFetcher{Fetcher: crawltest.NewFakeFetcher(map[string][]string{
+			srv.URL + "/a": nil,
+			srv.URL + "/b": nil,
+		})}
+		robots := NewRobotsFetcher(tf, srv.Client(), "testbot")
+		f := NewRateLimitFetcher(robots, tt.interval)
+		f.SetHostDelay(robots.CrawlDelay)
+
+		begin := time.Now()
+		for _, path := range []string{"/a", "/b"} {
+			if _, _, err := f.Fetch(context.Background(), srv.URL+path); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		starts := tf.starts[hostOf(srv.URL)]
+		if len(starts) != 2 {
+			t.Fatalf("%s: %d fetches, want 2", tt.name, len(starts))
+		}
+		// the longer of the two intervals, and no more
+		if d := starts[1].Sub(begin); d < tt.want || d > tt.want+500*time.Millisecond {
+			t.Errorf("%s: second fetch started %v in, want just over %v", tt.name, d, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// ResultSink receives the results of a crawl given it as its Sink, in
+// place of the results channel, so that they can go straight to a
+// file or a database, say, without the caller reading the channel.
+type ResultSink interface {
+	// Write takes one result. The crawl never calls it from two
+	// goroutines at once, nor after Close. An error stops the
+	// crawl.
+	Write(CrawlResult) error
+
+	// Close is called once, when the crawl has finished, after the
+	// last Write.
+	Close() error
+}
+
+// SinkError is returned, as *SinkError, by Crawler.Run when a crawl's
+// Sink failed to write a result or to close. It wraps the sink's
+// error.
+type SinkError struct {
+	err error
+}
+
+func (e SinkError) Error() string {
+	return fmt.Sprintf("Result sink failed: %v", e.err)
+}
+
+func (e SinkError) Unwrap() error {
+	return e.err
+}
+
+// ChannelSink is a ResultSink that sends results on a channel, as a
+// crawl with no Sink does, and closes the channel when the crawl
+// finishes. Its reader must read every result, since Write can't give
+// up on a send when the crawl is cancelled.
+type ChannelSink chan<- CrawlResult
+
+// Write implements ResultSink.
+func (s ChannelSink) Write(r CrawlResult) error {
+	s <- r
+	return nil
+}
+
+// Close implements ResultSink.
+func (s ChannelSink) Close() error {
+	close(s)
+	return nil
+}
+
+// SliceSink is a ResultSink that collects results in memory.
+// The zero value is an empty sink ready to use.
+type SliceSink struct {
+	mu      sync.Mutex
+	results []CrawlResult
+}
+
+// Write implements ResultSink.
+func (s *SliceSink) Write(r CrawlResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, r)
+	return nil
+}
+
+// Close implements ResultSink. It does nothing.
+func (s *SliceSink) Close() error {
+	return nil
+}
+
+// Results returns a copy of the results written so far, in the order
+// they were written.
+func (s *SliceSink) Results() []CrawlResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.results)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// recordingSink is a ResultSink that counts the writes of each url,
+// checking the crawl keeps to the interface's promises.
+type recordingSink struct {
+	t       *testing.T
+	writing atomic.Bool
+	closed  atomic.Int32
+	fail    int // fail the write after this many, if positive
+
+	mu     sync.Mutex
+	writes map[string]int
+	n      int
+}
+
+func (s *recordingSink) Write(r CrawlResult) error {
+	if !s.writing.CompareAndSwap(false, true) {
+		s.t.Error("Write called from two goroutines at once")
+	}
+	defer s.writing.Store(false)
+	if s.closed.Load() > 0 {
+		s.t.Errorf("Write(%s) after Close", r.URL)
+	}
+	time.Sleep(time.Millisecond) // so overlapping writes would overlap
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 && s.n == s.fail {
+		return errWriteFailed
+	}
+	if s.writes == nil {
+		s.writes = make(map[string]int)
+	}
+	s.writes[r.URL]++
+	s.n++
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed.Add(1)
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	graph := syntheticGraph(50, 4)
+	tests := []struct {
+		name string
+		cfg  CrawlConfig
+	}{
+		{"default", CrawlConfig{}},
+		{"sequential", CrawlConfig{Sequential: true}},
+		{"16 workers", CrawlConfig{MaxWorkers: 16}},
+		{"max pages", CrawlConfig{MaxWorkers: 8, MaxPages: 20}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		sink := &recordingSink{t: t}
+		cfg := tt.cfg
+		cfg.Fetcher, cfg.Depth, cfg.Sink = f, Unlimited, sink
+		cr, err := NewCrawler([]string{"http://example.com/p0"}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cr.Run(context.Background()); err != nil {
+			t.Errorf("%s: Run: %v", tt.name, err)
+		}
+		// every page fetched, exactly once
+		for u := range graph {
+			if n, w := f.Fetches(u), sink.writes[u]; n != w || w > 1 {
+				t.Errorf("%s: %s fetched %d times, written %d times", tt.name, u, n, w)
+			}
+		}
+		if want := cmp.Or(tt.cfg.MaxPages, len(graph)); sink.n != want {
+			t.Errorf("%s: %d writes, want %d", tt.name, sink.n, want)
+		}
+		if n := sink.closed.Load(); n != 1 {
+			t.Errorf("%s: closed %d times, want once", tt.name, n)
+		}
+	}
+}
+
+func TestSinkError(t *testing.T) {
+	f := crawltest.NewFakeFetcher(syntheticGraph(50, 4))
+	sink := &recordingSink{t: t, fail: 5}
+	cr, err := NewCrawler([]string{"http://example.com/p0"}, CrawlConfig{Fetcher: f, Depth: Unlimited, Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cr.Run(context.Background())
+	var se *SinkError
+	if !errors.As(err, &se) || !errors.Is(err, errWriteFailed) {
+		t.Errorf("Run with a failing sink: %v, want a *SinkError wrapping the write's error", err)
+	}
+	if sink.n != 5 || sink.closed.Load() != 1 {
+		t.Errorf("%d writes, closed %d times; want 5 and then the Close", sink.n, sink.closed.Load())
+	}
+}
+
+func TestSliceAndChannelSinks(t *testing.T) {
+	want := resultURLs(crawlRawData(t, CrawlConfig{Sequential: true}))
+
+	var slice SliceSink
+	c := make(chan CrawlResult)
+	var fromChannel []CrawlResult
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range c {
+			fromChannel = append(fromChannel, r)
+		}
+	}()
+	for _, sink := range []ResultSink{&slice, ChannelSink(c)} {
+		f := crawltest.NewFakeFetcher(rawDataGraph())
+		cr, err := NewCrawler([]string{"https://golang.org/"}, CrawlConfig{Fetcher: f, Depth: 4, Sequential: true, Sink: sink})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cr.Run(context.Background()); err != nil {
+			t.Errorf("%T: Run: %v", sink, err)
+		}
+	}
+	<-done // the ChannelSink closed c
+
+	if got := resultURLs(slice.Results()); !slices.Equal(got, want) {
+		t.Errorf("SliceSink has %q, want %q", got, want)
+	}
+	if got := resultURLs(fromChannel); !slices.Equal(got, want) {
+		t.Errorf("ChannelSink sent %q, want %q", got, want)
+	}
+}
+
+// resultURLs returns the urls of results, in order.
+func resultURLs(results []CrawlResult) []string {
+	var urls []string
+	for _, r := range results {
+		urls = append(urls, r.URL)
+	}
+	return urls
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// sitemapNS is the XML namespace of the sitemaps.org protocol.
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// WriteSitemap writes an XML sitemap listing the url of each page in
+// results that was fetched successfully to w. Urls are sorted and
+// listed once each, so the same pages always produce the same sitemap.
+func WriteSitemap(w io.Writer, results []CrawlResult) error {
+	results = slices.Clone(results)
+	sortResults(results)
+	set := sitemapURLSet{NS: sitemapNS}
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		if n := len(set.URLs); n == 0 || set.URLs[n-1].Loc != r.URL {
+			set.URLs = append(set.URLs, sitemapURL{Loc: r.URL})
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(set); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ParseSitemap reads an XML sitemap from r and returns the urls it
+// lists, in order.
+func ParseSitemap(r io.Reader) ([]string, error) {
+	var set sitemapURLSet
+	if err := xml.NewDecoder(r).Decode(&set); err != nil {
+		return nil, fmt.Errorf("parsing sitemap: %w", err)
+	}
+	var urls []string
+	for _, u := range set.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+	return urls, nil
+}
+
+// FetchSitemap fetches the XML sitemap at rawurl with f, sending
+// its headers and following its redirect policy, and returns the
+// urls it lists.
+func FetchSitemap(ctx context.Context, f *HTTPFetcher, rawurl string) ([]string, error) {
+	req, err := f.newRequest(ctx, http.MethodGet, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.clientFor(rawurl).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{URL: rawurl, StatusCode: resp.StatusCode}
+	}
+	b, err := f.readBody(rawurl, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSitemap(bytes.NewReader(b))
+}
+
+// CrawlSitemap fetches each url listed in the XML sitemap read from
+// r, as configured by cfg, without following any links: it is
+// CrawlSeeds with the sitemap's urls as seeds and a Depth of zero.
+func CrawlSitemap(ctx context.Context, r io.Reader, cfg CrawlConfig) (<-chan CrawlResult, error) {
+	seeds, err := ParseSitemap(r)
+	if err != nil {
+		return nil, fmt.Errorf("crawl: %w", err)
+	}
+	cfg.Depth = 0
+	return CrawlSeeds(ctx, seeds, cfg)
+}
+
+// sitemapLocs returns the urls listed by page, fetched from rawurl, if
+// it is an XML sitemap: one whose Content-Type is XML, or whose url
+// ends in .xml when it has none, and which parses as a sitemap.
+func sitemapLocs(rawurl string, page *Page) ([]string, bool) {
+	if page.ContentType != "" {
+		mediatype, _, _ := mime.ParseMediaType(page.ContentType)
+		if mediatype != "application/xml" && mediatype != "text/xml" {
+			return nil, false
+		}
+	} else if u, err := url.Parse(rawurl); err != nil || path.Ext(u.Path) != ".xml" {
+		return nil, false
+	}
+	locs, err := ParseSitemap(strings.NewReader(page.Body))
+	return locs, err == nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with the golden file testdata/name, or
+// rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s:\n%s\nwant\n%s", path, got, want)
+	}
+}
+
+func TestWriteSitemap(t *testing.T) {
+	results := crawlRawData(t, CrawlConfig{})
+	var buf bytes.Buffer
+	if err := WriteSitemap(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "sitemap.golden", buf.Bytes())
+
+	// the same pages in another order give the same sitemap
+	slices.Reverse(results)
+	results = append(results, results[0])
+	var again bytes.Buffer
+	if err := WriteSitemap(&again, results); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Errorf("reordered results gave a different sitemap:\n%s", again.Bytes())
+	}
+}
+
+func TestWriteSitemapEscaping(t *testing.T) {
+	const u = "https://example.com/search?q=a&lang=<en>"
+	var buf bytes.Buffer
+	if err := WriteSitemap(&buf, []CrawlResult{{URL: u}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("q=a&amp;lang=&lt;en&gt;")) {
+		t.Errorf("url not escaped:\n%s", buf.Bytes())
+	}
+	urls, err := ParseSitemap(&buf)
+	if err != nil || !slices.Equal(urls, []string{u}) {
+		t.Errorf("ParseSitemap = %q, %v; want %q", urls, err, u)
+	}
+}
+
+const testSitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://golang.org/</loc></url>
+  <url>
+    <loc>
+      https://golang.org/pkg/fmt/
+    </loc>
+  </url>
+  <url><loc>https://golang.org/pkg/os/</loc><lastmod>2009-11-10</lastmod></url>
+  <url><loc></loc></url>
+</urlset>
+`
+
+func TestCrawlSitemap(t *testing.T) {
+	want := []string{"https://golang.org/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"}
+	f := crawltest.NewFakeFetcher(rawDataGraph())
+	results, err := CrawlSitemap(context.Background(), strings.NewReader(testSitemap), CrawlConfig{Fetcher: f, Depth: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for r := range results {
+		got = append(got, r.URL)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("crawled %q, want %q", got, want)
+	}
+	// the listed pages' links aren't followed
+	for u := range rawDataGraph() {
+		listed := 0
+		if slices.Contains(want, u) {
+			listed = 1
+		}
+		if n := f.Fetches(u); n != listed {
+			t.Errorf("%s fetched %d times, want %d", u, n, listed)
+		}
+	}
+
+	if _, err := CrawlSitemap(context.Background(), strings.NewReader("<urlset>"), CrawlConfig{Fetcher: f}); err == nil {
+		t.Error("CrawlSitemap of a truncated sitemap succeeded")
+	}
+}
+
+func TestFollowSitemaps(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root:                  {root + "about", root + "sitemap.xml"},
+		root + "about":        nil,
+		root + "sitemap.xml":  nil,
+		root + "hidden":       {root + "hidden/child"},
+		root + "hidden/child": nil,
+		root + "deep":         nil,
+	}
+	bodies := map[string]string{
+		root + "sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/hidden</loc></url>
+  <url><loc>http://example.com/about</loc></url>
+  <url><loc>http://example.com/deep</loc></url>
+</urlset>`,
+	}
+	tests := []struct {
+		name   string
+		follow bool
+		depth  int
+		want   []string // besides the seed and the sitemap
+	}{
+		{"off", false, Unlimited, []string{root + "about"}},
+		{"on", true, Unlimited, []string{root + "about", root + "deep", root + "hidden", root + "hidden/child"}},
+		// the sitemap's urls are at its depth, so the links on them
+		// aren't followed
+		{"on, depth 1", true, 1, []string{root + "about", root + "deep", root + "hidden"}},
+		{"on, seed only", true, 0, nil},
+	}
+	for _, tt := range tests {
+		f := bodyFetcher{crawltest.NewFakeFetcher(graph), bodies}
+		results, err := Crawl(context.Background(), root, CrawlConfig{Fetcher: f, Depth: tt.depth, FollowSitemaps: tt.follow})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for r := range results {
+			if r.Err != nil {
+				t.Errorf("%s: %v", tt.name, r.Err)
+			}
+			if r.URL != root && r.URL != root+"sitemap.xml" {
+				got = append(got, r.URL)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: crawled %q, want %q", tt.name, got, tt.want)
+		}
+		if n := f.Fetches(root + "about"); tt.depth != 0 && n != 1 {
+			t.Errorf("%s: fetched about %d times, linked to and listed, want once", tt.name, n)
+		}
+	}
+}
+
+func TestFetchSitemap(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sitemap.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(testSitemap))
+	}))
+	defer ts.Close()
+
+	f := NewHTTPFetcher(ts.Client())
+	urls, err := FetchSitemap(context.Background(), f, ts.URL+"/sitemap.xml")
+	want := []string{"https://golang.org/", "https://golang.org/pkg/fmt/", "https://golang.org/pkg/os/"}
+	if err != nil || !slices.Equal(urls, want) {
+		t.Errorf("FetchSitemap = %q, %v; want %q", urls, err, want)
+	}
+	if _, err := FetchSitemap(context.Background(), f, ts.URL+"/missing.xml"); err == nil {
+		t.Error("FetchSitemap of a missing sitemap succeeded")
+	}
+}
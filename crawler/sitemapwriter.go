@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The most urls and bytes the sitemaps.org protocol allows in one
+// sitemap file.
+const (
+	SitemapMaxURLs  = 50000
+	SitemapMaxBytes = 50 << 20
+)
+
+// SitemapWriter is a ResultSink that writes the url of each page
+// fetched successfully to XML sitemaps in a directory as the crawl
+// goes, for crawls too big to hold in memory until the end. It starts
+// a new sitemap file, sitemap-1.xml, sitemap-2.xml and so on, whenever
+// the current one is full, and when closed writes a sitemap index,
+// sitemap.xml, listing them. Unlike WriteSitemap it writes urls in the
+// order they come, and doesn't look for repeats. It is safe for
+// concurrent use.
+//
+// Its exported fields may be changed after NewSitemapWriter returns,
+// but not once it has been written to.
+type SitemapWriter struct {
+	dir, baseURL string
+
+	mu    sync.Mutex
+	w     *bufio.Writer // of the current sitemap, or nil
+	f     *os.File
+	urls  int   // in the current sitemap
+	bytes int64 // in the current sitemap, so far
+	files []string
+	err   error // the first error, after which every call fails
+
+	// MaxURLs and MaxBytes are the most urls and bytes written to
+	// one sitemap file. They default to the protocol's limits,
+	// SitemapMaxURLs and SitemapMaxBytes.
+	MaxURLs  int
+	MaxBytes int64
+}
+
+// NewSitemapWriter returns a SitemapWriter writing to files in dir,
+// which must exist. baseURL is where the files will be served from,
+// such as https://example.com/, for the index to give each sitemap's
+// url.
+func NewSitemapWriter(dir, baseURL string) *SitemapWriter {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &SitemapWriter{
+		dir:      dir,
+		baseURL:  baseURL,
+		MaxURLs:  SitemapMaxURLs,
+		MaxBytes: SitemapMaxBytes,
+	}
+}
+
+const (
+	sitemapStart = xml.Header + `<urlset xmlns="` + sitemapNS + `">` + "\n"
+	sitemapEnd   = "</urlset>\n"
+)
+
+// Write implements ResultSink. Results with an error are left out.
+func (s *SitemapWriter) Write(r CrawlResult) error {
+	if r.Err != nil {
+		return nil
+	}
+	entry := sitemapEntry("url", r.URL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.w != nil && (s.urls >= s.MaxURLs || s.bytes+int64(len(entry)+len(sitemapEnd)) > s.MaxBytes) {
+		s.err = s.finish()
+	}
+	if s.w == nil && s.err == nil {
+		s.err = s.start()
+	}
+	if s.err == nil {
+		_, s.err = s.w.WriteString(entry)
+		s.urls++
+		s.bytes += int64(len(entry))
+	}
+	return s.err
+}
+
+// Close implements ResultSink. It finishes the last sitemap and writes
+// the index.
+func (s *SitemapWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.w != nil {
+		if s.err = s.finish(); s.err != nil {
+			return s.err
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<sitemapindex xmlns="` + sitemapNS + `">` + "\n")
+	for _, name := range s.files {
+		b.WriteString(sitemapEntry("sitemap", s.baseURL+name))
+	}
+	b.WriteString("</sitemapindex>\n")
+	s.err = os.WriteFile(filepath.Join(s.dir, "sitemap.xml"), b.Bytes(), 0o666)
+	if s.err != nil {
+		return s.err
+	}
+	s.err = errors.New("sitemap writer closed")
+	return nil
+}
+
+// start begins the next sitemap file.
+func (s *SitemapWriter) start() error {
+	name := fmt.Sprintf("sitemap-%d.xml", len(s.files)+1)
+	f, err := os.Create(filepath.Join(s.dir, name))
+	if err != nil {
+		return err
+	}
+	s.f, s.w = f, bufio.NewWriter(f)
+	s.files = append(s.files, name)
+	s.urls, s.bytes = 0, int64(len(sitemapStart))
+	_, err = s.w.WriteString(sitemapStart)
+	return err
+}
+
+// finish ends the current sitemap file.
+func (s *SitemapWriter) finish() error {
+	_, err := s.w.WriteString(sitemapEnd)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f, s.w = nil, nil
+	return err
+}
+
+// sitemapEntry returns the element of a sitemap, or of an index if
+// tag is "sitemap", listing loc, indented as WriteSitemap does.
+func sitemapEntry(tag, loc string) string {
+	var b strings.Builder
+	b.WriteString("  <" + tag + ">\n    <loc>")
+	xml.EscapeText(&b, []byte(loc))
+	b.WriteString("</loc>\n  </" + tag + ">\n")
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestSitemapWriter(t *testing.T) {
+	// room for four of p0 to p9
+	four := int64(len(sitemapStart) + 4*len(sitemapEntry("url", "http://example.com/p0")) + len(sitemapEnd))
+	tests := []struct {
+		name     string
+		pages    int
+		maxBytes int64 // if not the protocol's limit
+		want     []int // urls in each sitemap file
+	}{
+		{"60000 pages", 60000, 0, []int{SitemapMaxURLs, 10000}},
+		{"one file", 100, 0, []int{100}},
+		{"MaxBytes", 10, four, []int{4, 4, 2}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		w := NewSitemapWriter(dir, "https://example.com/maps")
+		if tt.maxBytes > 0 {
+			w.MaxBytes = tt.maxBytes
+		}
+		graph := syntheticGraph(tt.pages, 2)
+		cr, err := NewCrawler([]string{"http://example.com/p0"}, CrawlConfig{
+			Fetcher: crawltest.NewFakeFetcher(graph), Depth: Unlimited, Sink: w,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cr.Run(context.Background()); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "sitemap.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index struct {
+			Sitemaps []struct {
+				Loc string `xml:"loc"`
+			} `xml:"sitemap"`
+		}
+		if err := xml.Unmarshal(b, &index); err != nil {
+			t.Fatalf("%s: the index: %v", tt.name, err)
+		}
+		var listed []string
+		for _, s := range index.Sitemaps {
+			listed = append(listed, s.Loc)
+		}
+		var wantListed []string
+		for i := range tt.want {
+			wantListed = append(wantListed, fmt.Sprintf("https://example.com/maps/sitemap-%d.xml", i+1))
+		}
+		if !slices.Equal(listed, wantListed) {
+			t.Errorf("%s: the index lists %q, want %q", tt.name, listed, wantListed)
+		}
+
+		var all []string
+		for i, n := range tt.want {
+			name := filepath.Join(dir, fmt.Sprintf("sitemap-%d.xml", i+1))
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			urls, err := ParseSitemap(f)
+			f.Close()
+			if err != nil || len(urls) != n {
+				t.Errorf("%s: %s has %d urls, %v; want %d", tt.name, name, len(urls), err, n)
+			}
+			if fi, err := os.Stat(name); err == nil && fi.Size() > w.MaxBytes {
+				t.Errorf("%s: %s is %d bytes, over %d", tt.name, name, fi.Size(), w.MaxBytes)
+			}
+			all = append(all, urls...)
+		}
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("sitemap-%d.xml", len(tt.want)+1))); err == nil {
+			t.Errorf("%s: more than %d sitemap files", tt.name, len(tt.want))
+		}
+		// every page, once
+		slices.Sort(all)
+		if len(slices.Compact(all)) != tt.pages {
+			t.Errorf("%s: the sitemaps list %d distinct urls, want %d", tt.name, len(all), tt.pages)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CrawlStats summarizes a finished crawl.
+type CrawlStats struct {
+	PagesFetched    int   // pages fetched successfully
+	PagesFailed     int   // pages whose fetch failed
+	PagesSkipped    int   // pages the fetcher skipped, e.g. already fetched
+	TotalLinksFound int   // links found on fetched pages, counting repeats
+	TruncatedPages  int   // pages with more links than MaxLinksPerPage
+	FanOutLimited   int   // pages with links held back by MaxFanOut
+	SkippedLongURLs int   // links not followed for being over MaxURLLength
+	SuspectedTraps  int   // links not followed for MaxRepeatedSegments
+	DeepPaths       int   // links not followed for MaxPathSegments
+	SelfLinks       int   // pages linking to themselves, the links not followed
+	BytesFetched    int64 // body bytes downloaded, not counting the BodyCache
+	UniqueHosts     int   // hosts of the seeds and links found, followed or not; see Crawler.Hosts
+	Elapsed         time.Duration
+
+	// SkippedSchemes counts the links not followed for their scheme
+	// not being among the AllowedSchemes, by lower case scheme, such
+	// as "mailto", or "" for links with none. It is nil if there
+	// were none.
+	SkippedSchemes map[string]int
+
+	// PagesByDepth counts the pages fetched successfully by how many
+	// links from a seed they were found: 0 for the seeds, 1 for the
+	// pages they link to, and so on. The counts add up to
+	// PagesFetched.
+	PagesByDepth map[int]int
+
+	// DeadlineExceeded is set if the crawl was cut short by its
+	// MaxDuration, rather than finishing or being cancelled.
+	DeadlineExceeded bool
+
+	// CircuitOpen is set if the crawl was stopped by its
+	// MaxConsecutiveErrors.
+	CircuitOpen *CircuitOpenError
+
+	// IdleTimedOut is set if the crawl was stopped by its
+	// IdleTimeout.
+	IdleTimedOut bool
+}
+
+// crawlCounters accumulates the numbers in a CrawlStats while the
+// crawl runs. It is safe for concurrent use.
+type crawlCounters struct {
+	start     time.Time
+	fetched   atomic.Int64
+	failed    atomic.Int64
+	skipped   atomic.Int64
+	links     atomic.Int64
+	truncated atomic.Int64
+	fanOut    atomic.Int64
+	longURLs  atomic.Int64
+	traps     atomic.Int64
+	deepPaths atomic.Int64
+	self      atomic.Int64
+	bytes     atomic.Int64
+
+	mu       sync.Mutex
+	byLevel  map[int]int
+	byScheme map[string]int
+	hosts    map[string]bool // for UniqueHosts
+}
+
+// fetchedAt counts a page fetched at level links from a seed.
+func (c *crawlCounters) fetchedAt(level int) {
+	c.fetched.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byLevel == nil {
+		c.byLevel = make(map[int]int)
+	}
+	c.byLevel[level]++
+}
+
+// skippedScheme counts a link not followed for its scheme.
+func (c *crawlCounters) skippedScheme(scheme string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byScheme == nil {
+		c.byScheme = make(map[string]int)
+	}
+	c.byScheme[scheme]++
+}
+
+// sawHosts records the hosts of urls, leaving out those with none.
+func (c *crawlCounters) sawHosts(urls []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, u := range urls {
+		if h := hostOf(u); h != "" {
+			if c.hosts == nil {
+				c.hosts = make(map[string]bool)
+			}
+			c.hosts[h] = true
+		}
+	}
+}
+
+// hostList returns the hosts recorded by sawHosts, sorted.
+func (c *crawlCounters) hostList() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedKeys(c.hosts)
+}
+
+// restoreHosts records hosts, as returned by hostList.
+func (c *crawlCounters) restoreHosts(hosts []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, h := range hosts {
+		if c.hosts == nil {
+			c.hosts = make(map[string]bool)
+		}
+		c.hosts[h] = true
+	}
+}
+
+// restore sets the counters to the numbers in s, as if the crawl had
+// been running for s.Elapsed.
+func (c *crawlCounters) restore(s CrawlStats) {
+	c.start = time.Now().Add(-s.Elapsed)
+	c.fetched.Store(int64(s.PagesFetched))
+	c.failed.Store(int64(s.PagesFailed))
+	c.skipped.Store(int64(s.PagesSkipped))
+	c.links.Store(int64(s.TotalLinksFound))
+	c.truncated.Store(int64(s.TruncatedPages))
+	c.fanOut.Store(int64(s.FanOutLimited))
+	c.longURLs.Store(int64(s.SkippedLongURLs))
+	c.traps.Store(int64(s.SuspectedTraps))
+	c.deepPaths.Store(int64(s.DeepPaths))
+	c.self.Store(int64(s.SelfLinks))
+	c.bytes.Store(s.BytesFetched)
+	c.mu.Lock()
+	c.byLevel = maps.Clone(s.PagesByDepth)
+	c.byScheme = maps.Clone(s.SkippedSchemes)
+	c.mu.Unlock()
+}
+
+// stats returns the counters as of now.
+func (c *crawlCounters) stats() CrawlStats {
+	c.mu.Lock()
+	byLevel := maps.Clone(c.byLevel)
+	byScheme := maps.Clone(c.byScheme)
+	hosts := len(c.hosts)
+	c.mu.Unlock()
+	return CrawlStats{
+		PagesFetched:    int(c.fetched.Load()),
+		PagesFailed:     int(c.failed.Load()),
+		PagesSkipped:    int(c.skipped.Load()),
+		TotalLinksFound: int(c.links.Load()),
+		TruncatedPages:  int(c.truncated.Load()),
+		FanOutLimited:   int(c.fanOut.Load()),
+		SkippedLongURLs: int(c.longURLs.Load()),
+		SuspectedTraps:  int(c.traps.Load()),
+		DeepPaths:       int(c.deepPaths.Load()),
+		SelfLinks:       int(c.self.Load()),
+		BytesFetched:    c.bytes.Load(),
+		UniqueHosts:     hosts,
+		Elapsed:         time.Since(c.start),
+		PagesByDepth:    byLevel,
+		SkippedSchemes:  byScheme,
+	}
+}
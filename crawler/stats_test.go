@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+func TestCrawlStats(t *testing.T) {
+	const root = "http://example.com/"
+	f := crawltest.NewFakeFetcher(map[string][]string{
+		root:                    {root + "a", root + "b", root + "gone", "mailto:a@example.com", root, "http://other.example/"},
+		root + "a":              {root + "b", root + "a/c"},
+		root + "a/c":            nil,
+		"http://other.example/": nil,
+	})
+	f.SetError(root+"b", &AlreadyFetchedError{root + "b"})
+
+	var stats CrawlStats
+	pages, _ := CrawlErrors(context.Background(), root, 3, f, WithStats(&stats))
+	if stats.Elapsed <= 0 {
+		t.Errorf("Elapsed = %v, want the time the crawl took", stats.Elapsed)
+	}
+	stats.Elapsed = 0
+
+	var bytes int64
+	for _, p := range pages {
+		bytes += int64(len(crawltest.Body(p.URL)))
+	}
+	want := CrawlStats{
+		PagesFetched: 4, // the root, a, a/c and other.example
+		PagesFailed:  1, // gone
+		// b, skipped by the fetcher, then as a duplicate link from a
+		PagesSkipped:    2,
+		TotalLinksFound: 8,
+		SelfLinks:       1,
+		BytesFetched:    bytes,
+		UniqueHosts:     2,
+		SkippedSchemes:  map[string]int{"mailto": 1},
+		PagesByDepth:    map[int]int{0: 1, 1: 2, 2: 1},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats:\n%+v\nwant\n%+v", stats, want)
+	}
+}
+
+func TestUniqueHosts(t *testing.T) {
+	// offSiteGraph links to three hosts; the restricted crawls fetch
+	// one or two of them, but every host is still seen
+	hosts := []string{"example.com", "golang.org", "www.golang.org"}
+	tests := []struct {
+		name    string
+		cfg     CrawlConfig
+		fetched []string
+	}{
+		{"unrestricted", CrawlConfig{}, hosts},
+		{"same host", CrawlConfig{SameHostOnly: true}, []string{"golang.org"}},
+		{"allowed hosts", CrawlConfig{AllowedHosts: []string{"golang.org", "www.golang.org"}}, []string{"golang.org", "www.golang.org"}},
+		{"concurrent", CrawlConfig{SameHostOnly: true, MaxWorkers: 16}, []string{"golang.org"}},
+	}
+	for _, tt := range tests {
+		var stats CrawlStats
+		cfg := tt.cfg
+		cfg.Fetcher = crawltest.NewFakeFetcher(offSiteGraph)
+		cfg.Depth = Unlimited
+		cfg.Stats = &stats
+		cr, err := NewCrawler([]string{"https://golang.org/"}, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := cr.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched := map[string]bool{}
+		for r := range results {
+			fetched[hostOf(r.URL)] = true
+		}
+		if got := slices.Sorted(maps.Keys(fetched)); !slices.Equal(got, tt.fetched) {
+			t.Errorf("%s: fetched from %q, want %q", tt.name, got, tt.fetched)
+		}
+		if got := cr.Hosts(); !slices.Equal(got, hosts) {
+			t.Errorf("%s: Hosts() = %q, want %q", tt.name, got, hosts)
+		}
+		if stats.UniqueHosts != len(hosts) {
+			t.Errorf("%s: UniqueHosts = %d, want %d", tt.name, stats.UniqueHosts, len(hosts))
+		}
+	}
+}
+
+func TestPagesByDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph map[string][]string
+		seed  string
+		opts  []CrawlOption
+		want  map[int]int
+	}{
+		// golang.org/cmd/ at depth 1 is not found
+		{"rawData", rawDataGraph(), "https://golang.org/", nil, map[int]int{0: 1, 1: 1, 2: 2}},
+		{"max pages", syntheticGraph(100, 3), "http://example.com/p0",
+			[]CrawlOption{WithMaxPages(7), WithBreadthFirst()}, map[int]int{0: 1, 1: 3, 2: 3}},
+	}
+	for _, tt := range tests {
+		var stats CrawlStats
+		opts := append([]CrawlOption{WithStats(&stats)}, tt.opts...)
+		CrawlErrors(context.Background(), tt.seed, 4, crawltest.NewFakeFetcher(tt.graph), opts...)
+		if !maps.Equal(stats.PagesByDepth, tt.want) {
+			t.Errorf("%s: PagesByDepth = %v, want %v", tt.name, stats.PagesByDepth, tt.want)
+		}
+		sum := 0
+		for _, n := range stats.PagesByDepth {
+			sum += n
+		}
+		if sum != stats.PagesFetched {
+			t.Errorf("%s: PagesByDepth adds up to %d, but %d pages were fetched", tt.name, sum, stats.PagesFetched)
+		}
+	}
+}
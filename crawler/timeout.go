@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FetchTimeoutError is returned, as *FetchTimeoutError, when a fetch
+// takes longer than a TimeoutFetcher allows.
+type FetchTimeoutError struct {
+	url string
+}
+
+func (e FetchTimeoutError) Error() string {
+	return fmt.Sprintf("Timed out fetching %v", e.url)
+}
+
+// TimeoutFetcher is a Fetcher that gives up on slow fetches. It
+// wraps another Fetcher and fails any fetch that takes longer than
+// timeout with *FetchTimeoutError.
+type TimeoutFetcher struct {
+	fetcher Fetcher
+	timeout time.Duration
+}
+
+// NewTimeoutFetcher returns a TimeoutFetcher that wraps fetcher and
+// allows each fetch to run for at most timeout.
+func NewTimeoutFetcher(fetcher Fetcher, timeout time.Duration) *TimeoutFetcher {
+	return &TimeoutFetcher{fetcher: fetcher, timeout: timeout}
+}
+
+func (f *TimeoutFetcher) wrapped() Fetcher {
+	return f.fetcher
+}
+
+// Fetch implements Fetcher. It returns as soon as the timeout
+// expires, even if the wrapped fetcher ignores its context. Such a
+// fetcher keeps running in the background until it returns; its
+// result is then discarded.
+func (f *TimeoutFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	return fromPage(f.FetchPage(ctx, url))
+}
+
+// FetchPage implements PageFetcher, timing out like Fetch.
+func (f *TimeoutFetcher) FetchPage(parent context.Context, url string) (*Page, error) {
+	ctx, cancel := context.WithTimeout(parent, f.timeout)
+	defer cancel()
+
+	type result struct {
+		page *Page
+		err  error
+	}
+	// buffered so the fetch goroutine can always finish
+	done := make(chan result, 1)
+	go func() {
+		page, err := fetchPage(ctx, f.fetcher, url)
+		done <- result{page, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil && ctx.Err() != nil && parent.Err() == nil {
+			// failed because our timer went off
+			return nil, &FetchTimeoutError{url}
+		}
+		return r.page, r.err
+	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			return nil, err
+		}
+		return nil, &FetchTimeoutError{url}
+	}
+}
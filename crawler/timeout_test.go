@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// stubbornFetcher takes delay over every fetch, ignoring its context.
+type stubbornFetcher struct {
+	Fetcher
+	delay time.Duration
+}
+
+func (f stubbornFetcher) Fetch(ctx context.Context, url string) (string, []string, error) {
+	time.Sleep(f.delay)
+	return f.Fetcher.Fetch(context.Background(), url)
+}
+
+func TestTimeoutFetcher(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	fake := crawltest.NewFakeFetcher(map[string][]string{
+		"http://example.com/fast": nil,
+		"http://example.com/slow": nil,
+	})
+	fake.SetDelay("http://example.com/slow", time.Minute)
+
+	tests := []struct {
+		name    string
+		fetcher Fetcher
+		url     string
+		timeout bool
+	}{
+		{"fast", fake, "http://example.com/fast", false},
+		{"slow", fake, "http://example.com/slow", true},
+		{"ignores its context", stubbornFetcher{fake, 500 * time.Millisecond}, "http://example.com/fast", true},
+	}
+	for _, tt := range tests {
+		f := NewTimeoutFetcher(tt.fetcher, timeout)
+		start := time.Now()
+		body, _, err := f.Fetch(context.Background(), tt.url)
+		elapsed := time.Since(start)
+
+		var te *FetchTimeoutError
+		if got := errors.As(err, &te); got != tt.timeout {
+			t.Errorf("%s: Fetch = %q, %v; want a timeout %v", tt.name, body, err, tt.timeout)
+		}
+		if tt.timeout && elapsed > 10*timeout {
+			t.Errorf("%s: timed out after %v, want about %v", tt.name, elapsed, timeout)
+		}
+	}
+}
+
+func TestTimeoutFetcherCancel(t *testing.T) {
+	fake := crawltest.NewFakeFetcher(map[string][]string{"http://example.com/": nil})
+	fake.SetDelay("http://example.com/", time.Minute)
+	f := NewTimeoutFetcher(fake, time.Minute)
+
+	// the caller giving up is not a timeout of the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := f.Fetch(ctx, "http://example.com/")
+	var te *FetchTimeoutError
+	if errors.As(err, &te) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch with its context cancelled: %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+)
+
+// PrintTree writes the pages in results to w as an indented tree
+// rooted at root, in which each page sits below the page that it was
+// discovered from. Links from a page back to itself or one of its
+// ancestors are shown as "(already visited)" leaves instead of being
+// followed around the cycle, and pages that failed to fetch are
+// marked "(failed)". Pages are placed in sorted order, but which page
+// each was discovered from can vary between concurrent crawls; a
+// Sequential crawl always gives the same tree.
+func PrintTree(w io.Writer, results []CrawlResult, root string) {
+	results = slices.Clone(results)
+	sortResults(results)
+	t := treePrinter{
+		w:        w,
+		pages:    make(map[string]CrawlResult),
+		children: make(map[string][]string),
+		printed:  make(map[string]bool),
+	}
+	for _, r := range results {
+		if _, dup := t.pages[r.URL]; dup {
+			continue
+		}
+		t.pages[r.URL] = r
+		if r.ParentURL != "" {
+			t.children[r.ParentURL] = append(t.children[r.ParentURL], r.URL)
+		}
+	}
+	for _, c := range t.children {
+		sort.Strings(c)
+	}
+
+	fmt.Fprintln(w, root)
+	t.printed[root] = true
+	t.print(root, "", map[string]bool{root: true})
+}
+
+type treePrinter struct {
+	w        io.Writer
+	pages    map[string]CrawlResult
+	children map[string][]string
+	printed  map[string]bool
+}
+
+type treeItem struct {
+	url     string
+	visited bool // a link back up the tree
+}
+
+// print writes the subtree below node, each line starting with
+// prefix. ancestors holds node and the pages above it.
+func (t *treePrinter) print(node, prefix string, ancestors map[string]bool) {
+	var items []treeItem
+	seen := make(map[string]bool)
+	for _, l := range t.pages[node].Links {
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		if ancestors[l] {
+			items = append(items, treeItem{l, true})
+		} else if t.pages[l].ParentURL == node && !t.printed[l] {
+			items = append(items, treeItem{l, false})
+		}
+	}
+	// children the page's links don't account for
+	for _, c := range t.children[node] {
+		if !seen[c] && !t.printed[c] {
+			items = append(items, treeItem{c, false})
+		}
+	}
+
+	for i, it := range items {
+		branch, indent := "├── ", "│   "
+		if i == len(items)-1 {
+			branch, indent = "└── ", "    "
+		}
+		if it.visited {
+			fmt.Fprintf(t.w, "%s%s%s (already visited)\n", prefix, branch, it.url)
+			continue
+		}
+		if t.pages[it.url].Err != nil {
+			fmt.Fprintf(t.w, "%s%s%s (failed)\n", prefix, branch, it.url)
+		} else {
+			fmt.Fprintf(t.w, "%s%s%s\n", prefix, branch, it.url)
+		}
+		t.printed[it.url] = true
+		ancestors[it.url] = true
+		t.print(it.url, prefix+indent, ancestors)
+		delete(ancestors, it.url)
+	}
+}
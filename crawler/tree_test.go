@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintTree(t *testing.T) {
+	results := crawlRawData(t, CrawlConfig{Sequential: true})
+	var buf bytes.Buffer
+	PrintTree(&buf, results, "https://golang.org/")
+	checkGolden(t, "tree.golden", buf.Bytes())
+
+	// each page is placed once, and the links back up are marked
+	placed := make(map[string]int)
+	var cycles int
+	for line := range strings.Lines(buf.String()) {
+		fields := strings.Fields(strings.TrimLeft(line, "│├└─ "))
+		if strings.HasSuffix(line, "(already visited)\n") {
+			cycles++
+			continue
+		}
+		placed[fields[0]]++
+	}
+	for _, r := range results {
+		if placed[r.URL] != 1 {
+			t.Errorf("%s placed %d times, want once", r.URL, placed[r.URL])
+		}
+	}
+	if len(placed) != len(results) {
+		t.Errorf("placed %d pages, want the %d crawled", len(placed), len(results))
+	}
+	if cycles == 0 {
+		t.Error("no links back to https://golang.org/ marked (already visited)")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// Validators are what an HTTPFetcher remembers of a page to fetch it
+// again conditionally: the response's ETag and Last-Modified
+// headers, and the links that were on the page along with what else
+// the fetcher found on it, to report again when it hasn't changed.
+type Validators struct {
+	ETag         string
+	LastModified string
+	URLs         []string
+
+	ContentType        string
+	Canonical          string
+	Title, Description string
+	Assets             []string
+	StructuredData     [][]byte
+	Sitemaps           []string
+	Nofollow           []string
+	BodyHash           string // see Page.BodyHash
+	Blank              bool
+}
+
+// ValidatorCache stores the Validators of pages by url, so that a
+// re-crawl can ask servers for just the pages that changed. It must
+// be safe for concurrent use.
+type ValidatorCache interface {
+	// Get returns the validators stored for url, if any.
+	Get(url string) (Validators, bool)
+
+	// Put stores v as the validators of url.
+	Put(url string, v Validators)
+}
+
+// MemoryValidatorCache is a ValidatorCache held in memory.
+// The zero value is an empty cache ready to use.
+type MemoryValidatorCache struct {
+	mu   sync.Mutex
+	vals map[string]Validators
+}
+
+// Get implements ValidatorCache.
+func (c *MemoryValidatorCache) Get(url string) (Validators, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.vals[url]
+	return v.clone(), ok
+}
+
+// Put implements ValidatorCache.
+func (c *MemoryValidatorCache) Put(url string, v Validators) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.vals == nil {
+		c.vals = make(map[string]Validators)
+	}
+	c.vals[url] = v.clone()
+}
+
+// clone returns a copy of v that shares no slices with it.
+func (v Validators) clone() Validators {
+	v.URLs = slices.Clone(v.URLs)
+	v.Assets = slices.Clone(v.Assets)
+	v.StructuredData = slices.Clone(v.StructuredData)
+	v.Sitemaps = slices.Clone(v.Sitemaps)
+	v.Nofollow = slices.Clone(v.Nofollow)
+	return v
+}
+
+// record sets what v remembers of the page besides its validators
+// to what p reports.
+func (v *Validators) record(p *Page) {
+	v.URLs, v.ContentType = p.URLs, p.ContentType
+	v.Canonical, v.Title, v.Description = p.Canonical, p.Title, p.Description
+	v.Assets, v.StructuredData = p.Assets, p.StructuredData
+	v.Sitemaps, v.Nofollow = p.Sitemaps, p.Nofollow
+	v.BodyHash, v.Blank = p.bodyHash()
+}
+
+// restore sets the fields of p, a page that hasn't changed since v
+// was stored, to what v remembers of it.
+func (v Validators) restore(p *Page) {
+	p.URLs, p.ContentType = v.URLs, cmp.Or(p.ContentType, v.ContentType)
+	p.Canonical, p.Title, p.Description = v.Canonical, v.Title, v.Description
+	p.Assets, p.StructuredData = v.Assets, v.StructuredData
+	p.Sitemaps, p.Nofollow = v.Sitemaps, v.Nofollow
+	p.BodyHash, p.Blank = v.BodyHash, v.Blank
+}
+
+// setConditional makes req conditional on the page having changed
+// since v was stored.
+func (v Validators) setConditional(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// validatorsOf returns the validators of resp, along with whether it
+// had any.
+func validatorsOf(resp *http.Response) (Validators, bool) {
+	v := Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return v, v.ETag != "" || v.LastModified != ""
+}
@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// VisitedSet is the set of urls a crawl has admitted. A crawl adds
+// each url it finds, as the key given by its DedupKey, by default the
+// form returned by NormalizeURL, and crawls only those it could add.
+// Implementations must be safe for concurrent use.
+type VisitedSet interface {
+	// Add inserts url into the set. It reports whether url was
+	// added, returning false if it was already present. For any
+	// url exactly one caller of Add may see true.
+	Add(url string) (added bool)
+
+	// Len returns the number of urls in the set.
+	Len() int
+}
+
+// MemoryVisitedSet is a VisitedSet held in memory.
+// The zero value is an empty set ready to use.
+type MemoryVisitedSet struct {
+	mu   sync.Mutex
+	urls map[string]bool
+}
+
+// Add implements VisitedSet. Checking and inserting happen under a
+// single lock.
+func (s *MemoryVisitedSet) Add(url string) (added bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.urls[url] {
+		return false
+	}
+	if s.urls == nil {
+		s.urls = make(map[string]bool)
+	}
+	s.urls[url] = true
+	return true
+}
+
+// keys returns the urls in the set, sorted.
+func (s *MemoryVisitedSet) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.urls)
+}
+
+// Len implements VisitedSet.
+func (s *MemoryVisitedSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.urls)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryVisitedSetConcurrentAdd(t *testing.T) {
+	const callers, urls = 50, 100
+	var s MemoryVisitedSet
+	var mu sync.Mutex
+	added := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Go(func() {
+			for i := range urls {
+				u := fmt.Sprintf("http://example.com/%d", i)
+				if s.Add(u) {
+					mu.Lock()
+					added[u]++
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	for i := range urls {
+		u := fmt.Sprintf("http://example.com/%d", i)
+		if added[u] != 1 {
+			t.Errorf("Add(%q) returned true %d times, want once", u, added[u])
+		}
+	}
+	if s.Len() != urls {
+		t.Errorf("Len() = %d, want %d", s.Len(), urls)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// CrawlDiff is how one crawl of a site differs from the one before,
+// as reported by Watch. Each list is sorted.
+type CrawlDiff struct {
+	Added   []string // urls crawled this time but not last time
+	Removed []string // urls crawled last time but not this time
+	Changed []string // urls crawled both times whose bodies differ
+
+	Stats CrawlStats // of this crawl
+}
+
+// Watch crawls from seeds, configured by cfg, every interval, and
+// sends on the returned channel how each crawl differs from the one
+// before, for watching a site for changes. The first crawl has
+// nothing to differ from, so its diff lists every page as added.
+// Crawls that change nothing send nothing. The pages of a crawl are
+// those fetched without error, and one has changed if its BodyHash
+// has, so a DryRun crawl sees changes too.
+//
+// Each crawl starts afresh: cfg.Visited and cfg.BodyCache are not
+// used, since they would have later crawls skip the pages found by
+// earlier ones, and cfg.Stats is not filled in, the stats of each
+// crawl coming with its diff instead. A crawl cut short by MaxPages
+// or MaxDuration reports the pages it didn't reach as removed.
+// cfg.Fetcher is used for every crawl, so an HTTPFetcher's Validators
+// have later crawls fetch only the pages that changed; those that
+// didn't come back 304 Not Modified with the BodyHash they had, so
+// they are not reported changed.
+//
+// If a crawl outlasts interval the next starts as soon as it
+// finishes. The channel is closed once ctx is cancelled; a crawl
+// interrupted by that sends no diff. An error is returned, and
+// nothing crawled, if interval is not positive or a crawl would fail
+// to start.
+func Watch(ctx context.Context, seeds []string, cfg CrawlConfig, interval time.Duration) (<-chan CrawlDiff, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("crawl: invalid watch interval %v", interval)
+	}
+	cfg.Visited, cfg.BodyCache, cfg.Stats = nil, nil, nil
+	if _, err := NewCrawler(seeds, cfg); err != nil {
+		return nil, err
+	}
+
+	c := make(chan CrawlDiff)
+	go func() {
+		defer close(c)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var last map[string]string
+		for {
+			pages, stats, ok := watchCrawl(ctx, seeds, cfg)
+			if !ok {
+				return
+			}
+			if diff := diffCrawls(last, pages); diff.Added != nil || diff.Removed != nil || diff.Changed != nil {
+				diff.Stats = stats
+				select {
+				case c <- diff:
+				case <-ctx.Done():
+					return
+				}
+			}
+			last = pages
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// CrawlChanged is like Crawl, but for crawling a site again cheaply:
+// prior maps the urls crawled before to their BodyHash then, and the
+// links of a page whose BodyHash is still the same are not followed,
+// the pages below it being taken to be the same as well. Such pages
+// are still fetched, to tell whether they have changed, and sent
+// with Unchanged set. So a crawl from an unchanged seed fetches only
+// the seed. Changes below an unchanged page are not found, and a
+// full crawl now and then catches them up. prior is typically built
+// from the BodyHash of each result of an earlier crawl, and must not
+// be changed while the crawl runs.
+func CrawlChanged(ctx context.Context, seed string, cfg CrawlConfig, prior map[string]string) (<-chan CrawlResult, error) {
+	cr, err := NewCrawler([]string{seed}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cr.run.prior = prior
+	return cr.Start(ctx)
+}
+
+// watchCrawl runs one crawl for Watch, returning the BodyHash of each
+// page fetched without error, and the crawl's stats. It reports false
+// if ctx was cancelled before the crawl finished.
+func watchCrawl(ctx context.Context, seeds []string, cfg CrawlConfig) (map[string]string, CrawlStats, bool) {
+	var stats CrawlStats
+	cfg.Stats = &stats
+	results, err := CrawlSeeds(ctx, seeds, cfg)
+	if err != nil {
+		return nil, stats, false // checked by Watch, so it can't happen
+	}
+	pages := make(map[string]string)
+	for r := range results {
+		if r.Err == nil {
+			pages[r.URL] = r.BodyHash
+		}
+	}
+	return pages, stats, ctx.Err() == nil
+}
+
+// diffCrawls returns how the pages of one crawl, as returned by
+// watchCrawl, differ from those of the crawl before.
+func diffCrawls(last, pages map[string]string) CrawlDiff {
+	var d CrawlDiff
+	for u, h := range pages {
+		if old, ok := last[u]; !ok {
+			d.Added = append(d.Added, u)
+		} else if old != h {
+			d.Changed = append(d.Changed, u)
+		}
+	}
+	for u := range last {
+		if _, ok := pages[u]; !ok {
+			d.Removed = append(d.Removed, u)
+		}
+	}
+	slices.Sort(d.Added)
+	slices.Sort(d.Removed)
+	slices.Sort(d.Changed)
+	return d
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/colindr/gotests/crawler/crawltest"
+)
+
+// etagSite is a site whose pages have ETags, counting the full
+// responses it sends for each path.
+type etagSite struct {
+	mu    sync.Mutex
+	pages map[string]string // path to body
+	sent  map[string]int
+}
+
+func (s *etagSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.pages[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, len(body))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.sent[r.URL.Path]++
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, body)
+}
+
+// newETagSite serves pages, which map paths to bodies.
+func newETagSite(t *testing.T, pages map[string]string) (*etagSite, *httptest.Server) {
+	t.Helper()
+	s := &etagSite{pages: pages, sent: make(map[string]int)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+// changingSite is a site that changes between crawls from its seed:
+// each crawl fetching the seed moves it on a step, and it is each of
+// its versions for two crawls, the last for good.
+type changingSite struct {
+	seed     string
+	versions []Fetcher
+
+	mu     sync.Mutex
+	crawls int
+}
+
+func (s *changingSite) Fetch(ctx context.Context, url string) (string, []string, error) {
+	s.mu.Lock()
+	if url == s.seed {
+		s.crawls++
+	}
+	f := s.versions[min((s.crawls-1)/2, len(s.versions)-1)]
+	s.mu.Unlock()
+	return f.Fetch(ctx, url)
+}
+
+func TestWatch(t *testing.T) {
+	const root = "http://example.com/"
+	// the site as each crawl finds it, and how it differs from before
+	steps := []struct {
+		name                    string
+		graph                   map[string][]string
+		bodies                  map[string]string
+		added, removed, changed []string
+		fetched                 int
+	}{
+		{
+			"first crawl",
+			map[string][]string{root: {root + "a", root + "b"}, root + "a": nil, root + "b": nil},
+			map[string]string{root: "home", root + "a": "a", root + "b": "b"},
+			[]string{root, root + "a", root + "b"}, nil, nil, 3,
+		},
+		{
+			"b changed",
+			map[string][]string{root: {root + "a", root + "b"}, root + "a": nil, root + "b": nil},
+			map[string]string{root: "home", root + "a": "a", root + "b": "b, changed"},
+			nil, nil, []string{root + "b"}, 3,
+		},
+		{
+			"a unlinked, c linked",
+			map[string][]string{root: {root + "b", root + "c"}, root + "a": nil, root + "b": nil, root + "c": nil},
+			map[string]string{root: "home, changed", root + "a": "a", root + "b": "b, changed", root + "c": "c"},
+			[]string{root + "c"}, []string{root + "a"}, []string{root}, 3,
+		},
+		{
+			"c gone",
+			map[string][]string{root: {root + "b", root + "c"}, root + "b": nil},
+			map[string]string{root: "home, changed", root + "b": "b, changed"},
+			nil, []string{root + "c"}, nil, 2,
+		},
+	}
+
+	site := &changingSite{seed: root}
+	for _, step := range steps {
+		site.versions = append(site.versions, bodyFetcher{crawltest.NewFakeFetcher(step.graph), step.bodies})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	diffs, err := Watch(ctx, []string{root}, CrawlConfig{Fetcher: site, Depth: Unlimited}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second crawl of each version finds nothing changed, and
+	// sends nothing
+	for _, step := range steps {
+		d := <-diffs
+		if !slices.Equal(d.Added, step.added) || !slices.Equal(d.Removed, step.removed) || !slices.Equal(d.Changed, step.changed) {
+			t.Errorf("%s: diff added %q, removed %q, changed %q; want %q, %q, %q",
+				step.name, d.Added, d.Removed, d.Changed, step.added, step.removed, step.changed)
+		}
+		if d.Stats.PagesFetched != step.fetched {
+			t.Errorf("%s: diff stats have %d pages fetched, want %d", step.name, d.Stats.PagesFetched, step.fetched)
+		}
+	}
+	// the last version, crawled again and again
+	select {
+	case d := <-diffs:
+		t.Errorf("Watch sent %+v for a site that stopped changing", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	for range diffs {
+	}
+}
+
+func TestCrawlChanged(t *testing.T) {
+	const root = "http://example.com/"
+	graph := map[string][]string{
+		root:        {root + "a", root + "b"},
+		root + "a":  {root + "a1"},
+		root + "b":  {root + "b1"},
+		root + "a1": nil,
+		root + "b1": nil,
+	}
+	bodies := func(changed ...string) map[string]string {
+		b := make(map[string]string)
+		for u := range graph {
+			b[u] = "the first " + u
+			if slices.Contains(changed, u) {
+				b[u] = "a new " + u
+			}
+		}
+		return b
+	}
+	seen, err := Crawl(context.Background(), root, CrawlConfig{
+		Fetcher: bodyFetcher{crawltest.NewFakeFetcher(graph), bodies()}, Depth: Unlimited,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prior := make(map[string]string)
+	for r := range seen {
+		prior[r.URL] = r.BodyHash
+	}
+
+	tests := []struct {
+		name      string
+		changed   []string
+		want      []string // fetched
+		unchanged []string
+	}{
+		{"nothing", nil, []string{root}, []string{root}},
+		// the root and b: only their subtrees, so not a1
+		{"the root and b", []string{root, root + "b"},
+			[]string{root, root + "a", root + "b", root + "b1"}, []string{root + "a", root + "b1"}},
+		// the change below an unchanged page isn't found
+		{"only b", []string{root + "b"}, []string{root}, []string{root}},
+		{"a leaf and its parent", []string{root, root + "a", root + "a1"},
+			[]string{root, root + "a", root + "a1", root + "b"}, []string{root + "b"}},
+	}
+	for _, tt := range tests {
+		f := crawltest.NewFakeFetcher(graph)
+		results, err := CrawlChanged(context.Background(), root, CrawlConfig{
+			Fetcher: bodyFetcher{f, bodies(tt.changed...)}, Depth: Unlimited,
+		}, prior)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, unchanged []string
+		for r := range results {
+			if r.Err != nil {
+				t.Errorf("%s: %v", tt.name, r.Err)
+			}
+			got = append(got, r.URL)
+			if r.Unchanged {
+				unchanged = append(unchanged, r.URL)
+			}
+		}
+		slices.Sort(got)
+		slices.Sort(unchanged)
+		if !slices.Equal(got, tt.want) || !slices.Equal(unchanged, tt.unchanged) {
+			t.Errorf("%s changed: crawled %q, unchanged %q; want %q, %q", tt.name, got, unchanged, tt.want, tt.unchanged)
+		}
+		if n := totalFetches(f, graph); n != len(tt.want) {
+			t.Errorf("%s changed: %d fetches, want %d", tt.name, n, len(tt.want))
+		}
+	}
+}
+
+func TestCrawlChangedNotModified(t *testing.T) {
+	_, srv := newETagSite(t, map[string]string{
+		"/":  `<a href="/a">a</a>`,
+		"/a": `a page`,
+	})
+	cfg := CrawlConfig{Fetcher: NewHTTPFetcher(srv.Client()), Depth: 2}
+	seed := srv.URL + "/"
+
+	results, err := Crawl(context.Background(), seed, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prior := make(map[string]string)
+	for r := range results {
+		prior[r.URL] = r.BodyHash
+	}
+
+	// the fetcher's validators have the seed come back a 304
+	results, err = CrawlChanged(context.Background(), seed, cfg, prior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []CrawlResult
+	for r := range results {
+		got = append(got, r)
+	}
+	if len(got) != 1 || got[0].URL != seed {
+		t.Fatalf("crawled %d pages, want only the seed: %+v", len(got), got)
+	}
+	if r := got[0]; r.StatusCode != http.StatusNotModified || !r.Unchanged || r.BodyHash != prior[seed] {
+		t.Errorf("seed: status %d, unchanged %v, hash %q; want 304, unchanged, hash %q",
+			r.StatusCode, r.Unchanged, r.BodyHash, prior[seed])
+	}
+}
+
+func TestWatchNotModified(t *testing.T) {
+	site, srv := newETagSite(t, map[string]string{
+		"/":  `<a href="/a">a</a> <a href="/b">b</a>`,
+		"/a": `a page`,
+		"/b": `b page`,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := CrawlConfig{Fetcher: NewHTTPFetcher(srv.Client()), Depth: 2}
+	diffs, err := Watch(ctx, []string{srv.URL + "/"}, cfg, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := <-diffs; len(d.Added) != 3 {
+		t.Fatalf("first diff added %q, want all 3 pages", d.Added)
+	}
+
+	// let a few crawls find the pages unchanged, all 304s
+	time.Sleep(50 * time.Millisecond)
+	site.mu.Lock()
+	site.pages["/b"] = `b page, changed`
+	site.mu.Unlock()
+
+	d := <-diffs
+	want := []string{srv.URL + "/b"}
+	if d.Added != nil || d.Removed != nil || !slices.Equal(d.Changed, want) {
+		t.Errorf("diff after changing /b: %+v, want only %q changed", d, want)
+	}
+}